@@ -0,0 +1,65 @@
+package main
+
+// 创建首个管理员账号的命令 go run ./cmd/createadmin -email=... -password=...
+// 如果邮箱已经注册过 则直接将该账号提升为管理员
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"log"
+	"os"
+
+	"SnippetBox.mikudayo.net/internal/models"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+func main() {
+	dsn := flag.String("dsn", "web:pass@/snippetbox?parseTime=true", "MySql data source name")
+	name := flag.String("name", "admin", "Admin display name")
+	email := flag.String("email", "", "Admin email")
+	password := flag.String("password", "", "Admin password (unused if the account already exists)")
+	flag.Parse()
+
+	infolog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
+	errlog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+
+	if !models.EmailRX.MatchString(*email) {
+		errlog.Fatal("a valid -email is required")
+	}
+	// 与注册页面使用同样的密码策略
+	var v models.Validator
+	if err := v.CheckPassword(models.DefaultPasswordPolicy, "password", *password, *name, *email); err != nil {
+		errlog.Fatal(err)
+	}
+	if !v.Valid() {
+		errlog.Fatal(v.FieldErrors["password"])
+	}
+
+	db, err := sql.Open("mysql", *dsn)
+	if err != nil {
+		errlog.Fatal(err)
+	}
+	defer db.Close()
+	if err = db.Ping(); err != nil {
+		errlog.Fatal(err)
+	}
+
+	users := &models.UserModel{DB: db}
+	_, err = users.Insert(*name, *email, *password)
+	switch {
+	case errors.Is(err, models.ErrDuplicateEmail):
+		// 账号已经存在 只修改角色
+		infolog.Printf("user %s already exists, promoting to admin", *email)
+	case err != nil:
+		errlog.Fatal(err)
+	default:
+		infolog.Printf("created user %s", *email)
+	}
+	err = users.SetRoleByEmail(*email, models.RoleAdmin)
+	if err != nil {
+		errlog.Fatal(err)
+	}
+	infolog.Printf("%s is now an admin", *email)
+}
@@ -0,0 +1,72 @@
+package main
+
+// 使用当前的主密钥重新包装所有snippet的数据密钥 go run ./cmd/rotatekeys -master-key-file=...
+// 密钥文件的第一行是新的主密钥 其余的旧主密钥用于解开原来的数据密钥
+// 启用静态加密之前保存的明文同样会被加密 运行期间服务可以正常使用
+
+import (
+	"database/sql"
+	"flag"
+	"log"
+	"os"
+
+	"SnippetBox.mikudayo.net/internal/models"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+func main() {
+	dsn := flag.String("dsn", "web:pass@/snippetbox?parseTime=true", "MySql data source name")
+	masterKey := flag.String("master-key", "", "Base64 encoded 32-byte master key")
+	masterKeyFile := flag.String("master-key-file", "", "File with one base64 master key per line, the first one is the new master key")
+	encryptedSearch := flag.String("encrypted-search", "", "Same as the web server: titles or content")
+	batch := flag.Int("batch", 100, "Number of snippets processed per batch")
+	flag.Parse()
+
+	infolog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
+	errlog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+
+	keyring, err := models.OpenKeyring(*masterKey, *masterKeyFile)
+	if err != nil {
+		errlog.Fatal(err)
+	}
+	if keyring == nil {
+		errlog.Fatal("-master-key or -master-key-file is required")
+	}
+	// 与服务器的设置一致 决定加密之前的明文是否保留用于搜索
+	var searchContent bool
+	switch *encryptedSearch {
+	case "titles":
+	case "content":
+		searchContent = true
+	default:
+		errlog.Fatal("-encrypted-search must be titles or content")
+	}
+	if *batch < 1 {
+		errlog.Fatal("-batch must be positive")
+	}
+
+	db, err := sql.Open("mysql", *dsn)
+	if err != nil {
+		errlog.Fatal(err)
+	}
+	defer db.Close()
+	if err = db.Ping(); err != nil {
+		errlog.Fatal(err)
+	}
+
+	snippets := &models.SnippetModel{DB: db, Keyring: keyring, SearchContent: searchContent}
+	total := 0
+	for {
+		n, err := snippets.RotateKeys(*batch)
+		total += n
+		if err != nil {
+			errlog.Fatalf("%v (%d snippets rotated before the error)", err, total)
+		}
+		if n == 0 {
+			break
+		}
+		infolog.Printf("rotated %d snippets", total)
+	}
+	infolog.Printf("done, all snippets now use master key %s", keyring.CurrentID())
+}
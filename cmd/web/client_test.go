@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"SnippetBox.mikudayo.net/internal/assert"
+	"SnippetBox.mikudayo.net/internal/models"
+	"SnippetBox.mikudayo.net/internal/models/mocks"
+	"SnippetBox.mikudayo.net/pkg/client"
+)
+
+// 使用pkg/client访问由app.routes()创建的测试服务器
+func TestClient(t *testing.T) {
+	app := newTestApplication(t)
+	ts := httptest.NewTLSServer(app.routes())
+	defer ts.Close()
+	ctx := context.Background()
+	newClient := func(t *testing.T) *client.Client {
+		c, err := client.New(ts.URL, ts.Client())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	t.Run("Snippets", func(t *testing.T) {
+		c := newClient(t)
+		snippet, err := c.GetSnippet(ctx, 39)
+		assert.NilError(t, err)
+		assert.Equal(t, snippet.Title, "miku")
+		assert.Equal(t, snippet.Files[1].Name, "Dockerfile")
+
+		_, err = c.GetSnippet(ctx, 1)
+		assert.Equal(t, client.IsStatus(err, http.StatusNotFound), true)
+		_, err = c.GetSnippet(ctx, 44)
+		assert.Equal(t, client.IsStatus(err, http.StatusUnavailableForLegalReasons), true)
+
+		content, err := c.RawFile(ctx, 39, "Dockerfile")
+		assert.NilError(t, err)
+		assert.Equal(t, content, "FROM golang:1.24")
+
+		page, err := c.ListSnippets(ctx, client.ListOptions{})
+		assert.NilError(t, err)
+		assert.Equal(t, page.Snippets[0].ID, 39)
+
+		_, err = c.Search(ctx, client.SearchOptions{Language: "cobol"})
+		var verr *client.ValidationError
+		if !errors.As(err, &verr) {
+			t.Fatalf("got %v; want *client.ValidationError", err)
+		}
+		assert.Equal(t, verr.Fields["language"], "请选择列表中的语言...")
+	})
+
+	t.Run("Auth", func(t *testing.T) {
+		c := newClient(t)
+		_, err := c.CurrentUser(ctx)
+		assert.Equal(t, client.IsStatus(err, http.StatusUnauthorized), true)
+		_, err = c.CreateSnippet(ctx, client.NewSnippet{Title: "miku"})
+		assert.Equal(t, client.IsStatus(err, http.StatusUnauthorized), true)
+
+		err = c.Login(ctx, "miku@vocaloid.com", "mikudayo")
+		var verr *client.ValidationError
+		if !errors.As(err, &verr) {
+			t.Fatalf("got %v; want *client.ValidationError", err)
+		}
+		assert.Equal(t, verr.NonField[0], "邮箱或密码错误...")
+
+		assert.NilError(t, c.Login(ctx, "miku@vocaloid.com", "mikudayo3939"))
+		user, err := c.CurrentUser(ctx)
+		assert.NilError(t, err)
+		assert.Equal(t, user.ID, 39)
+		assert.Equal(t, user.Email, "miku@vocaloid.com")
+
+		id, err := c.CreateSnippet(ctx, client.NewSnippet{
+			Title: "miku",
+			Files: []client.File{{Name: "main.go", Language: "go", Content: "mikudayo"}},
+			Tags:  []string{"k8s"},
+		})
+		assert.NilError(t, err)
+		assert.Equal(t, id, 2)
+		_, err = c.CreateSnippet(ctx, client.NewSnippet{Files: []client.File{{Content: "mikudayo"}}})
+		if !errors.As(err, &verr) {
+			t.Fatalf("got %v; want *client.ValidationError", err)
+		}
+		assert.Equal(t, verr.Fields["title"], "标题不能为空...")
+
+		assert.NilError(t, c.StarSnippet(ctx, 40, true))
+		assert.NilError(t, c.ReportSnippet(ctx, 40, models.ReportSpam, ""))
+		err = c.ReportSnippet(ctx, 40, "miku", "")
+		assert.Equal(t, client.IsStatus(err, http.StatusBadRequest), true)
+
+		events, err := c.SecurityEvents(ctx)
+		assert.NilError(t, err)
+		assert.Equal(t, events[0].Action, models.AuditSnippetCreated)
+
+		// 普通用户不能访问管理的接口
+		_, err = c.ListUsers(ctx, "")
+		assert.Equal(t, client.IsStatus(err, http.StatusForbidden), true)
+
+		assert.NilError(t, c.Logout(ctx))
+		_, err = c.CurrentUser(ctx)
+		assert.Equal(t, client.IsStatus(err, http.StatusUnauthorized), true)
+	})
+
+	t.Run("Users", func(t *testing.T) {
+		c := newClient(t)
+		assert.NilError(t, c.Login(ctx, "rin@vocaloid.com", "rindayo1227"))
+		users, err := c.ListUsers(ctx, "miku")
+		assert.NilError(t, err)
+		assert.Equal(t, len(users), 1)
+		assert.Equal(t, users[0].ID, 39)
+
+		assert.NilError(t, c.SetUserRole(ctx, 39, models.RoleModerator))
+		err = c.SetUserRole(ctx, 39, "miku")
+		assert.Equal(t, client.IsStatus(err, http.StatusBadRequest), true)
+		err = c.SetUserRole(ctx, 100, models.RoleModerator)
+		assert.Equal(t, client.IsStatus(err, http.StatusNotFound), true)
+		assert.NilError(t, c.SetUserDisabled(ctx, 39, true))
+		assert.NilError(t, c.RequirePasswordReset(ctx, 39))
+
+		audit := app.audit.(*mocks.AuditModel)
+		last := audit.Events[len(audit.Events)-1]
+		assert.Equal(t, last.Action, models.AuditAdminPasswordReset)
+	})
+}
@@ -3,3 +3,12 @@ package main
 type contextKey string
 
 const isAuthenticatedContextKey = contextKey("isAuthenticated")
+
+// 当前登入用户的角色
+const userRoleContextKey = contextKey("userRole")
+
+// 当前登入用户是否被要求修改密码
+const passwordResetRequiredContextKey = contextKey("passwordResetRequired")
+
+// 请求的id 写入请求日志与审计日志
+const requestIDContextKey = contextKey("requestID")
@@ -3,13 +3,23 @@ package main
 // 定义所有的处理器
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"SnippetBox.mikudayo.net/internal/models"
+	"SnippetBox.mikudayo.net/internal/oidc"
 
 	"github.com/julienschmidt/httprouter"
 )
@@ -17,19 +27,81 @@ import (
 // 存储用户输入的消息
 type snippetCreateForm struct {
 	// 告诉解码器去html里找name为`...`的input标签
-	Title   string `form:"title"`
-	Content string `form:"content"`
-	Expires int    `form:"expires"`
+	Title string `form:"title"`
+	// 表单中的文件 对应files[0].name等输入框
+	Files []snippetFileForm `form:"files"`
+	// 只有一个文件时也可以直接提交content与language(旧版表单与脚本)
+	Content  string `form:"content"`
+	Language string `form:"language"`
+	// 点击添加或删除文件的按钮时为add或remove-<序号> 此时只重新渲染表单
+	Action string `form:"action"`
+	// 有效期相关的字段
+	expiryForm
+	// 以逗号或空格分隔的标签
+	Tags string `form:"tags"`
+	// 仅创建者可见
+	Private bool `form:"private"`
+	// 创建分支时原消息的id
+	Parent int `form:"parent"`
+	// 文件内容已经由浏览器端到端加密
+	Encrypted bool `form:"encrypted"`
+	// 检测到密钥时仍然发布
+	AllowSecrets bool `form:"allow_secrets"`
+	// 检测到密钥时不能勾选仍然发布 由服务器设置
+	SecretsBlocked bool `form:"-"`
 	// 将验证器注入要验证的数据中
 	// (类似于继承直接使当前要检验的数据结构拥有验证器所有的字段与方法)
 	models.Validator `form:"-"`
 }
 
+// snippet中的一个文件
+type snippetFileForm struct {
+	Name     string `form:"name"`
+	Language string `form:"language"`
+	Content  string `form:"content"`
+}
+
+// 发表与修改评论的表单 Line为0时不针对某一行
+type commentForm struct {
+	Body             string `form:"body"`
+	File             string `form:"file"`
+	Line             int    `form:"line"`
+	models.Validator `form:"-"`
+}
+
+// 收藏按钮提交的表单 Star为想要的状态 重复提交的结果相同
+type starForm struct {
+	Star bool `form:"star"`
+}
+
+// 有效期的选择 创建snippet与修改有效期时共用
+type expiryForm struct {
+	// 预设的时长 旧版表单中以天为单位的数字 或者custom(自定义时长) at(指定时间) never(永不过期)
+	Expires string `form:"expires"`
+	// 自定义时长的数量与单位
+	ExpiresAmount int    `form:"expires_amount"`
+	ExpiresUnit   string `form:"expires_unit"`
+	// 指定的过期时间(UTC) 格式与datetime-local输入框一致
+	ExpiresAt string `form:"expires_at"`
+}
+
+// 修改snippet的有效期
+type snippetExpiryForm struct {
+	expiryForm
+	models.Validator `form:"-"`
+}
+
 // 存储用户填写的个人信息
 type userSignupForm struct {
-	Name             string `form:"name"`
-	Email            string `form:"email"`
-	Password         string `form:"password"`
+	Name     string `form:"name"`
+	Email    string `form:"email"`
+	Password string `form:"password"`
+	// 工作量证明的挑战与pow.js计算出的solution
+	Challenge  string `form:"pow_challenge"`
+	Solution   string `form:"pow_solution"`
+	Difficulty int    `form:"-"`
+	// 隐藏的输入框 正常用户看不到也不会填写
+	Website          string `form:"website"`
 	models.Validator `form:"-"`
 }
 
@@ -40,27 +112,145 @@ type userLoginForm struct {
 	models.Validator `form:"-"`
 }
 
-// 存储用户的账号信息(这里其实不应该额外定义结构体用于存储账户信息直接重复利用先前存储插入信息的结构体就行)
-type UserAccountInfo struct {
-	Name   string
-	Email  string
-	Joined time.Time
+// 存储用户输入的新昵称
+type userNameUpdateForm struct {
+	Name             string `form:"name"`
+	models.Validator `form:"-"`
+}
+
+// 存储用户输入的新邮箱
+type userEmailUpdateForm struct {
+	Email            string `form:"email"`
+	models.Validator `form:"-"`
 }
 
 // 存储用户输入的密码信息
 type UserPasswordUpdate struct {
-	CurrentPassword  string `form:"currentPD"`
-	NewPassword      string `form:"newPD"`
-	ConfirmPassword  string `form:"confirmPD"`
+	CurrentPassword string `form:"currentPD"`
+	NewPassword     string `form:"newPD"`
+	ConfirmPassword string `form:"confirmPD"`
+	// 为false时是在设置第一个密码 不显示当前密码的输入框
+	HasPassword      bool `form:"-"`
+	models.Validator `form:"-"`
+}
+
+// 存储管理员修改的用户角色
+type adminRoleForm struct {
+	Role             string `form:"role"`
+	models.Validator `form:"-"`
+}
+
+// 存储管理员停用或启用账号的操作
+type adminDisableForm struct {
+	Disabled bool `form:"disabled"`
+}
+
+// 存储要删除的snippet id
+type adminSnippetDeleteForm struct {
+	ID int `form:"id"`
+}
+
+// 存储举报的原因与补充说明
+type snippetReportForm struct {
+	Reason  string `form:"reason"`
+	Details string `form:"details"`
+}
+
+// 存储审核队列中的操作 隐藏时需要选择原因
+type adminReportForm struct {
+	Action string `form:"action"`
+	Reason string `form:"reason"`
+}
+
+// 存储搜索页面的关键字与筛选条件 From与To为yyyy-mm-dd格式的日期
+type snippetSearchForm struct {
+	Query            string `form:"q"`
+	Language         string `form:"language"`
+	Author           string `form:"author"`
+	From             string `form:"from"`
+	To               string `form:"to"`
+	models.Validator `form:"-"`
+}
+
+// 存储管理页面中搜索用户的关键字
+type adminUserSearchForm struct {
+	Query string
+}
+
+// 存储审计日志的筛选条件 From与To为yyyy-mm-dd格式的日期
+type adminAuditForm struct {
+	UserID           string `form:"user_id"`
+	Action           string `form:"action"`
+	IP               string `form:"ip"`
+	From             string `form:"from"`
+	To               string `form:"to"`
 	models.Validator `form:"-"`
 }
 
+// 存储用户注销账号时填写的信息
+type userDeleteForm struct {
+	Password string `form:"password"`
+	// 对已创建的snippet的处理方式 delete或anonymize
+	Snippets string `form:"snippets"`
+	// 为false时需要先设置密码才能注销
+	HasPassword      bool `form:"-"`
+	models.Validator `form:"-"`
+}
+
+// 导出的个人数据 字段名固定便于用户或其他工具读取
+type userDataExport struct {
+	ExportedAt  time.Time          `json:"exported_at"`
+	Profile     userProfileExport  `json:"profile"`
+	Snippets    []snippetExport    `json:"snippets"`
+	Sessions    []sessionExport    `json:"sessions"`
+	AuditEvents []auditEventExport `json:"audit_events"`
+}
+
+type userProfileExport struct {
+	ID     int       `json:"id"`
+	Name   string    `json:"name"`
+	Email  string    `json:"email"`
+	Joined time.Time `json:"joined"`
+}
+
+type snippetExport struct {
+	ID       int          `json:"id"`
+	Title    string       `json:"title"`
+	Content  string       `json:"content"`
+	Files    []fileExport `json:"files"`
+	Private  bool         `json:"private"`
+	ParentID int          `json:"parent_id,omitempty"`
+	// 加密的snippet导出的是密文 需要使用原来的链接中的密钥解密
+	Encrypted bool      `json:"encrypted"`
+	Created   time.Time `json:"created"`
+	Expires   time.Time `json:"expires"`
+}
+
+type fileExport struct {
+	Name     string `json:"name"`
+	Language string `json:"language"`
+	Content  string `json:"content"`
+}
+
+type sessionExport struct {
+	Expires time.Time `json:"expires"`
+	Current bool      `json:"current"`
+}
+
+type auditEventExport struct {
+	Action    string    `json:"action"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Details   string    `json:"details"`
+	Created   time.Time `json:"created"`
+}
+
 // 展示网站的主页面
 func (app *Application) home(w http.ResponseWriter, r *http.Request) {
 	// 指定"/"的逻辑 防止预料之外的访问
 	if r.URL.Path != "/" {
 		// 直接使用not found方法写入信息
-		app.notFound(w)
+		app.notFound(w, r)
 		return
 	}
 
@@ -69,51 +259,221 @@ func (app *Application) home(w http.ResponseWriter, r *http.Request) {
 
 	snippets, err := app.snippets.Latest()
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
+		return
+	}
+	counts, err := app.snippets.TagCounts(maxTagCloud)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	mostStarred, err := app.stars.MostStarred(time.Now().Add(-mostStarredPeriod), maxMostStarred)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
 	}
 	// 先初始化默认数据再初始化查询得到的数据
 	data := app.newTemplateData(r)
 	data.Snippets = snippets
+	data.TagCloud = newTagCloud(counts)
+	data.MostStarred = mostStarred
 	// 使用render()进行home.tmpl.html渲染
-	app.render(w, http.StatusOK, "home.tmpl.html", data)
+	app.render(w, r, http.StatusOK, "home.tmpl.html", data)
 	// w.Write([]byte("mikudayoooo"))
 }
 
-// 展示一个具体的消息页面
-func (app *Application) snippetView(w http.ResponseWriter, r *http.Request) {
-	// 获取url ?后的id用于数据库查询
-	// 抽取为string并用于转换判断是否为正值
-	// http://localhost:3939/snippet/view?id=39
-	// id, err := strconv.Atoi(r.URL.Query().Get("id"))
-	// w.Write([]byte("Display a specific miku..."))
-	// 使用新的方法获取url中的值
+// 分页展示所有未过期的snippet ?after=与?before=为上一页返回的游标 ?size=可以调整每页的数量
+func (app *Application) snippetList(w http.ResponseWriter, r *http.Request) {
+	app.renderSnippetPage(w, r, "/snippets", "")
+}
+
+// 分页展示带有指定标签的snippet
+func (app *Application) tagView(w http.ResponseWriter, r *http.Request) {
 	params := httprouter.ParamsFromContext(r.Context())
-	id, err := strconv.Atoi(params.ByName("id"))
-	if err != nil || id < 0 {
-		// http.NotFound(w, r)
-		app.notFound(w)
+	// 与创建时一样进行规范化 /tag/K8s与/tag/k8s是同一个标签
+	tags, err := models.ParseTags(params.ByName("name"))
+	if err != nil || len(tags) != 1 {
+		app.notFound(w, r)
 		return
 	}
-	snippet, err := app.snippets.Get(id)
+	app.renderSnippetPage(w, r, "/tag/"+url.PathEscape(tags[0]), tags[0])
+}
+
+// 展示标签云
+func (app *Application) tagList(w http.ResponseWriter, r *http.Request) {
+	counts, err := app.snippets.TagCounts(maxTagCloud)
 	if err != nil {
-		// 判断是否是ErrNoRecord 这里要通过包名调用自己定义的错误model.ErrNoRecord
-		if errors.Is(err, models.ErrNoRecord) {
-			app.notFound(w)
+		app.serverError(w, r, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.TagCloud = newTagCloud(counts)
+	app.render(w, r, http.StatusOK, "tags.tmpl.html", data)
+}
+
+// 解析分页参数并渲染一页snippet base为翻页链接使用的路径
+func (app *Application) renderSnippetPage(w http.ResponseWriter, r *http.Request, base, tag string) {
+	query := r.URL.Query()
+	q := models.PageQuery{Size: app.pageSize, Tag: tag}
+	if size := query.Get("size"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil || n < 1 || n > models.MaxPageSize {
+			app.clientError(w, r, http.StatusBadRequest)
+			return
+		}
+		q.Size = n
+	}
+	var err error
+	if after := query.Get("after"); after != "" {
+		q.After, err = models.ParseCursor(after)
+	} else if before := query.Get("before"); before != "" {
+		q.Before, err = models.ParseCursor(before)
+	}
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	page, err := app.snippets.Page(q)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Snippets = page.Snippets
+	data.Tag = tag
+	// 同时通过Link头(RFC 8288)提供翻页地址 便于脚本等客户端使用
+	var links []string
+	if page.Next != nil {
+		data.NextPage = app.pageURL(base, "after", page.Next, q.Size)
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, data.NextPage))
+	}
+	if page.Prev != nil {
+		data.PrevPage = app.pageURL(base, "before", page.Prev, q.Size)
+		links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, data.PrevPage))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+	app.render(w, r, http.StatusOK, "snippets.tmpl.html", data)
+}
+
+// 生成翻页的地址 使用默认分页大小时省略size参数
+func (app *Application) pageURL(base, direction string, c *models.Cursor, size int) string {
+	v := url.Values{}
+	v.Set(direction, c.String())
+	if size != app.pageSize {
+		v.Set("size", strconv.Itoa(size))
+	}
+	return base + "?" + v.Encode()
+}
+
+// 按关键字与筛选条件搜索snippet 没有任何条件时只展示搜索表单
+func (app *Application) snippetSearch(w http.ResponseWriter, r *http.Request) {
+	var form snippetSearchForm
+	err := app.decodeQuery(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	q := models.SearchQuery{
+		Query:    strings.TrimSpace(form.Query),
+		Language: form.Language,
+		Author:   strings.TrimSpace(form.Author),
+	}
+	form.CheckField(form.MaxChars(q.Query, 100), "q", "关键字长度不能超过100个字符...")
+	form.CheckField(q.Language == "" || models.ValidLanguage(q.Language), "language", "请选择列表中的语言...")
+	if form.From != "" {
+		q.From, err = time.Parse(time.DateOnly, form.From)
+		form.CheckField(err == nil, "from", "日期格式应为yyyy-mm-dd...")
+	}
+	if form.To != "" {
+		// 结束日期当天创建的snippet也包含在内
+		to, err := time.Parse(time.DateOnly, form.To)
+		form.CheckField(err == nil, "to", "日期格式应为yyyy-mm-dd...")
+		if err == nil {
+			q.To = to.AddDate(0, 0, 1)
+		}
+	}
+	if !q.From.IsZero() && !q.To.IsZero() {
+		form.CheckField(q.From.Before(q.To), "to", "结束日期不能早于开始日期...")
+	}
+	data := app.newTemplateData(r)
+	data.Form = form
+	if !form.Valid() {
+		app.render(w, r, http.StatusUnprocessableEntity, "search.tmpl.html", data)
+		return
+	}
+	if q != (models.SearchQuery{}) {
+		data.Snippets, err = app.snippets.Search(q)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		data.Searched = true
+	}
+	app.render(w, r, http.StatusOK, "search.tmpl.html", data)
+}
+
+// 展示一个具体的消息页面
+func (app *Application) snippetView(w http.ResponseWriter, r *http.Request) {
+	// 使用新的方法获取url中的值 不存在 私有或者被隐藏时由viewableSnippet直接返回对应的页面
+	snippet, ok := app.viewableSnippet(w, r)
+	if !ok {
+		return
+	}
+	// 只有直接打开消息页面才计数 发表评论出错时重新渲染不计数
+	app.countView(r, snippet)
+	app.renderSnippetView(w, r, http.StatusOK, snippet, commentForm{})
+	// 将搜索到的内容直接输出到响应体
+	// fmt.Fprintf(w, "Display a specific miku %v...", snippet)
+}
+
+// 渲染snippet页面 包括分支与评论 发表评论出错时带着表单重新渲染
+func (app *Application) renderSnippetView(w http.ResponseWriter, r *http.Request, status int, snippet *models.Snippet, form commentForm) {
+	var err error
+	forks := []*models.Snippet{}
+	if snippet.ForkCount > 0 {
+		forks, err = app.snippets.Forks(snippet.ID)
+		if err != nil {
+			app.serverError(w, r, err)
 			return
 		}
-		app.serverError(w, err)
+	}
+	comments, err := app.comments.BySnippet(snippet.ID)
+	if err != nil {
+		app.serverError(w, r, err)
 		return
 	}
+	starred := false
+	var stats *models.ViewStats
+	if userID := app.authenticatedUserID(r); userID != 0 {
+		starred, err = app.stars.Starred(userID, snippet.ID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		// 访问统计只展示给创建者
+		if userID == snippet.UserID {
+			stats, err = app.views.Stats(snippet.ID, viewStatsDays, time.Now())
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+		}
+	}
 	// 初始化用于渲染网页的信息
 	// 取出临时存在于ctx中的数据并删除(一次性使用) 在这里如果信息不存在就会返回空的字符串
 	// flash := app.sessionManager.PopString(r.Context(), "flash")
 	data := app.newTemplateData(r)
 	data.Snippet = snippet
+	data.Forks = forks
+	data.Comments = comments
+	data.Starred = starred
+	data.ViewStats = stats
+	data.Form = form
 	// 将创建成功的消息导入数据用于网页渲染
 	// data.Flash = flash
-	app.render(w, http.StatusOK, "view.tmpl.html", data)
-	// 将搜索到的内容直接输出到响应体
-	// fmt.Fprintf(w, "Display a specific miku %v...", snippet)
+	app.render(w, r, status, "view.tmpl.html", data)
 }
 
 // 展示创建消息的页面
@@ -131,7 +491,7 @@ func (app *Application) snippetCreate(w http.ResponseWriter, r *http.Request) {
 
 	// 	// 一般不会直接调用writeheader与write而是通过别的函数间接调用
 	// 	// http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-	// 	app.clientError(w, http.StatusMethodNotAllowed)
+	// 	app.clientError(w, r, http.StatusMethodNotAllowed)
 	// 	return
 	// }
 
@@ -146,10 +506,11 @@ func (app *Application) snippetCreate(w http.ResponseWriter, r *http.Request) {
 	form := snippetCreateForm{
 		// 处理错误内容返回原网页重新填充的逻辑需要用到结构体存储信息
 		// 在这里初始化初次进入页面看到的内容 如果没有设置这个结构体会因为尝试访问不存在的信息报错
-		Expires: 365,
+		Files:      []snippetFileForm{{Language: "text"}},
+		expiryForm: expiryForm{Expires: "1y", ExpiresAmount: 1, ExpiresUnit: "day"},
 	}
 	data.Form = form
-	app.render(w, http.StatusOK, "create.tmpl.html", data)
+	app.render(w, r, http.StatusOK, "create.tmpl.html", data)
 	// w.Write([]byte("Createa a new miku..."))
 
 }
@@ -161,7 +522,16 @@ func (app *Application) snippetCreatePost(w http.ResponseWriter, r *http.Request
 	// 使用自定的helper公式化解码数据
 	err := app.decodePostForm(r, &form)
 	if err != nil {
-		app.clientError(w, http.StatusBadRequest)
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	// 没有提交文件时将content作为唯一的文件
+	if len(form.Files) == 0 {
+		form.Files = []snippetFileForm{{Language: form.Language, Content: form.Content}}
+	}
+	// 添加或删除文件后重新展示表单 不进行验证
+	if form.Action != "" {
+		app.snippetCreateFormAction(w, r, &form)
 		return
 	}
 	// Get方法在查找不到数据的情况下是会返回空字符串的
@@ -171,267 +541,1475 @@ func (app *Application) snippetCreatePost(w http.ResponseWriter, r *http.Request
 	// 确定title不是空值并且长度小于100 如果失败就直接把错误信息加入map
 	form.CheckField(form.NotBlank(form.Title), "title", "标题不能为空...")
 	form.CheckField(form.MaxChars(form.Title, 100), "title", "标题长度不能超过100个字符...")
-	form.CheckField(form.NotBlank(form.Content), "content", "内容不能为空...")
-	form.CheckField(models.PermittedValue(form.Expires, 3, 7, 365), "expires", "时间必须为1,7,365...")
+	files := app.checkSnippetFiles(&form)
+	app.checkSecrets(&form)
+	expires := app.expiryFromForm(&form.expiryForm, &form.Validator, time.Now())
+	tags, err := models.ParseTags(form.Tags)
+	switch {
+	case errors.Is(err, models.ErrTooManyTags):
+		form.AddFieldError("tags", fmt.Sprintf("标签不能超过%d个...", models.MaxTags))
+	case err != nil:
+		form.AddFieldError("tags", fmt.Sprintf("标签只能包含字母、数字与-_.+ 且不能超过%d个字符...", models.MaxTagLength))
+	}
 	// 检测是否有字段出现错误
 	if !form.Valid() {
 		// 如果有字段出现错误就以原先的输入信息重新渲染网页
 		data := app.newTemplateData(r)
 		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "create.tmpl.html", data)
+		app.render(w, r, http.StatusUnprocessableEntity, "create.tmpl.html", data)
 		return
 	}
-	id, err := app.snippets.Insert(form.Title, form.Content, form.Expires)
+	// 记录下创建者 后续注销账号时据此删除或匿名化
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	var id int
+	if form.Parent != 0 {
+		id, err = app.snippets.Fork(form.Parent, form.Title, files, expires, userID, tags, form.Private, form.Encrypted)
+	} else {
+		id, err = app.snippets.Insert(form.Title, files, expires, userID, tags, form.Private, form.Encrypted)
+	}
 	if err != nil {
-		app.serverError(w, err)
+		// 原消息在编辑分支的过程中过期或被删除
+		if errors.Is(err, models.ErrNoRecord) && form.Parent != 0 {
+			form.AddFieldError("parent", "原消息已经过期或不存在 无法创建分支...")
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, r, http.StatusUnprocessableEntity, "create.tmpl.html", data)
+			return
+		}
+		app.serverError(w, r, err)
 		return
 	}
 
+	details := fmt.Sprintf("snippet_id=%d", id)
+	if form.Parent != 0 {
+		details += fmt.Sprintf(" parent_id=%d", form.Parent)
+	}
+	app.recordAudit(r, userID, models.AuditSnippetCreated, details)
 	// curl -iL -X POST http://localhost:3939/snippet/create
 	// 创建成功后为当前用户的会话添加共享信息(如果key存在则会将原先的信息覆盖掉)
-	app.sessionManager.Put(r.Context(), "flash", "消息创建成功!")
+	if form.Parent != 0 {
+		app.sessionManager.Put(r.Context(), "flash", "分支创建成功!")
+	} else {
+		app.sessionManager.Put(r.Context(), "flash", "消息创建成功!")
+	}
 	// 创建成功后将用户重定向到最新创建的snippet
 	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
 }
 
-// 展示用户的注册页面
-func (app *Application) userSignup(w http.ResponseWriter, r *http.Request) {
-	data := app.newTemplateData(r)
-	// 初始话默认结构体为空值 防止因未传入默认结构体导致网页初始化错误
-	data.Form = userSignupForm{}
-	app.render(w, http.StatusOK, "signup.tmpl.html", data)
-}
-
-// 将用户填写的注册信息发送到后端
-func (app *Application) userSignupPost(w http.ResponseWriter, r *http.Request) {
-	// 定义用于存储输入信息的结构体并尝试向其解码
-	var form userSignupForm
-	err := app.decodePostForm(r, &form)
-	if err != nil {
-		app.clientError(w, http.StatusBadRequest)
+// 以原消息的内容预先填写创建页面 提交后创建一个属于当前用户的分支
+// 过期的消息与其他用户的私有消息返回404
+func (app *Application) snippetFork(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.viewableSnippet(w, r)
+	if !ok {
 		return
 	}
-	// 解码成功检查数据的正确性
-	form.CheckField(form.NotBlank(form.Name), "name", "姓名不能为空...")
-	form.CheckField(form.NotBlank(form.Email), "email", "邮箱不能为空...")
-	form.CheckField(form.Matches(form.Email, models.EmailRX), "email", "输入的邮箱格式错误...")
-	form.CheckField(form.MinChars(form.Password, 8), "password", "密码长度必须大于8...")
-	// 如果填入的字段出现错误就将字段返回给网页重新渲染
-	if !form.Valid() {
-		data := app.newTemplateData(r)
-		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "signup.tmpl.html", data)
+	// 服务器无法读取加密消息的内容
+	if snippet.Encrypted {
+		app.notFound(w, r)
 		return
 	}
-	// 没有出现错误
-	err = app.users.Insert(form.Name, form.Email, form.Password)
-	if err != nil {
-		// 判断错误类型
-		if errors.Is(err, models.ErrDuplicateEmail) {
-			// 在这里返回的已经是将原始错误包装过形成的自定义错误
-			form.AddFieldError("email", "输入的邮箱已经被使用...")
+	form := snippetCreateForm{
+		Title:      snippet.Title,
+		Tags:       strings.Join(snippet.Tags, " "),
+		Private:    snippet.Private,
+		Parent:     snippet.ID,
+		expiryForm: expiryForm{Expires: "1y", ExpiresAmount: 1, ExpiresUnit: "day"},
+	}
+	for _, f := range snippet.Files {
+		form.Files = append(form.Files, snippetFileForm{Name: f.Name, Language: f.Language, Content: f.Content})
+	}
+	data := app.newTemplateData(r)
+	data.Form = form
+	app.render(w, r, http.StatusOK, "create.tmpl.html", data)
+}
 
-			// 为用户重新渲染页面
-			data := app.newTemplateData(r)
-			data.Form = form
-			app.render(w, http.StatusUnprocessableEntity, "signup.tmpl.html", data)
-		} else {
-			app.serverError(w, err)
+// 处理创建表单中添加与删除文件以及预览的按钮
+func (app *Application) snippetCreateFormAction(w http.ResponseWriter, r *http.Request, form *snippetCreateForm) {
+	data := app.newTemplateData(r)
+	switch {
+	case form.Action == "preview":
+		// 在每个Markdown文件下方展示渲染后的效果
+		data.Preview = true
+	case form.Action == "redact":
+		// 将检测到的密钥替换为[REDACTED] 提交前还可以继续修改
+		redacted := 0
+		if app.secrets != nil {
+			for i := range form.Files {
+				var n int
+				form.Files[i].Content, n = app.secrets.Redact(form.Files[i].Content)
+				redacted += n
+			}
 		}
-		// 终止请求
+		data.Flash = fmt.Sprintf("已隐去%d处可能的密钥 请检查后再提交...", redacted)
+	case form.Action == "add":
+		if len(form.Files) < models.MaxFiles {
+			form.Files = append(form.Files, snippetFileForm{Language: "text"})
+		}
+	case strings.HasPrefix(form.Action, "remove-"):
+		i, err := strconv.Atoi(strings.TrimPrefix(form.Action, "remove-"))
+		// 至少保留一个文件
+		if err == nil && i >= 0 && i < len(form.Files) && len(form.Files) > 1 {
+			form.Files = slices.Delete(form.Files, i, i+1)
+		}
+	default:
+		app.clientError(w, r, http.StatusBadRequest)
 		return
 	}
-	// 创建成功了使用session创建flash信息进行提示
-	app.sessionManager.Put(r.Context(), "flash", "注册成功！请登入...")
-
-	// 对网页进行重定向
-	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+	data.Form = *form
+	app.render(w, r, http.StatusOK, "create.tmpl.html", data)
 }
 
-// 展示用户的登录界面
-func (app *Application) userLogin(w http.ResponseWriter, r *http.Request) {
-	// 初始化参数用于网页渲染
-	data := app.newTemplateData(r)
-	data.Form = userLoginForm{}
-	app.render(w, http.StatusOK, "login.tmpl.html", data)
-	// fmt.Fprint(w, "Display a html form for logging in a user...")
+// 逐个验证表单中的文件 错误信息的key为files.<序号>.<字段>
+// 只有一个文件时可以不填文件名 根据语言生成默认的文件名
+func (app *Application) checkSnippetFiles(form *snippetCreateForm) []*models.File {
+	form.CheckField(len(form.Files) <= models.MaxFiles, "files", fmt.Sprintf("文件不能超过%d个...", models.MaxFiles))
+	files := make([]*models.File, 0, len(form.Files))
+	seen := map[string]bool{}
+	for i := range form.Files {
+		f := &form.Files[i]
+		key := fmt.Sprintf("files.%d.", i)
+		f.Name = strings.TrimSpace(f.Name)
+		// 没有选择语言时根据文件名推断
+		if f.Language == "" {
+			f.Language = models.LanguageFromName(f.Name)
+		}
+		if f.Name == "" && len(form.Files) == 1 {
+			f.Name = models.DefaultFileName(f.Language)
+		}
+		form.CheckField(form.NotBlank(f.Name), key+"name", "文件名不能为空...")
+		if f.Name != "" {
+			form.CheckField(models.ValidFileName(f.Name), key+"name", `文件名不能以.开头 也不能包含/\:*?"<>|#%...`)
+		}
+		form.CheckField(!seen[strings.ToLower(f.Name)], key+"name", "文件名不能重复...")
+		seen[strings.ToLower(f.Name)] = true
+		form.CheckField(models.ValidLanguage(f.Language), key+"language", "请选择列表中的语言...")
+		form.CheckField(form.NotBlank(f.Content), key+"content", "内容不能为空...")
+		// 没有启用JavaScript时浏览器会直接提交明文
+		if form.Encrypted && f.Content != "" {
+			form.CheckField(models.ValidCiphertext(f.Content), key+"content", "内容没有被加密 请启用JavaScript后重新提交...")
+		}
+		files = append(files, &models.File{Name: f.Name, Language: f.Language, Content: f.Content})
+	}
+	if form.Encrypted {
+		form.CheckField(models.ContentSize(files) <= models.MaxCiphertextBytes, "files", "所有文件的内容合计不能超过64KB...")
+	} else {
+		form.CheckField(models.ContentSize(files) <= models.MaxContentBytes, "files", "所有文件的内容合计不能超过64KB...")
+	}
+	return files
 }
 
-// 将用户填写的登录信息发送到后端
-func (app *Application) userLoginPost(w http.ResponseWriter, r *http.Request) {
-	// 尝试解码用户填写的数据
-	var form userLoginForm
-	err := app.decodePostForm(r, &form)
-	if err != nil {
-		app.clientError(w, http.StatusBadRequest)
+// 检测文件内容中不小心粘贴的密钥 错误信息的key为files.<序号>.content与secrets
+// 勾选仍然发布时跳过 服务器无法检测端到端加密的内容
+func (app *Application) checkSecrets(form *snippetCreateForm) {
+	if app.secrets == nil || form.Encrypted {
 		return
 	}
-	// 先对输入的数据进行简单的有效性验证
-	form.CheckField(form.NotBlank(form.Email), "email", "邮箱不能为空值...")
-	form.CheckField(form.Matches(form.Email, models.EmailRX), "email", "邮箱格式不正确...")
-	form.CheckField(form.NotBlank(form.Password), "password", "密码不能为空值...")
-	// 如果有错误返回填写的信息重新渲染网页
-	if !form.Valid() {
-		data := app.newTemplateData(r)
-		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "login.tmpl.html", data)
+	form.SecretsBlocked = app.blockSecrets
+	if form.AllowSecrets && !app.blockSecrets {
 		return
 	}
-	// 填写信息的格式都正确进行正式的有效性检测
-	id, err := app.users.Authenticate(form.Email, form.Password)
-	if err != nil {
-		// 判断错误是否是无效数据错误
-		if errors.Is(err, models.ErrInvalidCredentials) {
-			// 将错误信息添加到NonFieldErrors
-			form.AddNonFieldError("邮箱或密码错误...")
-			data := app.newTemplateData(r)
-			data.Form = form
-			app.render(w, http.StatusUnprocessableEntity, "login.tmpl.html", data)
-		} else {
-			app.serverError(w, err)
+	total := 0
+	for i, f := range form.Files {
+		findings := app.secrets.Scan(f.Content)
+		if len(findings) == 0 {
+			continue
 		}
-		return
+		total += len(findings)
+		form.AddFieldError(fmt.Sprintf("files.%d.content", i), secretsMessage(findings))
 	}
-	// 在登陆成功后或者权限等级发生变化后更新session ID
-	err = app.sessionManager.RenewToken(r.Context())
-	if err != nil {
-		app.serverError(w, err)
+	if total == 0 {
 		return
 	}
-	// 验证通过将当前用户的id加入session表示已登入
-	app.sessionManager.Put(r.Context(), "authenticatedUserID", id)
-	// 查看先前是否尝试访问某个页面使用PopString提取出url用于重定向
-	currentURL := app.sessionManager.PopString(r.Context(), "currentURL")
-	if currentURL != "" {
-		// 重定向到原先想访问的url而不是默认重定向到创建消息的页面
-		http.Redirect(w, r, currentURL, http.StatusSeeOther)
+	if app.blockSecrets {
+		form.AddFieldError("secrets", fmt.Sprintf("检测到%d处可能的密钥 请隐去后再提交...", total))
 	} else {
-		// 重定向到创建消息页面表示当前已登录
-		http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+		form.AddFieldError("secrets", fmt.Sprintf("检测到%d处可能的密钥 请隐去或确认后勾选仍然发布...", total))
 	}
+}
 
-	// fmt.Fprint(w, "Authenticate and login the user...")
+// 列出检测到的前3处密钥 只展示密钥的前几个字符
+func secretsMessage(findings []models.SecretFinding) string {
+	parts := make([]string, 0, 3)
+	for _, f := range findings[:min(len(findings), 3)] {
+		parts = append(parts, fmt.Sprintf("第%d行的%s(%s)", f.Line, f.Pattern, f.Masked))
+	}
+	msg := "可能包含密钥: " + strings.Join(parts, "、")
+	if len(findings) > 3 {
+		msg += fmt.Sprintf(" 等%d处", len(findings))
+	}
+	return msg + "..."
 }
 
-// 将用户需要退出的信息发送到后端
-func (app *Application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
-	//app.infolog.Println("renewing token...")
-	// 更新会话ID
-	err := app.sessionManager.RenewToken(r.Context())
-	if err != nil {
-		app.serverError(w, err)
+// 以纯文本返回snippet中的一个文件
+func (app *Application) snippetRaw(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.viewableSnippet(w, r)
+	if !ok {
 		return
 	}
-	// 删除当前登入的AuthenticateUserID
-	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
-	// 添加一个flash消息提示用户成功退出
-	app.sessionManager.Put(r.Context(), "flash", "已成功退出...")
-	// 导航回到主页面
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	f := snippet.File(httprouter.ParamsFromContext(r.Context()).ByName("name"))
+	if f == nil {
+		app.notFound(w, r)
+		return
+	}
+	// 无论文件是什么语言都以纯文本返回 防止浏览器将html等内容当作网页执行
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(f.Content))
 }
 
-// 用于测试的处理器
-func ping(w http.ResponseWriter, r *http.Request) {
-	// 向传入的响应体写入信息
+// 将snippet中的所有文件打包为zip下载
+func (app *Application) snippetDownload(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.viewableSnippet(w, r)
+	if !ok {
+		return
+	}
+	// 先写入缓冲 打包出错时还可以返回500
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, f := range snippet.Files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: f.Name, Method: zip.Deflate, Modified: snippet.Created})
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		if _, err = fw.Write([]byte(f.Content)); err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="snippet-%d.zip"`, snippet.ID))
+	buf.WriteTo(w)
+}
+
+// 发表评论 可以选择针对某个文件的某一行
+func (app *Application) snippetCommentPost(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.viewableSnippet(w, r)
+	if !ok {
+		return
+	}
+	var form commentForm
+	if err := app.decodePostForm(r, &form); err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	app.checkCommentBody(&form)
+	// 服务器不知道加密文件的行数
+	if snippet.Encrypted && form.Line != 0 {
+		form.AddFieldError("line", "加密消息的评论不能针对某一行...")
+	} else if form.Line != 0 {
+		// 只有一个文件时可以不选择文件
+		if form.File == "" && len(snippet.Files) == 1 {
+			form.File = snippet.Files[0].Name
+		}
+		f := snippet.File(form.File)
+		form.CheckField(f != nil, "line", "请选择消息中的文件...")
+		if f != nil {
+			form.CheckField(form.Line >= 1 && form.Line <= f.Lines(), "line", fmt.Sprintf("行号必须在1到%d之间...", f.Lines()))
+		}
+	} else {
+		form.File = ""
+	}
+	if !form.Valid() {
+		app.renderSnippetView(w, r, http.StatusUnprocessableEntity, snippet, form)
+		return
+	}
+	id, err := app.comments.Insert(snippet.ID, app.authenticatedUserID(r), form.File, form.Line, form.Body)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", "评论发表成功!")
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d#comment-%d", snippet.ID, id), http.StatusSeeOther)
+}
+
+// 评论的内容不能为空也不能过长
+func (app *Application) checkCommentBody(form *commentForm) {
+	form.Body = strings.TrimSpace(form.Body)
+	form.CheckField(form.NotBlank(form.Body), "body", "评论不能为空...")
+	form.CheckField(form.MaxChars(form.Body, models.MaxCommentLength), "body", fmt.Sprintf("评论不能超过%d个字符...", models.MaxCommentLength))
+}
+
+// 按照url中的id取出评论与所在的snippet snippet已经过期或者对当前用户不可见时返回404
+func (app *Application) commentFromParams(w http.ResponseWriter, r *http.Request) (*models.Comment, *models.Snippet, bool) {
+	id, err := app.idParam(r)
+	if err != nil {
+		app.notFound(w, r)
+		return nil, nil, false
+	}
+	comment, err := app.comments.Get(id)
+	if err == nil {
+		var snippet *models.Snippet
+		snippet, err = app.snippets.Get(comment.SnippetID)
+		if err == nil {
+			if snippet.Private && snippet.UserID != app.authenticatedUserID(r) {
+				app.notFound(w, r)
+				return nil, nil, false
+			}
+			return comment, snippet, true
+		}
+	}
+	if errors.Is(err, models.ErrNoRecord) {
+		app.notFound(w, r)
+	} else {
+		app.serverError(w, r, err)
+	}
+	return nil, nil, false
+}
+
+// 展示修改评论的页面 只有评论者本人可以修改
+func (app *Application) commentEdit(w http.ResponseWriter, r *http.Request) {
+	comment, snippet, ok := app.commentFromParams(w, r)
+	if !ok {
+		return
+	}
+	if comment.UserID != app.authenticatedUserID(r) {
+		app.clientError(w, r, http.StatusForbidden)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	data.Comment = comment
+	data.Form = commentForm{Body: comment.Body}
+	app.render(w, r, http.StatusOK, "comment.tmpl.html", data)
+}
+
+func (app *Application) commentEditPost(w http.ResponseWriter, r *http.Request) {
+	comment, snippet, ok := app.commentFromParams(w, r)
+	if !ok {
+		return
+	}
+	if comment.UserID != app.authenticatedUserID(r) {
+		app.clientError(w, r, http.StatusForbidden)
+		return
+	}
+	var form commentForm
+	if err := app.decodePostForm(r, &form); err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	app.checkCommentBody(&form)
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Snippet = snippet
+		data.Comment = comment
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "comment.tmpl.html", data)
+		return
+	}
+	if err := app.comments.Update(comment.ID, form.Body); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", "评论已修改!")
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d#comment-%d", snippet.ID, comment.ID), http.StatusSeeOther)
+}
+
+// 删除评论 评论者本人 snippet的创建者与协管员都可以删除
+func (app *Application) commentDeletePost(w http.ResponseWriter, r *http.Request) {
+	comment, snippet, ok := app.commentFromParams(w, r)
+	if !ok {
+		return
+	}
+	userID := app.authenticatedUserID(r)
+	if comment.UserID != userID && snippet.UserID != userID && !models.RoleAtLeast(app.userRole(r), models.RoleModerator) {
+		app.clientError(w, r, http.StatusForbidden)
+		return
+	}
+	if err := app.comments.Delete(comment.ID); err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", "评论已删除...")
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", snippet.ID), http.StatusSeeOther)
+}
+
+// 收藏或取消收藏snippet
+func (app *Application) snippetStarPost(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.viewableSnippet(w, r)
+	if !ok {
+		return
+	}
+	var form starForm
+	if err := app.decodePostForm(r, &form); err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	userID := app.authenticatedUserID(r)
+	var err error
+	if form.Star {
+		err = app.stars.Star(userID, snippet.ID)
+	} else {
+		err = app.stars.Unstar(userID, snippet.ID)
+	}
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", snippet.ID), http.StatusSeeOther)
+}
+
+// 举报snippet 同一个用户只能举报一次 达到数量后自动隐藏等待审核
+func (app *Application) snippetReportPost(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.viewableSnippet(w, r)
+	if !ok {
+		return
+	}
+	var form snippetReportForm
+	if err := app.decodePostForm(r, &form); err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	// 原因只能从列表中选择 说明的长度由输入框限制 不符合时说明请求被篡改过
+	if !models.ValidReportReason(form.Reason) || utf8.RuneCountInString(form.Details) > models.MaxReportDetails {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	userID := app.authenticatedUserID(r)
+	redirect := fmt.Sprintf("/snippet/view/%d", snippet.ID)
+	if snippet.UserID == userID {
+		app.sessionManager.Put(r.Context(), "flash", "不能举报自己的消息...")
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+	hidden, err := app.reports.Insert(snippet.ID, userID, form.Reason, strings.TrimSpace(form.Details))
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateReport) {
+			app.sessionManager.Put(r.Context(), "flash", "你已经举报过这条消息 请等待审核...")
+			http.Redirect(w, r, redirect, http.StatusSeeOther)
+			return
+		}
+		app.serverError(w, r, err)
+		return
+	}
+	if hidden {
+		app.recordAudit(r, userID, models.AuditSnippetAutoHidden, fmt.Sprintf("snippet_id=%d reason=%s", snippet.ID, form.Reason))
+	}
+	app.sessionManager.Put(r.Context(), "flash", "举报已提交 感谢你的反馈...")
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// 展示当前用户收藏的snippet
+func (app *Application) userFavorites(w http.ResponseWriter, r *http.Request) {
+	snippets, err := app.stars.Favorites(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Snippets = snippets
+	app.render(w, r, http.StatusOK, "favorites.tmpl.html", data)
+}
+
+// 展示修改有效期的页面 只有创建者可以访问
+func (app *Application) snippetExpiry(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.ownedSnippet(w, r)
+	if !ok {
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	data.Form = snippetExpiryForm{expiryForm: expiryForm{Expires: "custom", ExpiresAmount: 1, ExpiresUnit: "day"}}
+	app.render(w, r, http.StatusOK, "expiry.tmpl.html", data)
+}
+
+// 延长或缩短snippet的有效期 新的有效期从现在开始计算
+func (app *Application) snippetExpiryPost(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.ownedSnippet(w, r)
+	if !ok {
+		return
+	}
+	var form snippetExpiryForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	expires := app.expiryFromForm(&form.expiryForm, &form.Validator, time.Now())
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Snippet = snippet
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "expiry.tmpl.html", data)
+		return
+	}
+	err = app.snippets.UpdateExpiry(snippet.ID, expires)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", "有效期已更新!")
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", snippet.ID), http.StatusSeeOther)
+}
+
+// 展示用户的注册页面
+func (app *Application) userSignup(w http.ResponseWriter, r *http.Request) {
+	// 初始话默认结构体为空值 防止因未传入默认结构体导致网页初始化错误
+	app.renderSignup(w, r, http.StatusOK, userSignupForm{})
+}
+
+// 渲染注册页面 每次渲染都签发新的挑战 提交过的挑战不能再次使用
+func (app *Application) renderSignup(w http.ResponseWriter, r *http.Request, status int, form userSignupForm) {
+	form.Challenge, form.Difficulty = app.signupPoW.Challenge("signup", time.Now())
+	form.Solution = ""
+	data := app.newTemplateData(r)
+	data.Form = form
+	app.render(w, r, status, "signup.tmpl.html", data)
+}
+
+// 将用户填写的注册信息发送到后端
+func (app *Application) userSignupPost(w http.ResponseWriter, r *http.Request) {
+	// 定义用于存储输入信息的结构体并尝试向其解码
+	var form userSignupForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	// 填写了隐藏输入框的是自动程序 假装注册成功 不提示被拦截的原因
+	if form.Website != "" {
+		app.infolog.Printf("signup honeypot triggered from %s", remoteIP(r))
+		app.sessionManager.Put(r.Context(), "flash", "注册成功！请登入...")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	// 验证工作量证明 没有启用JavaScript时solution为空
+	err = app.signupPoW.Verify("signup", form.Challenge, form.Solution, time.Now())
+	switch {
+	case errors.Is(err, models.ErrChallengeExpired):
+		form.AddFieldError("pow", "人机验证已过期 请重新提交...")
+	case err != nil:
+		form.AddFieldError("pow", "人机验证失败 请启用JavaScript后重新提交...")
+	}
+	// 解码成功检查数据的正确性
+	form.CheckField(form.NotBlank(form.Name), "name", "姓名不能为空...")
+	form.CheckField(form.NotBlank(form.Email), "email", "邮箱不能为空...")
+	form.CheckField(form.Matches(form.Email, models.EmailRX), "email", "输入的邮箱格式错误...")
+	// 按照密码策略检查长度 强度 个人信息与泄露列表
+	err = form.CheckPassword(app.passwordPolicy, "password", form.Password, form.Name, form.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	// 如果填入的字段出现错误就将字段返回给网页重新渲染
+	if !form.Valid() {
+		app.renderSignup(w, r, http.StatusUnprocessableEntity, form)
+		return
+	}
+	// 没有出现错误
+	id, err := app.users.Insert(form.Name, form.Email, form.Password)
+	if err != nil {
+		// 判断错误类型
+		if errors.Is(err, models.ErrDuplicateEmail) {
+			// 在这里返回的已经是将原始错误包装过形成的自定义错误
+			form.AddFieldError("email", "输入的邮箱已经被使用...")
+
+			// 为用户重新渲染页面
+			app.renderSignup(w, r, http.StatusUnprocessableEntity, form)
+		} else {
+			app.serverError(w, r, err)
+		}
+		// 终止请求
+		return
+	}
+	app.recordAudit(r, id, models.AuditSignup, "")
+	// 最近的注册越多 之后的挑战越难
+	app.signupPoW.Record(time.Now())
+	// 创建成功了使用session创建flash信息进行提示
+	app.sessionManager.Put(r.Context(), "flash", "注册成功！请登入...")
+
+	// 对网页进行重定向
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// 展示用户的登录界面
+func (app *Application) userLogin(w http.ResponseWriter, r *http.Request) {
+	// 初始化参数用于网页渲染
+	data := app.newTemplateData(r)
+	data.Form = userLoginForm{}
+	app.render(w, r, http.StatusOK, "login.tmpl.html", data)
+	// fmt.Fprint(w, "Display a html form for logging in a user...")
+}
+
+// 将用户填写的登录信息发送到后端
+func (app *Application) userLoginPost(w http.ResponseWriter, r *http.Request) {
+	// 尝试解码用户填写的数据
+	var form userLoginForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	// 先对输入的数据进行简单的有效性验证
+	form.CheckField(form.NotBlank(form.Email), "email", "邮箱不能为空值...")
+	form.CheckField(form.Matches(form.Email, models.EmailRX), "email", "邮箱格式不正确...")
+	form.CheckField(form.NotBlank(form.Password), "password", "密码不能为空值...")
+	// 如果有错误返回填写的信息重新渲染网页
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "login.tmpl.html", data)
+		return
+	}
+	// 填写信息的格式都正确进行正式的有效性检测
+	id, err := app.users.Authenticate(form.Email, form.Password)
+	if err != nil {
+		// 判断错误是否是无效数据错误
+		if errors.Is(err, models.ErrInvalidCredentials) || errors.Is(err, models.ErrAccountDisabled) {
+			// 将错误信息添加到NonFieldErrors
+			// 邮箱存在时id为尝试登入的账号 记录到该账号的安全记录中
+			if errors.Is(err, models.ErrAccountDisabled) {
+				app.recordAudit(r, id, models.AuditLoginFailed, "reason=disabled")
+				form.AddNonFieldError("账号已被停用...")
+			} else {
+				reason := "reason=invalid_credentials"
+				if id == 0 {
+					reason = "reason=unknown_email"
+				}
+				app.recordAudit(r, id, models.AuditLoginFailed, reason)
+				form.AddNonFieldError("邮箱或密码错误...")
+			}
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, r, http.StatusUnprocessableEntity, "login.tmpl.html", data)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	// 在登陆成功后或者权限等级发生变化后更新session ID
+	err = app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	// 验证通过将当前用户的id加入session表示已登入
+	app.sessionManager.Put(r.Context(), "authenticatedUserID", id)
+	app.recordAudit(r, id, models.AuditLogin, "method=password")
+	// 查看先前是否尝试访问某个页面使用PopString提取出url用于重定向
+	currentURL := app.sessionManager.PopString(r.Context(), "currentURL")
+	if currentURL != "" {
+		// 重定向到原先想访问的url而不是默认重定向到创建消息的页面
+		http.Redirect(w, r, currentURL, http.StatusSeeOther)
+	} else {
+		// 重定向到创建消息页面表示当前已登录
+		http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+	}
+
+	// fmt.Fprint(w, "Authenticate and login the user...")
+}
+
+// OIDC回调地址 需要与在身份提供方注册的地址一致
+func (app *Application) oidcRedirectURL() string {
+	return app.baseURL + "/user/login/oidc/callback"
+}
+
+// 将用户重定向到企业身份提供方进行登入
+func (app *Application) userLoginOIDC(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFound(w, r)
+		return
+	}
+	// state防止CSRF nonce防止ID token重放 verifier用于PKCE
+	state, err := oidc.RandomString()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	verifier, err := oidc.RandomString()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "oidcState", state)
+	app.sessionManager.Put(r.Context(), "oidcNonce", nonce)
+	app.sessionManager.Put(r.Context(), "oidcVerifier", verifier)
+	http.Redirect(w, r, app.oidc.AuthCodeURL(app.oidcRedirectURL(), state, nonce, verifier), http.StatusSeeOther)
+}
+
+// 身份提供方登入完成后的回调 验证ID token后登入对应的本地账号
+func (app *Application) userLoginOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFound(w, r)
+		return
+	}
+	// 每次登入使用的随机值都只能使用一次
+	state := app.sessionManager.PopString(r.Context(), "oidcState")
+	nonce := app.sessionManager.PopString(r.Context(), "oidcNonce")
+	verifier := app.sessionManager.PopString(r.Context(), "oidcVerifier")
+	query := r.URL.Query()
+	if state == "" || query.Get("state") != state {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	// 用户在身份提供方取消了登入或者发生了错误
+	if query.Get("error") != "" || query.Get("code") == "" {
+		app.sessionManager.Put(r.Context(), "flash", "企业账号登入失败...")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	claims, err := app.oidc.Exchange(r.Context(), app.oidcRedirectURL(), query.Get("code"), verifier, nonce)
+	if err != nil {
+		if errors.Is(err, oidc.ErrInvalidToken) || errors.Is(err, oidc.ErrExchange) {
+			app.errlog.Println(err)
+			app.sessionManager.Put(r.Context(), "flash", "企业账号登入失败...")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	id, err := app.users.AuthenticateExternal(app.oidc.Issuer(), claims.Subject, claims.Email, claims.Name, claims.EmailVerified)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidCredentials):
+			app.sessionManager.Put(r.Context(), "flash", "企业账号没有提供经过验证的邮箱...")
+		case errors.Is(err, models.ErrAccountDisabled):
+			app.sessionManager.Put(r.Context(), "flash", "账号已被停用...")
+		case errors.Is(err, models.ErrDuplicateEmail):
+			app.sessionManager.Put(r.Context(), "flash", "该邮箱已经被其他账号使用...")
+		default:
+			app.serverError(w, r, err)
+			return
+		}
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	// 与密码登入一样 登入成功后更新session ID
+	err = app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "authenticatedUserID", id)
+	app.recordAudit(r, id, models.AuditLogin, "method=oidc")
+	currentURL := app.sessionManager.PopString(r.Context(), "currentURL")
+	if currentURL != "" {
+		http.Redirect(w, r, currentURL, http.StatusSeeOther)
+	} else {
+		http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+	}
+}
+
+// 将用户需要退出的信息发送到后端
+func (app *Application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
+	//app.infolog.Println("renewing token...")
+	// 更新会话ID
+	err := app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	// 删除当前登入的AuthenticateUserID
+	id := app.sessionManager.PopInt(r.Context(), "authenticatedUserID")
+	app.recordAudit(r, id, models.AuditLogout, "")
+	// 添加一个flash消息提示用户成功退出
+	app.sessionManager.Put(r.Context(), "flash", "已成功退出...")
+	// 导航回到主页面
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// 用于测试的处理器
+func ping(w http.ResponseWriter, r *http.Request) {
+	// 向传入的响应体写入信息
 	w.Write([]byte("OK"))
 }
 
-// 展示网站详情
-func (app *Application) about(w http.ResponseWriter, r *http.Request) {
+// 展示网站详情
+func (app *Application) about(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	app.render(w, r, http.StatusOK, "about.tmpl.html", data)
+}
+
+func (app *Application) userAccountSetting(w http.ResponseWriter, r *http.Request) {
+	// 像Authenticate中间件一样直接获取int类型的id
+	id := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	// 一次查询获取昵称 邮箱与创建时间
+	user, err := app.users.GetUser(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			// 可能当前账号信息发生了变化 重定向提示用户登入
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	// 将信息传入用于后续网页渲染
+	data := app.newTemplateData(r)
+	data.User = user
+	app.render(w, r, http.StatusOK, "setting.tmpl.html", data)
+}
+
+// 展示当前账号最近的安全记录 包括登入 失败的登入与账号信息的修改
+func (app *Application) userSecurityActivity(w http.ResponseWriter, r *http.Request) {
+	id := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	// 只取出最近的记录 由数据库限制数量
+	events, err := app.audit.Query(models.AuditQuery{UserID: id, Limit: maxSecurityEvents})
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.AuditEvents = events
+	app.render(w, r, http.StatusOK, "security.tmpl.html", data)
+}
+
+func (app *Application) userPasswordUpdate(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.GetUser(app.sessionManager.GetInt(r.Context(), "authenticatedUserID"))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	// 渲染用于修改密码的页面
+	data := app.newTemplateData(r)
+	// 传入空值用于网页的正常渲染
+	data.Form = UserPasswordUpdate{HasPassword: user.HasPassword}
+	app.render(w, r, http.StatusOK, "updatepd.tmpl.html", data)
+}
+func (app *Application) userPasswordUpdatePost(w http.ResponseWriter, r *http.Request) {
+	var form UserPasswordUpdate
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	// 简单检查通过提取当前用户id
+	id := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	// 新密码不能主要由昵称或邮箱组成 需要先取出用户信息
+	user, err := app.users.GetUser(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	// 进行简单的有效性检查 通过外部身份创建的账号设置第一个密码时没有当前的密码
+	form.HasPassword = user.HasPassword
+	if form.HasPassword {
+		form.CheckField(form.NotBlank(form.CurrentPassword), "currentPD", "当前的密码不能为空值...")
+	}
+	// 使用与注册时相同的密码策略
+	err = form.CheckPassword(app.passwordPolicy, "newPD", form.NewPassword, user.Name, user.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	// 比较两次输入的密码是否匹配
+	form.CheckField(models.Confirms(form.NewPassword, form.ConfirmPassword), "confirmPD", "与先前输入的密码不匹配...")
+	// 检查是否发生错误
+	if !form.Valid() {
+		// 对网页进行重新渲染
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "updatepd.tmpl.html", data)
+		// 结束当前请求
+		return
+	}
+	// 检查当前输入的密码是否正确
+	err = app.users.UpdatePassword(form.CurrentPassword, form.NewPassword, id)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			// 账号存在 但是密码不正确s
+			form.AddFieldError("currentPD", "当前密码不正确...")
+			// 使用新的错误信息渲染网页
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, r, http.StatusUnprocessableEntity, "updatepd.tmpl.html", data)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	// 密码更新成功(权限发生变化)进行重定向
+	err = app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+	app.recordAudit(r, id, models.AuditPasswordChanged, "")
+	// 移除当前的登入状态并重定向至登录界面
+	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+	app.sessionManager.Put(r.Context(), "flash", "密码修改成功请重新登入...")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// 展示注销账号的确认页面
+func (app *Application) userAccountDelete(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.GetUser(app.sessionManager.GetInt(r.Context(), "authenticatedUserID"))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
 	data := app.newTemplateData(r)
-	app.render(w, http.StatusOK, "about.tmpl.html", data)
+	// 默认保留内容只进行匿名化
+	data.Form = userDeleteForm{Snippets: "anonymize", HasPassword: user.HasPassword}
+	app.render(w, r, http.StatusOK, "delete.tmpl.html", data)
 }
 
-func (app *Application) userAccountSetting(w http.ResponseWriter, r *http.Request) {
-	// 像Authenticate中间件一样直接获取int类型的id
+// 再次验证密码后注销当前账号
+func (app *Application) userAccountDeletePost(w http.ResponseWriter, r *http.Request) {
+	var form userDeleteForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
 	id := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
-	//app.infolog.Println("current user id:")
-	name, err := app.users.GetName(id)
+	user, err := app.users.GetUser(id)
 	if err != nil {
-		if errors.Is(err, models.ErrNoRecord) {
-			// 可能当前账号信息发生了变化 重定向提示用户登入
-			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
-		}
-		app.serverError(w, err)
+		app.serverError(w, r, err)
+		return
 	}
-	email, err := app.users.GetEmail(id)
+	// 通过外部身份创建的账号没有可以用于确认的密码 需要先设置密码
+	form.HasPassword = user.HasPassword
+	if !form.HasPassword {
+		form.AddNonFieldError("注销账号需要验证密码,请先设置密码...")
+	}
+	form.CheckField(form.NotBlank(form.Password), "password", "密码不能为空值...")
+	form.CheckField(models.PermittedValue(form.Snippets, "delete", "anonymize"), "snippets", "请选择删除或匿名化已创建的消息...")
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "delete.tmpl.html", data)
+		return
+	}
+	err = app.users.Delete(id, form.Password, form.Snippets == "delete")
 	if err != nil {
-		if errors.Is(err, models.ErrNoRecord) {
-			// 可能当前账号信息发生了变化 重定向提示用户登入
-			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			form.AddFieldError("password", "密码不正确...")
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, r, http.StatusUnprocessableEntity, "delete.tmpl.html", data)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	app.recordAudit(r, id, models.AuditAccountDeleted, "snippets="+form.Snippets)
+	// 账号已经不存在 销毁该用户在其他设备上的会话
+	err = app.sessionManager.Iterate(r.Context(), func(ctx context.Context) error {
+		if app.sessionManager.GetInt(ctx, "authenticatedUserID") == id {
+			return app.sessionManager.Destroy(ctx)
 		}
-		app.serverError(w, err)
+		return nil
+	})
+	if err != nil {
+		app.serverError(w, r, err)
+		return
 	}
-	joined, err := app.users.GetJoinedTime(id)
+	// 权限发生变化更新当前会话
+	err = app.sessionManager.RenewToken(r.Context())
 	if err != nil {
-		if errors.Is(err, models.ErrNoRecord) {
-			// 可能当前账号信息发生了变化 重定向提示用户登入
-			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		app.serverError(w, r, err)
+		return
+	}
+	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+	app.sessionManager.Put(r.Context(), "flash", "账号已注销...")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// 将当前用户的个人数据打包为JSON文件下载
+func (app *Application) userDataExport(w http.ResponseWriter, r *http.Request) {
+	id := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	user, err := app.users.GetUser(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	snippets, err := app.snippets.AllByUser(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	// 先记录本次导出 使导出的文件中也包含这一条记录
+	app.recordAudit(r, id, models.AuditDataExported, "")
+	events, err := app.audit.ListByUser(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	export := userDataExport{
+		ExportedAt: time.Now().UTC(),
+		Profile: userProfileExport{
+			ID:     user.ID,
+			Name:   user.Name,
+			Email:  user.Email,
+			Joined: user.Created,
+		},
+		Snippets:    []snippetExport{},
+		Sessions:    []sessionExport{},
+		AuditEvents: []auditEventExport{},
+	}
+	for _, s := range snippets {
+		files := []fileExport{}
+		for _, f := range s.Files {
+			files = append(files, fileExport{Name: f.Name, Language: f.Language, Content: f.Content})
+		}
+		export.Snippets = append(export.Snippets, snippetExport{
+			ID:        s.ID,
+			Title:     s.Title,
+			Content:   s.Content,
+			Files:     files,
+			Private:   s.Private,
+			ParentID:  s.ParentID,
+			Encrypted: s.Encrypted,
+			Created:   s.Created,
+			Expires:   s.Expires,
+		})
+	}
+	// 只导出会话的有效期 不导出会话的token
+	currentToken := app.sessionManager.Token(r.Context())
+	err = app.sessionManager.Iterate(r.Context(), func(ctx context.Context) error {
+		if app.sessionManager.GetInt(ctx, "authenticatedUserID") == id {
+			export.Sessions = append(export.Sessions, sessionExport{
+				Expires: app.sessionManager.Deadline(ctx),
+				Current: app.sessionManager.Token(ctx) == currentToken,
+			})
 		}
-		app.serverError(w, err)
+		return nil
+	})
+	if err != nil {
+		app.serverError(w, r, err)
+		return
 	}
-	UserInfo := &UserAccountInfo{
-		Name:   name,
-		Email:  email,
-		Joined: joined,
+	for _, e := range events {
+		export.AuditEvents = append(export.AuditEvents, auditEventExport{
+			Action:    e.Action,
+			IP:        e.IP,
+			UserAgent: e.UserAgent,
+			Details:   e.Details,
+			Created:   e.Created,
+		})
 	}
-	// 将信息传入用于后续网页渲染
-	data := app.newTemplateData(r)
-	data.User = UserInfo
-	app.render(w, http.StatusOK, "setting.tmpl.html", data)
+
+	js, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	// 以附件的形式返回 浏览器会直接下载文件
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="snippetbox-data.json"`)
+	w.Write(js)
 }
 
-func (app *Application) userPasswordUpdate(w http.ResponseWriter, r *http.Request) {
-	// 渲染用于修改密码的页面
+// 展示修改昵称的页面
+func (app *Application) userNameUpdate(w http.ResponseWriter, r *http.Request) {
+	id := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	user, err := app.users.GetUser(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
 	data := app.newTemplateData(r)
-	// 传入空值用于网页的正常渲染
-	data.Form = UserPasswordUpdate{}
-	app.render(w, http.StatusOK, "updatepd.tmpl.html", data)
+	// 使用当前的昵称填充表单
+	data.Form = userNameUpdateForm{Name: user.Name}
+	app.render(w, r, http.StatusOK, "updatename.tmpl.html", data)
 }
-func (app *Application) userPasswordUpdatePost(w http.ResponseWriter, r *http.Request) {
-	var form UserPasswordUpdate
+
+func (app *Application) userNameUpdatePost(w http.ResponseWriter, r *http.Request) {
+	var form userNameUpdateForm
 	err := app.decodePostForm(r, &form)
 	if err != nil {
-		app.clientError(w, http.StatusBadRequest)
+		app.clientError(w, r, http.StatusBadRequest)
+		return
 	}
-	// 进行简单的有效性检查
-	form.CheckField(form.NotBlank(form.CurrentPassword), "currentPD", "当前的密码不能为空值...")
-	form.CheckField(form.NotBlank(form.NewPassword), "newPD", "新密码长度必须大于8...")
-	// 比较两次输入的密码是否匹配
-	form.CheckField(models.Confirms(form.NewPassword, form.ConfirmPassword), "confirmPD", "与先前输入的密码不匹配...")
-	// 检查是否发生错误
+	form.CheckField(form.NotBlank(form.Name), "name", "姓名不能为空...")
+	form.CheckField(form.MaxChars(form.Name, 255), "name", "姓名长度不能超过255个字符...")
 	if !form.Valid() {
-		// 对网页进行重新渲染
 		data := app.newTemplateData(r)
 		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "updatepd.tmpl.html", data)
-		// 结束当前请求
+		app.render(w, r, http.StatusUnprocessableEntity, "updatename.tmpl.html", data)
 		return
 	}
-	// 简单检查通过提取当前用户id
 	id := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
-	// 检查当前输入的密码是否正确
-	err = app.users.UpdatePassword(form.CurrentPassword, form.NewPassword, id)
+	err = app.users.UpdateName(id, form.Name)
 	if err != nil {
-		if errors.Is(err, models.ErrInvalidCredentials) {
-			// 账号存在 但是密码不正确s
-			form.AddFieldError("currentPD", "当前密码不正确...")
-			// 使用新的错误信息渲染网页
+		app.serverError(w, r, err)
+		return
+	}
+	app.recordAudit(r, id, models.AuditNameUpdated, "")
+	app.sessionManager.Put(r.Context(), "flash", "昵称修改成功!")
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+// 展示修改邮箱的页面
+func (app *Application) userEmailUpdate(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = userEmailUpdateForm{}
+	app.render(w, r, http.StatusOK, "updateemail.tmpl.html", data)
+}
+
+// 向新邮箱发送确认链接 在用户点击链接之前邮箱不会发生变化
+func (app *Application) userEmailUpdatePost(w http.ResponseWriter, r *http.Request) {
+	var form userEmailUpdateForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	form.CheckField(form.NotBlank(form.Email), "email", "邮箱不能为空...")
+	form.CheckField(form.Matches(form.Email, models.EmailRX), "email", "输入的邮箱格式错误...")
+	id := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	user, err := app.users.GetUser(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	form.CheckField(form.Email != user.Email, "email", "与当前的邮箱相同...")
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "updateemail.tmpl.html", data)
+		return
+	}
+	token, err := app.users.RequestEmailChange(id, form.Email)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateEmail) {
+			form.AddFieldError("email", "输入的邮箱已经被使用...")
 			data := app.newTemplateData(r)
 			data.Form = form
-			app.render(w, http.StatusUnprocessableEntity, "updatepd.tmpl.html", data)
+			app.render(w, r, http.StatusUnprocessableEntity, "updateemail.tmpl.html", data)
 		} else {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 		}
 		return
 	}
-	// 密码更新成功(权限发生变化)进行重定向
-	err = app.sessionManager.RenewToken(r.Context())
+	// 向新邮箱发送确认链接
+	link := fmt.Sprintf("%s/account/email/confirm?token=%s", app.baseURL, token)
+	body := fmt.Sprintf("%s 你好:\n\n请在24小时内打开下面的链接确认将SnippetBox账号的邮箱修改为 %s:\n\n%s\n", user.Name, form.Email, link)
+	err = app.mailer.Send(form.Email, "确认你的新邮箱", body)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
+		return
 	}
-	// 移除当前的登入状态并重定向至登录界面
-	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
-	app.sessionManager.Put(r.Context(), "flash", "密码修改成功请重新登入...")
-	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+	// 通知原邮箱 若不是本人操作可以及时发现
+	body = fmt.Sprintf("%s 你好:\n\n有人请求将你的SnippetBox账号邮箱修改为 %s。\n如果这不是你本人的操作,请立即修改密码。\n", user.Name, form.Email)
+	err = app.mailer.Send(user.Email, "账号邮箱修改通知", body)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.recordAudit(r, id, models.AuditEmailChangeRequested, "new_email="+form.Email)
+	app.sessionManager.Put(r.Context(), "flash", "确认邮件已发送到新邮箱,请查收...")
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+// 用户点击邮件中的确认链接后完成邮箱的修改
+func (app *Application) userEmailConfirm(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		app.notFound(w, r)
+		return
+	}
+	id, err := app.users.ConfirmEmailChange(token)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNoRecord):
+			app.sessionManager.Put(r.Context(), "flash", "确认链接无效或已过期...")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+		case errors.Is(err, models.ErrDuplicateEmail):
+			app.sessionManager.Put(r.Context(), "flash", "该邮箱已经被其他账号使用...")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	app.recordAudit(r, id, models.AuditEmailChanged, "")
+	app.sessionManager.Put(r.Context(), "flash", "邮箱修改成功!")
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+// 展示管理页面的首页 协管员及以上的角色可以访问
+func (app *Application) adminDashboard(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	app.render(w, r, http.StatusOK, "admin.tmpl.html", data)
+}
+
+// 列出或搜索用户 只有管理员可以访问
+func (app *Application) adminUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	users, err := app.users.List(query)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.Users = users
+	data.Form = adminUserSearchForm{Query: query}
+	app.render(w, r, http.StatusOK, "adminusers.tmpl.html", data)
+}
+
+// 读取url中的目标用户 管理员不能对自己的账号进行操作 防止把自己锁在外面
+func (app *Application) adminTargetUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	id, err := app.idParam(r)
+	if err != nil {
+		app.notFound(w, r)
+		return nil, false
+	}
+	if id == app.sessionManager.GetInt(r.Context(), "authenticatedUserID") {
+		app.sessionManager.Put(r.Context(), "flash", "不能修改自己的账号...")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return nil, false
+	}
+	user, err := app.users.GetUser(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return nil, false
+	}
+	return user, true
+}
+
+// 停用或重新启用用户的账号
+func (app *Application) adminUserDisablePost(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+	var form adminDisableForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	err = app.users.SetDisabled(user.ID, form.Disabled)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	actor := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	if form.Disabled {
+		app.recordAudit(r, actor, models.AuditAdminUserDisabled, fmt.Sprintf("user_id=%d", user.ID))
+		app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("已停用账号 %s...", user.Email))
+	} else {
+		app.recordAudit(r, actor, models.AuditAdminUserEnabled, fmt.Sprintf("user_id=%d", user.ID))
+		app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("已启用账号 %s...", user.Email))
+	}
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// 要求用户在下次访问时修改密码
+func (app *Application) adminUserResetPost(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+	err := app.users.RequirePasswordReset(user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	actor := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	app.recordAudit(r, actor, models.AuditAdminPasswordReset, fmt.Sprintf("user_id=%d", user.ID))
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("已要求 %s 修改密码...", user.Email))
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// 修改用户的角色
+func (app *Application) adminUserRolePost(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+	var form adminRoleForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	if !models.ValidRole(form.Role) {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	err = app.users.SetRole(user.ID, form.Role)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	actor := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	app.recordAudit(r, actor, models.AuditAdminRoleChanged, fmt.Sprintf("user_id=%d role=%s", user.ID, form.Role))
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("已将 %s 的角色修改为 %s...", user.Email, form.Role))
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// 删除任意的snippet 协管员及以上的角色可以操作
+func (app *Application) adminSnippetDeletePost(w http.ResponseWriter, r *http.Request) {
+	var form adminSnippetDeleteForm
+	err := app.decodePostForm(r, &form)
+	if err != nil || form.ID < 1 {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	id := form.ID
+	err = app.snippets.Delete(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	// 与审核队列中的删除一致 未处理的举报标记为已删除 不会一直留在队列中
+	if _, err = app.reports.Resolve(id, models.ReportDeleted); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	actor := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	app.recordAudit(r, actor, models.AuditAdminSnippetDeleted, fmt.Sprintf("snippet_id=%d", id))
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("消息 #%d 已删除...", id))
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// 展示审核队列 按照snippet列出未处理的举报
+func (app *Application) adminReports(w http.ResponseWriter, r *http.Request) {
+	queue, err := app.reports.Queue()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data := app.newTemplateData(r)
+	data.ReportQueue = queue
+	app.render(w, r, http.StatusOK, "adminreports.tmpl.html", data)
+}
+
+// 处理snippet的所有未处理举报 隐藏或删除snippet 驳回时同时取消自动隐藏
+func (app *Application) adminReportPost(w http.ResponseWriter, r *http.Request) {
+	id, err := app.idParam(r)
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+	var form adminReportForm
+	if err = app.decodePostForm(r, &form); err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	var status, action, flash string
+	switch form.Action {
+	case "hide":
+		if !models.ValidReportReason(form.Reason) {
+			app.clientError(w, r, http.StatusBadRequest)
+			return
+		}
+		err = app.snippets.SetHidden(id, form.Reason)
+		status, action, flash = models.ReportHidden, models.AuditModerationHidden, "消息 #%d 已隐藏..."
+	case "dismiss":
+		err = app.snippets.SetHidden(id, "")
+		status, action, flash = models.ReportDismissed, models.AuditModerationDismissed, "已驳回消息 #%d 的举报..."
+	case "delete":
+		err = app.snippets.Delete(id)
+		status, action, flash = models.ReportDeleted, models.AuditModerationDeleted, "消息 #%d 已删除..."
+	default:
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	// 删除snippet时保留举报记录
+	n, err := app.reports.Resolve(id, status)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	details := fmt.Sprintf("snippet_id=%d reports=%d", id, n)
+	if form.Action == "hide" {
+		details += " reason=" + form.Reason
+	}
+	actor := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	app.recordAudit(r, actor, action, details)
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf(flash, id))
+	http.Redirect(w, r, "/admin/reports", http.StatusSeeOther)
+}
+
+// 恢复被隐藏的snippet 协管员及以上的角色可以操作
+func (app *Application) adminSnippetRestorePost(w http.ResponseWriter, r *http.Request) {
+	var form adminSnippetDeleteForm
+	err := app.decodePostForm(r, &form)
+	if err != nil || form.ID < 1 {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	id := form.ID
+	err = app.snippets.SetHidden(id, "")
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	actor := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	app.recordAudit(r, actor, models.AuditModerationRestored, fmt.Sprintf("snippet_id=%d", id))
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("消息 #%d 已恢复...", id))
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
+}
+
+// 按照条件查询审计日志 只有管理员可以访问
+func (app *Application) adminAudit(w http.ResponseWriter, r *http.Request) {
+	var form adminAuditForm
+	err := app.decodeQuery(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	var q models.AuditQuery
+	if form.UserID != "" {
+		q.UserID, err = strconv.Atoi(form.UserID)
+		form.CheckField(err == nil && q.UserID > 0, "user_id", "用户ID应为正整数...")
+	}
+	q.Action = form.Action
+	form.CheckField(q.Action == "" || models.ValidAuditAction(q.Action), "action", "请选择列表中的操作...")
+	q.IP = strings.TrimSpace(form.IP)
+	form.CheckField(q.IP == "" || net.ParseIP(q.IP) != nil, "ip", "IP地址格式不正确...")
+	if form.From != "" {
+		q.From, err = time.Parse(time.DateOnly, form.From)
+		form.CheckField(err == nil, "from", "日期格式应为yyyy-mm-dd...")
+	}
+	if form.To != "" {
+		// 结束日期当天的记录也包含在内
+		to, err := time.Parse(time.DateOnly, form.To)
+		form.CheckField(err == nil, "to", "日期格式应为yyyy-mm-dd...")
+		if err == nil {
+			q.To = to.AddDate(0, 0, 1)
+		}
+	}
+	if !q.From.IsZero() && !q.To.IsZero() {
+		form.CheckField(q.From.Before(q.To), "to", "结束日期不能早于开始日期...")
+	}
+	data := app.newTemplateData(r)
+	data.Form = form
+	if !form.Valid() {
+		app.render(w, r, http.StatusUnprocessableEntity, "adminaudit.tmpl.html", data)
+		return
+	}
+	data.AuditEvents, err = app.audit.Query(q)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.render(w, r, http.StatusOK, "adminaudit.tmpl.html", data)
 }
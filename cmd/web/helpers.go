@@ -2,25 +2,44 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
 	"runtime/debug"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
+
+	"SnippetBox.mikudayo.net/internal/models"
 
 	"github.com/go-playground/form/v4"
+	"github.com/julienschmidt/httprouter"
 	"github.com/justinas/nosurf"
 )
 
 // 将函数输出错误信息的权限大部分移交给helper(app.errlog,)
 
 // 输出错误信息与栈追踪(在那个goroutine中调用的这个函数)
-func (app *Application) serverError(w http.ResponseWriter, err error) {
+func (app *Application) serverError(w http.ResponseWriter, r *http.Request, err error) {
 	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
 	// 在日志中输出当前调用函数的goroutine
 	// ERROR   2025/02/12 13:56:08 helpers.go:13:
 	// ->ERROR   2025/02/12 13:56:19 handlers.go:36
 	app.errlog.Output(2, trace)
+	// 请求JSON的客户端返回问题详情 只有debug模式才带上错误信息
+	if wantsJSON(r) {
+		detail := ""
+		if app.debugMode {
+			detail = err.Error()
+		}
+		app.writeProblem(w, r, http.StatusInternalServerError, detail)
+		return
+	}
 	// 根据当前是否处于debug模式决定是否输出详细的错误信息
 	if app.debugMode {
 		http.Error(w, trace, http.StatusInternalServerError)
@@ -30,32 +49,42 @@ func (app *Application) serverError(w http.ResponseWriter, err error) {
 }
 
 // 输出客户端(http)的错误信息,一般是由用户自己造成的
-func (app *Application) clientError(w http.ResponseWriter, status int) {
+func (app *Application) clientError(w http.ResponseWriter, r *http.Request, status int) {
+	if wantsJSON(r) {
+		app.writeProblem(w, r, status, "")
+		return
+	}
+	varyAccept(w)
 	http.Error(w, http.StatusText(status), status)
 }
 
 // 返回404notfound 通过包装clientError实现
-func (app *Application) notFound(w http.ResponseWriter) {
-	app.clientError(w, http.StatusNotFound)
+func (app *Application) notFound(w http.ResponseWriter, r *http.Request) {
+	app.clientError(w, r, http.StatusNotFound)
 }
 
-// 用于渲染各个网页
-func (app *Application) render(w http.ResponseWriter, status int, page string, data *TemplateData) {
+// 用于渲染各个网页 请求JSON的客户端返回页面数据的JSON
+func (app *Application) render(w http.ResponseWriter, r *http.Request, status int, page string, data *TemplateData) {
 	// 从模板缓存中获取当前请求页面的模板
 	ts, ok := app.templateCache[page]
 	if !ok {
 		// 若当前请求的页面模板不存在
 		// 定义一个新的错误并报告
 		err := fmt.Errorf("the template %s does not exist", page)
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
+	if wantsJSON(r) {
+		app.writeJSON(w, r, status, "application/json", newJSONPage(page, data))
+		return
+	}
+	varyAccept(w)
 	// 创建一个字节类型的缓冲
 	buf := new(bytes.Buffer)
 	// 将获取到的模板写入缓冲查看是否成功
 	err := ts.ExecuteTemplate(buf, "base", data)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	// 向缓冲写入成功没有报错
@@ -89,6 +118,19 @@ func (app *Application) decodePostForm(r *http.Request, dst any) error {
 	return nil
 }
 
+// 与decodePostForm相同 但是解码的是url中的查询参数 用于GET请求的表单
+func (app *Application) decodeQuery(r *http.Request, dst any) error {
+	err := app.formDecoder.Decode(dst, r.URL.Query())
+	if err != nil {
+		var invalidDecoderError *form.InvalidDecoderError
+		if errors.As(err, &invalidDecoderError) {
+			panic(err)
+		}
+		return err
+	}
+	return nil
+}
+
 // 初始化TemplateData结构体中每个网页都会用上的字段
 func (app *Application) newTemplateData(r *http.Request) *TemplateData {
 	return &TemplateData{
@@ -100,9 +142,167 @@ func (app *Application) newTemplateData(r *http.Request) *TemplateData {
 		IsAuthenticated: app.isAuthenticated(r),
 		// 初始化隐藏在网页中的token
 		CSRFToken: nosurf.Token(r),
+		// 用于决定是否展示管理相关的入口
+		UserRole:    app.userRole(r),
+		OIDCEnabled: app.oidc != nil,
+		// 只有允许时才在表单中提供永不过期的选项
+		AllowNeverExpire: app.allowNeverExpire,
+		// 用于判断当前用户是否是snippet的创建者
+		AuthenticatedUserID: app.authenticatedUserID(r),
+	}
+}
+
+// 返回当前登入用户的id 未登入时返回0
+func (app *Application) authenticatedUserID(r *http.Request) int {
+	if !app.isAuthenticated(r) {
+		return 0
+	}
+	return app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+}
+
+// 预设的有效期 对应表单中的单选项
+var expiryPresets = map[string]struct {
+	n    int
+	unit string
+}{
+	"10m": {10, "minute"},
+	"1h":  {1, "hour"},
+	"1d":  {1, "day"},
+	"1w":  {1, "week"},
+	"1mo": {1, "month"},
+	"1y":  {1, "year"},
+}
+
+// 根据表单计算过期时间 不合法时向v添加字段错误
+func (app *Application) expiryFromForm(f *expiryForm, v *models.Validator, now time.Time) time.Time {
+	const invalid = "有效期必须在1分钟到10年之间..."
+	switch f.Expires {
+	case "never":
+		v.CheckField(app.allowNeverExpire, "expires", "不允许创建永不过期的消息...")
+		return models.NeverExpire
+	case "custom":
+		t, err := models.ExpiryAfter(now, f.ExpiresAmount, f.ExpiresUnit)
+		v.CheckField(err == nil, "expires", invalid)
+		return t
+	case "at":
+		t, err := time.Parse("2006-01-02T15:04", f.ExpiresAt)
+		if err != nil {
+			// 部分浏览器会带上秒
+			t, err = time.Parse("2006-01-02T15:04:05", f.ExpiresAt)
+		}
+		v.CheckField(err == nil && models.ValidExpiry(now, t), "expires", invalid)
+		return t
+	}
+	if p, ok := expiryPresets[f.Expires]; ok {
+		t, _ := models.ExpiryAfter(now, p.n, p.unit)
+		return t
+	}
+	// 兼容旧版表单中以天为单位的数字
+	if days, err := strconv.Atoi(f.Expires); err == nil {
+		t, err := models.ExpiryAfter(now, days, "day")
+		v.CheckField(err == nil, "expires", invalid)
+		return t
+	}
+	v.AddFieldError("expires", "请选择有效期...")
+	return time.Time{}
+}
+
+// 读取url中的snippet 只有创建者可以继续操作 否则返回403
+func (app *Application) ownedSnippet(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	snippet, ok := app.viewableSnippet(w, r)
+	if !ok {
+		return nil, false
+	}
+	if snippet.UserID == 0 || snippet.UserID != app.authenticatedUserID(r) {
+		app.clientError(w, r, http.StatusForbidden)
+		return nil, false
+	}
+	return snippet, true
+}
+
+// 按照url中的id取出当前用户可以查看的snippet 不存在或者是其他用户的私有snippet时直接返回404
+// 被审核隐藏的snippet只有版主可以查看 其他用户看到说明页面
+func (app *Application) viewableSnippet(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	id, err := app.idParam(r)
+	if err != nil {
+		app.notFound(w, r)
+		return nil, false
+	}
+	snippet, err := app.snippets.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return nil, false
+	}
+	// 不暴露私有snippet是否存在
+	if snippet.Private && snippet.UserID != app.authenticatedUserID(r) {
+		app.notFound(w, r)
+		return nil, false
+	}
+	if snippet.HiddenReason != "" && !models.RoleAtLeast(app.userRole(r), models.RoleModerator) {
+		app.snippetRemoved(w, r, snippet)
+		return nil, false
+	}
+	return snippet, true
+}
+
+// 展示snippet已被隐藏的说明页面 因侵犯版权隐藏时返回451 其他原因返回410
+func (app *Application) snippetRemoved(w http.ResponseWriter, r *http.Request, snippet *models.Snippet) {
+	status := http.StatusGone
+	if snippet.HiddenReason == models.ReportCopyright {
+		status = http.StatusUnavailableForLegalReasons
+	}
+	data := app.newTemplateData(r)
+	// 页面中只展示隐藏的原因 不展示标题与内容
+	data.Snippet = &models.Snippet{ID: snippet.ID, HiddenReason: snippet.HiddenReason}
+	app.render(w, r, status, "removed.tmpl.html", data)
+}
+
+// 记录一次对snippet页面的访问 创建者本人与爬虫的访问不计数
+// 已登入或已有会话的访客按照会话去重 其余访客按照IP与User-Agent去重
+func (app *Application) countView(r *http.Request, snippet *models.Snippet) {
+	if snippet.UserID != 0 && snippet.UserID == app.authenticatedUserID(r) {
+		return
+	}
+	if models.IsBot(r.UserAgent()) {
+		return
+	}
+	visitor := "ip:" + remoteIP(r) + "\x00" + r.UserAgent()
+	if token := app.sessionManager.Token(r.Context()); token != "" {
+		visitor = "session:" + token
+	}
+	// 内存中只保存哈希 不保存会话token与IP
+	sum := sha256.Sum256([]byte(visitor))
+	_, full := app.viewBuffer.Add(snippet.ID, hex.EncodeToString(sum[:]), referrerHost(r), time.Now())
+	// 达到上限时只会通知一次 不会为每次访问启动新的goroutine
+	if full {
+		go app.flushViews()
+	}
+}
+
+// 将缓冲中的访问次数写入数据库
+func (app *Application) flushViews() {
+	if err := app.viewBuffer.Flush(time.Now()); err != nil {
+		app.errlog.Println(err)
 	}
 }
 
+// 返回请求来源的域名 直接访问或者来自本站的访问返回空字符串
+func referrerHost(r *http.Request) string {
+	u, err := url.Parse(r.Referer())
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" || strings.EqualFold(u.Host, r.Host) {
+		return ""
+	}
+	return host
+}
+
 // 验证用户是否成功登入
 func (app *Application) isAuthenticated(r *http.Request) bool {
 	// 作为键存入值时 只有使用同样类型的键才能正确检索到这个值
@@ -113,3 +313,77 @@ func (app *Application) isAuthenticated(r *http.Request) bool {
 	}
 	return isAuthenticated
 }
+
+// 返回当前登入用户的角色 未登入时返回空字符串
+func (app *Application) userRole(r *http.Request) string {
+	role, ok := r.Context().Value(userRoleContextKey).(string)
+	if !ok {
+		return ""
+	}
+	return role
+}
+
+// 当前登入用户是否被管理员要求修改密码
+func (app *Application) passwordResetRequired(r *http.Request) bool {
+	required, ok := r.Context().Value(passwordResetRequiredContextKey).(bool)
+	if !ok {
+		return false
+	}
+	return required
+}
+
+// 从url中读取名为id的参数 必须为正整数
+func (app *Application) idParam(r *http.Request) (int, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid id parameter")
+	}
+	return id, nil
+}
+
+// 返回客户端的ip RemoteAddr中包含端口 只保留ip部分
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// 返回assignRequestID生成的请求id 没有经过该中间件时返回空字符串
+func requestID(r *http.Request) string {
+	id, ok := r.Context().Value(requestIDContextKey).(string)
+	if !ok {
+		return ""
+	}
+	return id
+}
+
+// 向审计日志追加一条记录 写入失败只输出错误日志而不影响当前请求
+// userID为操作者 未登入时为0
+func (app *Application) recordAudit(r *http.Request, userID int, action, details string) {
+	event := &models.AuditEvent{
+		UserID:    userID,
+		Action:    action,
+		IP:        remoteIP(r),
+		UserAgent: truncateRunes(r.UserAgent(), maxAuditUserAgent),
+		RequestID: requestID(r),
+		Details:   details,
+	}
+	err := app.audit.Record(event)
+	if err != nil {
+		app.errlog.Output(2, err.Error())
+	}
+}
+
+// 审计日志中user_agent列的长度
+const maxAuditUserAgent = 255
+
+// 按照字符截断字符串 不会截断在多字节字符的中间
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"SnippetBox.mikudayo.net/internal/models"
+)
+
+// 内部工具与人访问相同的页面 请求头中的Accept偏好JSON时返回页面数据的JSON
+// 字段名固定 页面中不存在的字段为null 时间均为UTC
+
+// 判断客户端是否偏好JSON 只有JSON的权重高于text/html时才返回JSON
+// 权重相同时返回网页 浏览器的Accept中通常不会明确列出JSON
+func wantsJSON(r *http.Request) bool {
+	jsonQ, htmlQ := 0.0, 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+		}
+		switch {
+		case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+			jsonQ = max(jsonQ, q)
+		case mediaType == "text/html":
+			htmlQ = max(htmlQ, q)
+		}
+	}
+	return jsonQ > 0 && jsonQ > htmlQ
+}
+
+// 同一个地址会根据Accept返回不同的内容 告知缓存需要区分
+func varyAccept(w http.ResponseWriter) {
+	if !slices.Contains(w.Header().Values("Vary"), "Accept") {
+		w.Header().Add("Vary", "Accept")
+	}
+}
+
+// 将v编码为JSON写入响应
+func (app *Application) writeJSON(w http.ResponseWriter, r *http.Request, status int, contentType string, v any) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	varyAccept(w)
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	w.Write(js)
+}
+
+// RFC 9457中的问题详情 type为about:blank时title即为状态码的说明
+type problemDetails struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance"`
+	// 扩展字段 与请求日志及审计日志中的id一致
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// 向请求JSON的客户端返回错误
+func (app *Application) writeProblem(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	app.writeJSON(w, r, status, "application/problem+json", problemDetails{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		RequestID: requestID(r),
+	})
+}
+
+// 页面的JSON 对应TemplateData中对外有意义的字段
+type jsonPage struct {
+	// 模板的名称 不含.tmpl.html
+	Page          string `json:"page"`
+	Flash         string `json:"flash"`
+	Authenticated bool   `json:"authenticated"`
+	UserID        int    `json:"user_id"`
+	Role          string `json:"role"`
+	// 提交表单时需要与Cookie一起带上
+	CSRFToken   string              `json:"csrf_token"`
+	Snippet     *jsonSnippet        `json:"snippet"`
+	Snippets    []jsonSnippet       `json:"snippets"`
+	Forks       []jsonSnippet       `json:"forks"`
+	MostStarred []jsonSnippet       `json:"most_starred"`
+	Starred     bool                `json:"starred"`
+	Comments    []jsonComment       `json:"comments"`
+	Comment     *jsonComment        `json:"comment"`
+	ViewStats   *jsonViewStats      `json:"view_stats"`
+	User        *jsonUser           `json:"user"`
+	Users       []jsonUser          `json:"users"`
+	Tag         string              `json:"tag"`
+	TagCloud    []jsonTag           `json:"tag_cloud"`
+	NextPage    string              `json:"next_page"`
+	PrevPage    string              `json:"prev_page"`
+	ReportQueue []jsonReportedItem  `json:"report_queue"`
+	AuditEvents []jsonAuditEvent    `json:"audit_events"`
+	Form        *jsonFormValidation `json:"form"`
+}
+
+type jsonSnippet struct {
+	ID      int       `json:"id"`
+	Title   string    `json:"title"`
+	Content string    `json:"content"`
+	Created time.Time `json:"created"`
+	// 永不过期时为null
+	Expires  *time.Time `json:"expires"`
+	UserID   int        `json:"user_id"`
+	Language string     `json:"language"`
+	Tags     []string   `json:"tags"`
+	// 只有单条snippet的页面包含文件
+	Files     []jsonFile `json:"files"`
+	Private   bool       `json:"private"`
+	Encrypted bool       `json:"encrypted"`
+	ParentID  int        `json:"parent_id"`
+	ForkCount int        `json:"fork_count"`
+	Stars     int        `json:"stars"`
+	// 没有被隐藏时为空字符串
+	HiddenReason string `json:"hidden_reason"`
+}
+
+type jsonFile struct {
+	Name     string `json:"name"`
+	Language string `json:"language"`
+	Content  string `json:"content"`
+}
+
+type jsonComment struct {
+	ID        int       `json:"id"`
+	SnippetID int       `json:"snippet_id"`
+	UserID    int       `json:"user_id"`
+	Author    string    `json:"author"`
+	File      string    `json:"file"`
+	Line      int       `json:"line"`
+	Body      string    `json:"body"`
+	Created   time.Time `json:"created"`
+	// 没有编辑过时为null
+	Updated *time.Time `json:"updated"`
+}
+
+type jsonViewStats struct {
+	Total     int               `json:"total"`
+	Daily     []jsonDailyViews  `json:"daily"`
+	Referrers []jsonReferrerHit `json:"referrers"`
+}
+
+type jsonDailyViews struct {
+	Day   string `json:"day"`
+	Views int    `json:"views"`
+}
+
+type jsonReferrerHit struct {
+	Referrer string `json:"referrer"`
+	Views    int    `json:"views"`
+}
+
+// 不包含密码的哈希
+type jsonUser struct {
+	ID                    int       `json:"id"`
+	Name                  string    `json:"name"`
+	Email                 string    `json:"email"`
+	Created               time.Time `json:"created"`
+	Role                  string    `json:"role"`
+	Disabled              bool      `json:"disabled"`
+	PasswordResetRequired bool      `json:"password_reset_required"`
+	HasPassword           bool      `json:"password_set"`
+}
+
+type jsonTag struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type jsonReportedItem struct {
+	SnippetID    int          `json:"snippet_id"`
+	Title        string       `json:"title"`
+	UserID       int          `json:"user_id"`
+	HiddenReason string       `json:"hidden_reason"`
+	Reports      []jsonReport `json:"reports"`
+}
+
+type jsonReport struct {
+	ID      int       `json:"id"`
+	UserID  int       `json:"user_id"`
+	Reason  string    `json:"reason"`
+	Details string    `json:"details"`
+	Status  string    `json:"status"`
+	Created time.Time `json:"created"`
+}
+
+type jsonAuditEvent struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	Action    string    `json:"action"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	RequestID string    `json:"request_id"`
+	Details   string    `json:"details"`
+	Created   time.Time `json:"created"`
+}
+
+// 表单的验证结果 不包含用户填写的内容以免返回密码
+type jsonFormValidation struct {
+	// 字段名与表单中的name一致
+	Errors         map[string]string `json:"errors"`
+	NonFieldErrors []string          `json:"non_field_errors"`
+}
+
+// 嵌入了models.Validator的表单都实现了这个接口
+type formValidator interface {
+	Errors() (map[string]string, []string)
+}
+
+// 将渲染页面使用的数据转换为JSON的结构
+func newJSONPage(page string, data *TemplateData) jsonPage {
+	p := jsonPage{
+		Page:          strings.TrimSuffix(page, ".tmpl.html"),
+		Flash:         data.Flash,
+		Authenticated: data.IsAuthenticated,
+		UserID:        data.AuthenticatedUserID,
+		Role:          data.UserRole,
+		CSRFToken:     data.CSRFToken,
+		Snippets:      jsonSnippets(data.Snippets),
+		Forks:         jsonSnippets(data.Forks),
+		MostStarred:   jsonSnippets(data.MostStarred),
+		Starred:       data.Starred,
+		Tag:           data.Tag,
+		NextPage:      data.NextPage,
+		PrevPage:      data.PrevPage,
+	}
+	if data.Snippet != nil {
+		s := newJSONSnippet(data.Snippet)
+		p.Snippet = &s
+	}
+	if data.Comments != nil {
+		p.Comments = []jsonComment{}
+		for _, c := range data.Comments {
+			p.Comments = append(p.Comments, newJSONComment(c))
+		}
+	}
+	if data.Comment != nil {
+		c := newJSONComment(data.Comment)
+		p.Comment = &c
+	}
+	if data.ViewStats != nil {
+		p.ViewStats = &jsonViewStats{
+			Total:     data.ViewStats.Total,
+			Daily:     []jsonDailyViews{},
+			Referrers: []jsonReferrerHit{},
+		}
+		for _, d := range data.ViewStats.Daily {
+			p.ViewStats.Daily = append(p.ViewStats.Daily, jsonDailyViews{Day: d.Day.Format(time.DateOnly), Views: d.Views})
+		}
+		for _, ref := range data.ViewStats.Referrers {
+			p.ViewStats.Referrers = append(p.ViewStats.Referrers, jsonReferrerHit{Referrer: ref.Referrer, Views: ref.Views})
+		}
+	}
+	if data.User != nil {
+		u := newJSONUser(data.User)
+		p.User = &u
+	}
+	if data.Users != nil {
+		p.Users = []jsonUser{}
+		for _, u := range data.Users {
+			p.Users = append(p.Users, newJSONUser(u))
+		}
+	}
+	if data.TagCloud != nil {
+		p.TagCloud = []jsonTag{}
+		for _, t := range data.TagCloud {
+			p.TagCloud = append(p.TagCloud, jsonTag{Name: t.Name, Count: t.Count})
+		}
+	}
+	if data.ReportQueue != nil {
+		p.ReportQueue = []jsonReportedItem{}
+		for _, item := range data.ReportQueue {
+			reports := []jsonReport{}
+			for _, rep := range item.Reports {
+				reports = append(reports, jsonReport{
+					ID:      rep.ID,
+					UserID:  rep.UserID,
+					Reason:  rep.Reason,
+					Details: rep.Details,
+					Status:  rep.Status,
+					Created: rep.Created.UTC(),
+				})
+			}
+			p.ReportQueue = append(p.ReportQueue, jsonReportedItem{
+				SnippetID:    item.SnippetID,
+				Title:        item.Title,
+				UserID:       item.UserID,
+				HiddenReason: item.HiddenReason,
+				Reports:      reports,
+			})
+		}
+	}
+	if data.AuditEvents != nil {
+		p.AuditEvents = []jsonAuditEvent{}
+		for _, e := range data.AuditEvents {
+			p.AuditEvents = append(p.AuditEvents, jsonAuditEvent{
+				ID:        e.ID,
+				UserID:    e.UserID,
+				Action:    e.Action,
+				IP:        e.IP,
+				UserAgent: e.UserAgent,
+				RequestID: e.RequestID,
+				Details:   e.Details,
+				Created:   e.Created.UTC(),
+			})
+		}
+	}
+	if form, ok := data.Form.(formValidator); ok {
+		fieldErrors, nonFieldErrors := form.Errors()
+		p.Form = &jsonFormValidation{Errors: map[string]string{}, NonFieldErrors: []string{}}
+		for k, v := range fieldErrors {
+			p.Form.Errors[k] = v
+		}
+		p.Form.NonFieldErrors = append(p.Form.NonFieldErrors, nonFieldErrors...)
+	}
+	return p
+}
+
+// 列表为nil时保持null 用于区分页面中不存在的列表与空列表
+func jsonSnippets(snippets []*models.Snippet) []jsonSnippet {
+	if snippets == nil {
+		return nil
+	}
+	list := []jsonSnippet{}
+	for _, s := range snippets {
+		list = append(list, newJSONSnippet(s))
+	}
+	return list
+}
+
+func newJSONSnippet(s *models.Snippet) jsonSnippet {
+	js := jsonSnippet{
+		ID:           s.ID,
+		Title:        s.Title,
+		Content:      s.Content,
+		Created:      s.Created.UTC(),
+		UserID:       s.UserID,
+		Language:     s.Language,
+		Tags:         []string{},
+		Files:        []jsonFile{},
+		Private:      s.Private,
+		Encrypted:    s.Encrypted,
+		ParentID:     s.ParentID,
+		ForkCount:    s.ForkCount,
+		Stars:        s.Stars,
+		HiddenReason: s.HiddenReason,
+	}
+	if !s.Permanent() {
+		expires := s.Expires.UTC()
+		js.Expires = &expires
+	}
+	js.Tags = append(js.Tags, s.Tags...)
+	for _, f := range s.Files {
+		js.Files = append(js.Files, jsonFile{Name: f.Name, Language: f.Language, Content: f.Content})
+	}
+	return js
+}
+
+func newJSONComment(c *models.Comment) jsonComment {
+	jc := jsonComment{
+		ID:        c.ID,
+		SnippetID: c.SnippetID,
+		UserID:    c.UserID,
+		Author:    c.Author,
+		File:      c.File,
+		Line:      c.Line,
+		Body:      c.Body,
+		Created:   c.Created.UTC(),
+	}
+	if !c.Updated.IsZero() {
+		updated := c.Updated.UTC()
+		jc.Updated = &updated
+	}
+	return jc
+}
+
+func newJSONUser(u *models.User) jsonUser {
+	return jsonUser{
+		ID:                    u.ID,
+		Name:                  u.Name,
+		Email:                 u.Email,
+		Created:               u.Created.UTC(),
+		Role:                  u.Role,
+		Disabled:              u.Disabled,
+		PasswordResetRequired: u.PasswordResetRequired,
+		HasPassword:           u.HasPassword,
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"SnippetBox.mikudayo.net/internal/assert"
+)
+
+func TestWantsJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept string
+		want   bool
+	}{
+		{"Empty", "", false},
+		{"Browser", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", false},
+		{"Any", "*/*", false},
+		{"JSON", "application/json", true},
+		{"Problem", "application/problem+json", true},
+		{"JSON preferred", "text/html;q=0.8, application/json", true},
+		{"HTML preferred", "application/json;q=0.5, text/html", false},
+		{"Same weight", "application/json, text/html", false},
+		{"Refused", "application/json;q=0", false},
+		{"Invalid weight", "application/json;q=miku", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Header.Set("Accept", tt.accept)
+			assert.Equal(t, wantsJSON(r), tt.want)
+		})
+	}
+}
@@ -1,16 +1,23 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"crypto/tls"
 	"database/sql"
+	"encoding/base64"
 	"flag"
+	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"os"
 	"time"
 
+	"SnippetBox.mikudayo.net/internal/mailer"
 	"SnippetBox.mikudayo.net/internal/models"
+	"SnippetBox.mikudayo.net/internal/oidc"
+	"SnippetBox.mikudayo.net/internal/ratelimit"
 
 	"github.com/alexedwards/scs/mysqlstore"
 	"github.com/alexedwards/scs/v2"
@@ -26,13 +33,44 @@ type Application struct {
 	// snippet模型 包含数据库连接池与增删改查方法
 	snippets models.SnippetModelInterface
 	// 用户模型 包含数据库连接池与增删改查有效性验证方法
-	users         models.UserModelInterface
+	users models.UserModelInterface
+	// snippet下的评论
+	comments models.CommentModelInterface
+	// 用户收藏的snippet
+	stars models.StarModelInterface
+	// 访问统计 访问次数先在viewBuffer中累计再批量写入
+	views      models.ViewModelInterface
+	viewBuffer *models.ViewBuffer
+	// 用户对snippet的举报
+	reports models.ReportModelInterface
+	// 审计日志 记录账号注销与数据导出等安全相关的操作
+	audit         models.AuditLogger
 	templateCache map[string]*template.Template
 	// 向主程序注入解码依赖便于将用户的输入直接解码到相应的存储结构中去
 	formDecoder *form.Decoder
 	// 载入用于请求共享信息的依赖
 	sessionManager *scs.SessionManager
 	debugMode      bool
+	// 用于发送确认链接与通知的邮件
+	mailer mailer.Mailer
+	// 网站对外的访问地址 用于生成邮件中的链接与OIDC的回调地址
+	baseURL string
+	// 企业身份提供方 未配置时为nil 不启用单点登入
+	oidc *oidc.Provider
+	// 注册与修改密码时使用的密码策略
+	passwordPolicy *models.PasswordPolicy
+	// snippet列表每页默认展示的数量
+	pageSize int
+	// 是否允许创建永不过期的snippet
+	allowNeverExpire bool
+	// 注册时的工作量证明
+	signupPoW *models.ProofOfWork
+	// 限流的后端 为nil时不限流
+	rateLimits ratelimit.Store
+	// 检测snippet内容中的密钥 为nil时不检测
+	secrets *models.SecretScanner
+	// 检测到密钥时不允许勾选仍然发布 只能隐去后再提交
+	blockSecrets bool
 }
 
 func main() {
@@ -43,6 +81,41 @@ func main() {
 	// -addr=:4000指定参数 -help查看当前程序所有的可用参数
 	// 用于开启debug模式
 	debug := flag.Bool("debug", false, "enable debug mode")
+	// 邮件相关的设置 没有指定SMTP服务器时邮件内容只会输出到日志
+	baseURL := flag.String("base-url", "https://localhost:3939", "Public base URL used in email links")
+	smtpHost := flag.String("smtp-host", "", "SMTP host (empty to log emails instead)")
+	smtpPort := flag.Int("smtp-port", 25, "SMTP port")
+	smtpUsername := flag.String("smtp-username", "", "SMTP username")
+	smtpPassword := flag.String("smtp-password", "", "SMTP password")
+	smtpSender := flag.String("smtp-sender", "SnippetBox <no-reply@snippetbox.mikudayo.net>", "SMTP sender")
+	// 单点登入相关的设置 没有指定issuer时不启用
+	oidcIssuer := flag.String("oidc-issuer", "", "OpenID Connect issuer URL (empty to disable SSO)")
+	oidcClientID := flag.String("oidc-client-id", "", "OpenID Connect client ID")
+	oidcClientSecret := flag.String("oidc-client-secret", "", "OpenID Connect client secret")
+	// 密码策略相关的设置
+	passwordMinLength := flag.Int("password-min-length", 8, "Minimum password length")
+	passwordMinEntropy := flag.Float64("password-min-entropy", 30, "Minimum estimated password entropy in bits")
+	breachedDir := flag.String("breached-passwords", "", "Directory of k-anonymity prefix files with breached password hashes")
+	breachedMinCount := flag.Int("breached-min-count", 1, "Minimum breach count for a password to be rejected")
+	pageSize := flag.Int("page-size", models.DefaultPageSize, fmt.Sprintf("Default number of snippets per page (at most %d)", models.MaxPageSize))
+	allowNeverExpire := flag.Bool("allow-never-expire", false, "Allow snippets that never expire")
+	masterKey := flag.String("master-key", "", "Base64 encoded 32-byte master key for encrypting snippet content at rest")
+	masterKeyFile := flag.String("master-key-file", "", "File with one base64 master key per line, the first one is used for new snippets")
+	encryptedSearch := flag.String("encrypted-search", "", "Required with a master key: titles (content is not stored, search matches titles only) or content (content is kept in plaintext for search)")
+	secretScan := flag.String("secret-scan", "warn", "What to do when snippet content looks like it contains secrets (warn, block or off)")
+	secretPatterns := flag.String("secret-patterns", "", "File with extra secret patterns, one \"name min-entropy regexp\" per line")
+	powKey := flag.String("pow-key", "", "Base64 encoded key (at least 32 bytes) for signing signup challenges, random if empty")
+	powMinDifficulty := flag.Int("pow-min-difficulty", 16, "Leading zero bits required by signup challenges when signups are quiet")
+	powMaxDifficulty := flag.Int("pow-max-difficulty", 22, "Leading zero bits required by signup challenges at most")
+	autoHideReports := flag.Int("auto-hide-reports", models.DefaultAutoHideReports, "Hide a snippet for review after this many users report it (0 to disable)")
+	rateLimitStore := flag.String("rate-limit-store", "memory", "Where rate limit buckets are kept (memory, mysql to share them between instances, or off)")
+	viewFlushInterval := flag.Duration("view-flush-interval", 30*time.Second, "How often buffered snippet view counts are written to the database")
+	// 密码哈希相关的设置 修改后旧的哈希会在用户下次登入时重新生成
+	passwordHash := flag.String("password-hash", models.DefaultPasswordHasher.Algorithm, "Password hashing algorithm for new hashes (argon2id or bcrypt)")
+	bcryptCost := flag.Int("bcrypt-cost", models.DefaultPasswordHasher.BcryptCost, "bcrypt cost")
+	argon2Memory := flag.Uint("argon2-memory", uint(models.DefaultPasswordHasher.Argon2.Memory), "Argon2id memory in KiB")
+	argon2Iterations := flag.Uint("argon2-iterations", uint(models.DefaultPasswordHasher.Argon2.Iterations), "Argon2id iterations")
+	argon2Parallelism := flag.Uint("argon2-parallelism", uint(models.DefaultPasswordHasher.Argon2.Parallelism), "Argon2id parallelism")
 	// 使用前解析参数
 	flag.Parse()
 
@@ -74,15 +147,160 @@ func main() {
 	sessionManager.Cookie.Secure = true
 	// 初始化解码器
 	formDecoder := form.NewDecoder()
+	// 初始化邮件发送器
+	var m mailer.Mailer = &mailer.LogMailer{Logger: infolog}
+	if *smtpHost != "" {
+		m = &mailer.SMTPMailer{
+			Host:     *smtpHost,
+			Port:     *smtpPort,
+			Username: *smtpUsername,
+			Password: *smtpPassword,
+			Sender:   *smtpSender,
+		}
+	}
+	// 初始化密码策略 指定了泄露密码列表的目录时一并检查
+	passwordPolicy := &models.PasswordPolicy{
+		MinLength:  *passwordMinLength,
+		MinEntropy: *passwordMinEntropy,
+	}
+	if *breachedDir != "" {
+		passwordPolicy.Breached, err = models.NewBreachedPasswords(*breachedDir, *breachedMinCount)
+		if err != nil {
+			errlog.Fatal(err)
+		}
+	}
+	if *pageSize < 1 || *pageSize > models.MaxPageSize {
+		errlog.Fatalf("-page-size must be between 1 and %d", models.MaxPageSize)
+	}
+	// 初始化密码哈希
+	hasher := &models.PasswordHasher{
+		Algorithm:  *passwordHash,
+		BcryptCost: *bcryptCost,
+		Argon2: models.Argon2Params{
+			Memory:      uint32(*argon2Memory),
+			Iterations:  uint32(*argon2Iterations),
+			Parallelism: uint8(*argon2Parallelism),
+			SaltLength:  models.DefaultPasswordHasher.Argon2.SaltLength,
+			KeyLength:   models.DefaultPasswordHasher.Argon2.KeyLength,
+		},
+	}
+	if err = hasher.Validate(); err != nil {
+		errlog.Fatal(err)
+	}
+	// 启动时读取身份提供方的服务发现文档
+	var provider *oidc.Provider
+	if *oidcIssuer != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		provider, err = oidc.Discover(ctx, &http.Client{Timeout: 10 * time.Second}, *oidcIssuer, *oidcClientID, *oidcClientSecret)
+		cancel()
+		if err != nil {
+			errlog.Fatal(err)
+		}
+	}
+	if *viewFlushInterval <= 0 {
+		errlog.Fatal("-view-flush-interval must be positive")
+	}
+	// 没有配置主密钥时snippet的内容以明文保存
+	keyring, err := models.OpenKeyring(*masterKey, *masterKeyFile)
+	if err != nil {
+		errlog.Fatal(err)
+	}
+	// 静态加密与全文搜索不能兼得 需要明确选择
+	var searchContent bool
+	switch {
+	case keyring == nil:
+		infolog.Println("no master key configured, snippet content is stored in plaintext")
+	case *encryptedSearch == "titles":
+		infolog.Println("snippet content is encrypted at rest and not indexed, search matches titles only")
+	case *encryptedSearch == "content":
+		searchContent = true
+		infolog.Println("snippet files are encrypted at rest, content is kept in plaintext for search and excerpts")
+	default:
+		errlog.Fatal("-encrypted-search must be titles or content when a master key is configured")
+	}
+	// 检测到密钥时默认只警告 可以勾选仍然发布
+	var secrets *models.SecretScanner
+	switch *secretScan {
+	case "warn", "block":
+		patterns, err := models.LoadSecretPatterns(*secretPatterns)
+		if err != nil {
+			errlog.Fatal(err)
+		}
+		secrets = models.NewSecretScanner(patterns...)
+	case "off":
+	default:
+		errlog.Fatal("-secret-scan must be warn, block or off")
+	}
+	// 没有指定密钥时使用随机密钥 重启后之前签发的挑战失效 多个实例需要使用相同的密钥
+	var signupKey []byte
+	if *powKey != "" {
+		signupKey, err = base64.StdEncoding.DecodeString(*powKey)
+		if err != nil {
+			errlog.Fatal(err)
+		}
+	} else {
+		signupKey = make([]byte, models.PoWKeySize)
+		if _, err = rand.Read(signupKey); err != nil {
+			errlog.Fatal(err)
+		}
+	}
+	signupPoW, err := models.NewProofOfWork(signupKey, *powMinDifficulty, *powMaxDifficulty)
+	if err != nil {
+		errlog.Fatal(err)
+	}
+	// 单个实例时在内存中限流 多个实例时共享数据库中的桶
+	var rateLimits ratelimit.Store
+	switch *rateLimitStore {
+	case "memory":
+		rateLimits = ratelimit.NewMemoryStore(ratelimit.DefaultMaxKeys)
+	case "mysql":
+		rateLimits = &models.RateLimitModel{DB: db}
+	case "off":
+	default:
+		errlog.Fatal("-rate-limit-store must be memory, mysql or off")
+	}
+	views := &models.ViewModel{DB: db}
 	app := &Application{
-		errlog:         errlog,
-		infolog:        infolog,
-		snippets:       &models.SnippetModel{DB: db},
-		users:          &models.UserModel{DB: db},
-		templateCache:  cache,
-		formDecoder:    formDecoder,
-		sessionManager: sessionManager,
-		debugMode:      *debug,
+		errlog:           errlog,
+		infolog:          infolog,
+		snippets:         &models.SnippetModel{DB: db, Keyring: keyring, SearchContent: searchContent},
+		users:            &models.UserModel{DB: db, Hasher: hasher},
+		comments:         &models.CommentModel{DB: db},
+		stars:            &models.StarModel{DB: db},
+		reports:          &models.ReportModel{DB: db, AutoHide: *autoHideReports},
+		views:            views,
+		viewBuffer:       models.NewViewBuffer(views),
+		audit:            &models.AuditModel{DB: db},
+		templateCache:    cache,
+		formDecoder:      formDecoder,
+		sessionManager:   sessionManager,
+		debugMode:        *debug,
+		mailer:           m,
+		baseURL:          *baseURL,
+		oidc:             provider,
+		passwordPolicy:   passwordPolicy,
+		pageSize:         *pageSize,
+		allowNeverExpire: *allowNeverExpire,
+		signupPoW:        signupPoW,
+		rateLimits:       rateLimits,
+		secrets:          secrets,
+		blockSecrets:     *secretScan == "block",
+	}
+	// 定期将缓冲的访问次数写入数据库
+	go func() {
+		for range time.Tick(*viewFlushInterval) {
+			app.flushViews()
+		}
+	}()
+	// 定期删除已经回满的限流桶
+	if rateLimits != nil {
+		go func() {
+			for now := range time.Tick(time.Minute) {
+				if err := rateLimits.Evict(now); err != nil {
+					errlog.Println(err)
+				}
+			}
+		}()
 	}
 	// 指定启用椭圆曲线优化服务器的性能
 	// 由于在go1.20只有tls.CurveP256与tls.X25519装配使用了
@@ -2,8 +2,17 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
+
+	"SnippetBox.mikudayo.net/internal/models"
+	"SnippetBox.mikudayo.net/internal/ratelimit"
 
 	"github.com/justinas/nosurf"
 )
@@ -28,12 +37,25 @@ func secureHeaders(next http.Handler) http.Handler {
 func (app *Application) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// 输出请求的具体信息
-		app.infolog.Printf("%s - %s %s %s", r.RemoteAddr, r.Proto, r.Method, r.URL.RequestURI())
+		app.infolog.Printf("%s %s - %s %s %s", requestID(r), r.RemoteAddr, r.Proto, r.Method, r.URL.RequestURI())
 
 		next.ServeHTTP(w, r)
 	})
 }
 
+// 为每个请求生成随机的id 写入响应头X-Request-ID 便于按照id查找请求日志与审计记录
+// 不使用客户端提供的id 防止伪造的id混入审计日志
+func assignRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b := make([]byte, 8)
+		rand.Read(b)
+		id := hex.EncodeToString(b)
+		w.Header().Set("X-Request-ID", id)
+		ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 // 检查是否发生过panic输出人性化的提示
 func (app *Application) recoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -45,7 +67,7 @@ func (app *Application) recoverPanic(next http.Handler) http.Handler {
 				// 向响应体写入链接关闭的消息
 				w.Header().Set("Connection", "close")
 				// 将遇到的错误包装返回
-				app.serverError(w, fmt.Errorf("%s", err))
+				app.serverError(w, r, fmt.Errorf("%s", err))
 			}
 		}()
 		next.ServeHTTP(w, r)
@@ -57,6 +79,11 @@ func (app *Application) requireAuthentication(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 		if !app.isAuthenticated(r) {
+			// 请求JSON的客户端无法跟随重定向登入 直接返回401
+			if wantsJSON(r) {
+				app.clientError(w, r, http.StatusUnauthorized)
+				return
+			}
 			// 将当前用于尝试访问的页面存储到session中
 			// 登入成功后进行重定向到想访问的页面里(login处理器)
 			//currentURL := r.Header.Get("Location")
@@ -70,13 +97,20 @@ func (app *Application) requireAuthentication(next http.Handler) http.Handler {
 		// 不将请求验证信息的记录缓存在用户的浏览器中
 		w.Header().Add("Cache-Control", "no-store")
 
+		// 管理员要求修改密码时 只允许访问修改密码的页面与退出
+		if app.passwordResetRequired(r) && r.URL.Path != "/account/password/update" && r.URL.Path != "/user/logout" {
+			app.sessionManager.Put(r.Context(), "flash", "管理员要求你修改密码...")
+			http.Redirect(w, r, "/account/password/update", http.StatusSeeOther)
+			return
+		}
+
 		// 调用下一个处理器
 		next.ServeHTTP(w, r)
 	})
 }
 
 // 防止CSRF攻击
-func noSurf(next http.Handler) http.Handler {
+func (app *Application) noSurf(next http.Handler) http.Handler {
 	//log.Println("entering nosurf csrf...")
 	csrfHandler := nosurf.New(next)
 	// 设置自定义CSRF Cookie 包含(HttpOnly Path Secure三个字段)
@@ -85,6 +119,10 @@ func noSurf(next http.Handler) http.Handler {
 		Secure:   true,
 		Path:     "/",
 	})
+	// 验证失败时与其他错误的格式一致 请求JSON的客户端同样得到问题详情
+	csrfHandler.SetFailureHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.clientError(w, r, http.StatusBadRequest)
+	}))
 	return csrfHandler
 }
 
@@ -99,16 +137,18 @@ func (app *Application) authenticate(next http.Handler) http.Handler {
 			next.ServeHTTP(w, r)
 			return
 		}
-		// 正确返回了id 查找当前这个id是否在数据库中
-		exists, err := app.users.Exists(id)
-		if err != nil {
-			app.serverError(w, err)
+		// 正确返回了id 查找当前这个id是否在数据库中 同时取出角色与账号状态
+		user, err := app.users.GetUser(id)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, r, err)
 			return
 		}
-		// 如果找到了匹配的用户
+		// 如果找到了匹配的用户并且账号没有被停用
 		// 复制一份ctx加入标识符并传递给当前的r
-		if exists {
+		if err == nil && !user.Disabled {
 			ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+			ctx = context.WithValue(ctx, userRoleContextKey, user.Role)
+			ctx = context.WithValue(ctx, passwordResetRequiredContextKey, user.PasswordResetRequired)
 			// 更新当前r的值
 			r = r.WithContext(ctx)
 		}
@@ -116,3 +156,59 @@ func (app *Application) authenticate(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// 验证当前用户的角色是否满足要求 需要放在requireAuthentication之后使用
+func (app *Application) requireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !models.RoleAtLeast(app.userRole(r), role) {
+				// 已经登入但是权限不足
+				app.clientError(w, r, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// 各个路由的限流策略
+var (
+	// 除静态文件之外的所有请求 每秒100个
+	globalRateLimit = ratelimit.Policy{Name: "global", Interval: 10 * time.Millisecond, Burst: 200}
+	// 提交登入表单 防止猜测密码
+	loginRateLimit = ratelimit.PerMinute("login", 10)
+	// 提交注册表单 每小时10次 同一个网络中的多个用户可能共用ip
+	signupRateLimit = ratelimit.Policy{Name: "signup", Interval: 6 * time.Minute, Burst: 10}
+	// 提交创建表单 添加删除文件与预览同样计数
+	createRateLimit = ratelimit.PerMinute("create", 20)
+	// 提交举报 每分钟10次
+	reportRateLimit = ratelimit.PerMinute("report", 10)
+)
+
+// 按照策略限制请求的频率 已登入时按照用户限制 否则按照ip限制
+// 需要放在authenticate之后 否则都按照ip限制
+func (app *Application) rateLimit(policy ratelimit.Policy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// 没有配置后端时不限流
+			if app.rateLimits == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key := "ip:" + remoteIP(r)
+			if app.isAuthenticated(r) {
+				key = fmt.Sprintf("user:%d", app.sessionManager.GetInt(r.Context(), "authenticatedUserID"))
+			}
+			ok, retryAfter, err := app.rateLimits.Take(policy.Name+":"+key, policy, time.Now())
+			if err != nil {
+				// 后端出错时不限制请求 只输出错误日志
+				app.errlog.Output(2, err.Error())
+			} else if !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				app.clientError(w, r, http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
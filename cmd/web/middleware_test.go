@@ -5,8 +5,13 @@ import (
 	"bytes"
 	"io"
 	"net/http"
+	"net/http/cookiejar"
 	"net/http/httptest"
+	"net/url"
 	"testing"
+	"time"
+
+	"SnippetBox.mikudayo.net/internal/ratelimit"
 )
 
 func TestSecureHeader(t *testing.T) {
@@ -51,3 +56,114 @@ func TestSecureHeader(t *testing.T) {
 	// 判断是否一致
 	assert.Equal(t, string(body), "OK")
 }
+
+func TestAssignRequestID(t *testing.T) {
+	var got string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = requestID(r)
+	})
+	ids := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		rr := httptest.NewRecorder()
+		r, err := http.NewRequest(http.MethodGet, "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 不使用客户端提供的id
+		r.Header.Set("X-Request-ID", "miku")
+		assignRequestID(next).ServeHTTP(rr, r)
+		id := rr.Result().Header.Get("X-Request-ID")
+		assert.Equal(t, len(id), 16)
+		assert.Equal(t, got, id)
+		ids[id] = true
+	}
+	assert.Equal(t, len(ids), 3)
+}
+
+func TestRateLimit(t *testing.T) {
+	app := newTestApplication(t)
+	policy := ratelimit.Policy{Name: "test", Interval: time.Hour, Burst: 2}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+	h := app.rateLimit(policy)(next)
+	request := func(remoteAddr string) *http.Response {
+		rr := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+		h.ServeHTTP(rr, r)
+		return rr.Result()
+	}
+
+	assert.Equal(t, request("192.0.2.1:3939").StatusCode, http.StatusOK)
+	assert.Equal(t, request("192.0.2.1:1227").StatusCode, http.StatusOK)
+	rs := request("192.0.2.1:3939")
+	assert.Equal(t, rs.StatusCode, http.StatusTooManyRequests)
+	assert.Equal(t, rs.Header.Get("Retry-After"), "3600")
+	// 不同的ip使用不同的桶
+	assert.Equal(t, request("192.0.2.2:3939").StatusCode, http.StatusOK)
+
+	// 没有配置后端时不限流
+	app.rateLimits = nil
+	assert.Equal(t, request("192.0.2.1:3939").StatusCode, http.StatusOK)
+}
+
+func TestGlobalRateLimit(t *testing.T) {
+	// 使用较小的上限 路由创建时读取策略
+	defer func(p ratelimit.Policy) { globalRateLimit = p }(globalRateLimit)
+	globalRateLimit = ratelimit.Policy{Name: "global", Interval: time.Hour, Burst: 4}
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// 登入前按照ip计数 登入页面与提交表单各一次
+	ts.login(t, "miku@vocaloid.com", "mikudayo3939")
+	// 登入后按照用户计数 不受ip的桶影响
+	for i := 0; i < globalRateLimit.Burst; i++ {
+		code, _, _ := ts.get(t, "/about")
+		assert.Equal(t, code, http.StatusOK)
+	}
+	code, _, _ := ts.get(t, "/about")
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	// 静态文件不计数
+	for i := 0; i < globalRateLimit.Burst+1; i++ {
+		code, _, _ = ts.get(t, "/static/css/main.css")
+		assert.Equal(t, code, http.StatusOK)
+	}
+
+	// 未登入时使用ip的桶 还剩两个令牌 不需要session的路由同样计数
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.Client().Jar = jar
+	code, _, _ = ts.get(t, "/about")
+	assert.Equal(t, code, http.StatusOK)
+	code, _, _ = ts.get(t, "/ping")
+	assert.Equal(t, code, http.StatusOK)
+	code, _, _ = ts.get(t, "/ping")
+	assert.Equal(t, code, http.StatusTooManyRequests)
+}
+
+func TestLoginRateLimit(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, body)
+
+	form := url.Values{}
+	form.Add("email", "miku@vocaloid.com")
+	form.Add("password", "wrong password")
+	form.Add("csrf_token", csrfToken)
+	for i := 0; i < loginRateLimit.Burst; i++ {
+		code, _, _ := ts.postForm(t, "/user/login", form)
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+	}
+	code, header, _ := ts.postForm(t, "/user/login", form)
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.Equal(t, header.Get("Retry-After"), "6")
+	// 只限制提交登入表单
+	code, _, _ = ts.get(t, "/user/login")
+	assert.Equal(t, code, http.StatusOK)
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"SnippetBox.mikudayo.net/internal/models"
+)
+
+// 描述所有路由的OpenAPI文档 请求与响应的结构从表单与JSON的结构体中生成
+// 新增路由时需要在apiOperations中添加说明 测试会检查与注册的路由是否一致
+
+// 路由的响应类型
+const (
+	// 网页 请求JSON时返回jsonPage
+	respPage = iota
+	// 提交表单 成功后303重定向到结果页面
+	respRedirect
+	respText
+	respZip
+	// 导出的个人数据
+	respExport
+	respStatic
+	// OpenAPI文档本身
+	respSpec
+)
+
+// 一个路由的说明
+type apiOperation struct {
+	Method  string
+	Path    string
+	Tag     string
+	Summary string
+	// 访问需要的角色 为空时不需要登入
+	Access string
+	// 不经过dynamic中间件的路由 没有session与CSRF验证
+	Stateless bool
+	// 查询参数 与表单相同使用form标签
+	Query any
+	// 提交的表单 POST请求都需要额外带上csrf_token
+	Form any
+	// 表单字段或查询参数可以选择的值
+	Enums    map[string][]string
+	Response int
+	// 除200外同样返回页面的状态码 例如被隐藏的snippet
+	PageStatus []int
+}
+
+// 分页的查询参数 只用于生成文档
+type pageQueryParams struct {
+	After  string `form:"after"`
+	Before string `form:"before"`
+	Size   int    `form:"size"`
+}
+
+// 管理页面中搜索用户的查询参数 只用于生成文档
+type adminUserQueryParams struct {
+	Query string `form:"q"`
+}
+
+// 邮件中确认链接的查询参数 只用于生成文档
+type emailConfirmParams struct {
+	Token string `form:"token"`
+}
+
+// 身份提供方回调的查询参数 只用于生成文档
+type oidcCallbackParams struct {
+	State string `form:"state"`
+	Code  string `form:"code"`
+	Error string `form:"error"`
+}
+
+var (
+	roleEnum          = []string{models.RoleUser, models.RoleModerator, models.RoleAdmin}
+	reportActionEnum  = []string{"hide", "dismiss", "delete"}
+	auditActionEnum   = append(append([]string{}, models.AuditActions...), models.AuditCategories...)
+	deleteSnippetEnum = []string{"delete", "anonymize"}
+)
+
+var apiOperations = []apiOperation{
+	{Method: http.MethodGet, Path: "/static/*filepath", Tag: "meta", Summary: "静态文件", Stateless: true, Response: respStatic},
+	{Method: http.MethodGet, Path: "/ping", Tag: "meta", Summary: "检查服务是否正常", Stateless: true, Response: respText},
+	{Method: http.MethodGet, Path: "/api/openapi.json", Tag: "meta", Summary: "本文档", Stateless: true, Response: respSpec},
+	{Method: http.MethodGet, Path: "/", Tag: "snippets", Summary: "主页 最新的snippet与本周收藏最多的snippet"},
+	{Method: http.MethodGet, Path: "/about", Tag: "meta", Summary: "关于页面"},
+	{Method: http.MethodGet, Path: "/snippet/view/:id", Tag: "snippets", Summary: "查看snippet 被隐藏时返回410或451",
+		PageStatus: []int{http.StatusGone, http.StatusUnavailableForLegalReasons}},
+	{Method: http.MethodGet, Path: "/snippet/raw/:id/:name", Tag: "snippets", Summary: "以纯文本查看snippet中的一个文件", Response: respText},
+	{Method: http.MethodGet, Path: "/snippet/download/:id", Tag: "snippets", Summary: "将snippet中的所有文件打包下载", Response: respZip},
+	{Method: http.MethodGet, Path: "/snippets", Tag: "snippets", Summary: "分页列出所有公开的snippet 翻页地址同时在Link头中", Query: pageQueryParams{}},
+	{Method: http.MethodGet, Path: "/search", Tag: "snippets", Summary: "按关键字与条件搜索snippet", Query: snippetSearchForm{},
+		Enums: map[string][]string{"language": models.Languages}, PageStatus: []int{http.StatusUnprocessableEntity}},
+	{Method: http.MethodGet, Path: "/tags", Tag: "snippets", Summary: "标签云"},
+	{Method: http.MethodGet, Path: "/tag/:name", Tag: "snippets", Summary: "分页列出带有标签的snippet", Query: pageQueryParams{}},
+	{Method: http.MethodGet, Path: "/user/signup", Tag: "auth", Summary: "注册页面"},
+	{Method: http.MethodPost, Path: "/user/signup", Tag: "auth", Summary: "注册 需要完成页面中的工作量证明", Form: userSignupForm{}, Response: respRedirect},
+	{Method: http.MethodGet, Path: "/user/login", Tag: "auth", Summary: "登入页面"},
+	{Method: http.MethodPost, Path: "/user/login", Tag: "auth", Summary: "使用邮箱与密码登入", Form: userLoginForm{}, Response: respRedirect},
+	{Method: http.MethodGet, Path: "/user/login/oidc", Tag: "auth", Summary: "跳转到企业身份提供方登入 没有配置时返回404", Response: respRedirect},
+	{Method: http.MethodGet, Path: "/user/login/oidc/callback", Tag: "auth", Summary: "企业身份提供方登入后的回调", Query: oidcCallbackParams{}, Response: respRedirect},
+	{Method: http.MethodGet, Path: "/account/email/confirm", Tag: "account", Summary: "通过邮件中的链接确认新邮箱", Query: emailConfirmParams{}, Response: respRedirect},
+
+	{Method: http.MethodGet, Path: "/snippet/create", Tag: "snippets", Summary: "创建snippet的页面", Access: models.RoleUser},
+	{Method: http.MethodPost, Path: "/snippet/create", Tag: "snippets", Summary: "创建snippet 成功后重定向到新的snippet",
+		Access: models.RoleUser, Form: snippetCreateForm{}, Enums: map[string][]string{"language": models.Languages}, Response: respRedirect},
+	{Method: http.MethodGet, Path: "/snippet/fork/:id", Tag: "snippets", Summary: "以已有的snippet为基础创建分支的页面", Access: models.RoleUser},
+	{Method: http.MethodPost, Path: "/snippet/comment/:id", Tag: "comments", Summary: "发表评论", Access: models.RoleUser, Form: commentForm{}, Response: respRedirect},
+	{Method: http.MethodGet, Path: "/comment/edit/:id", Tag: "comments", Summary: "修改评论的页面 只有评论者可以访问", Access: models.RoleUser},
+	{Method: http.MethodPost, Path: "/comment/edit/:id", Tag: "comments", Summary: "修改评论", Access: models.RoleUser, Form: commentForm{}, Response: respRedirect},
+	{Method: http.MethodPost, Path: "/comment/delete/:id", Tag: "comments", Summary: "删除评论", Access: models.RoleUser, Response: respRedirect},
+	{Method: http.MethodPost, Path: "/snippet/star/:id", Tag: "snippets", Summary: "收藏或取消收藏", Access: models.RoleUser, Form: starForm{}, Response: respRedirect},
+	{Method: http.MethodPost, Path: "/snippet/report/:id", Tag: "snippets", Summary: "举报snippet", Access: models.RoleUser,
+		Form: snippetReportForm{}, Enums: map[string][]string{"reason": models.ReportReasons}, Response: respRedirect},
+	{Method: http.MethodGet, Path: "/account/favorites", Tag: "account", Summary: "收藏的snippet", Access: models.RoleUser},
+	{Method: http.MethodGet, Path: "/snippet/expiry/:id", Tag: "snippets", Summary: "修改有效期的页面 只有创建者可以访问", Access: models.RoleUser},
+	{Method: http.MethodPost, Path: "/snippet/expiry/:id", Tag: "snippets", Summary: "修改有效期", Access: models.RoleUser, Form: snippetExpiryForm{}, Response: respRedirect},
+	{Method: http.MethodPost, Path: "/user/logout", Tag: "auth", Summary: "退出", Access: models.RoleUser, Response: respRedirect},
+	{Method: http.MethodGet, Path: "/account/view", Tag: "account", Summary: "当前账号的信息", Access: models.RoleUser},
+	{Method: http.MethodGet, Path: "/account/password/update", Tag: "account", Summary: "修改密码的页面", Access: models.RoleUser},
+	{Method: http.MethodPost, Path: "/account/password/update", Tag: "account", Summary: "修改密码 还没有设置过密码的账号不需要currentPD", Access: models.RoleUser, Form: UserPasswordUpdate{}, Response: respRedirect},
+	{Method: http.MethodGet, Path: "/account/name/update", Tag: "account", Summary: "修改昵称的页面", Access: models.RoleUser},
+	{Method: http.MethodPost, Path: "/account/name/update", Tag: "account", Summary: "修改昵称", Access: models.RoleUser, Form: userNameUpdateForm{}, Response: respRedirect},
+	{Method: http.MethodGet, Path: "/account/email/update", Tag: "account", Summary: "修改邮箱的页面", Access: models.RoleUser},
+	{Method: http.MethodPost, Path: "/account/email/update", Tag: "account", Summary: "向新邮箱发送确认链接", Access: models.RoleUser, Form: userEmailUpdateForm{}, Response: respRedirect},
+	{Method: http.MethodGet, Path: "/account/delete", Tag: "account", Summary: "注销账号的页面", Access: models.RoleUser},
+	{Method: http.MethodPost, Path: "/account/delete", Tag: "account", Summary: "注销账号", Access: models.RoleUser,
+		Form: userDeleteForm{}, Enums: map[string][]string{"snippets": deleteSnippetEnum}, Response: respRedirect},
+	{Method: http.MethodPost, Path: "/account/export", Tag: "account", Summary: "导出个人数据", Access: models.RoleUser, Response: respExport},
+	{Method: http.MethodGet, Path: "/account/security", Tag: "account", Summary: "当前账号最近的安全记录", Access: models.RoleUser},
+
+	{Method: http.MethodGet, Path: "/admin", Tag: "admin", Summary: "管理页面", Access: models.RoleModerator},
+	{Method: http.MethodPost, Path: "/admin/snippets/delete", Tag: "admin", Summary: "删除任意的snippet", Access: models.RoleModerator, Form: adminSnippetDeleteForm{}, Response: respRedirect},
+	{Method: http.MethodPost, Path: "/admin/snippets/restore", Tag: "admin", Summary: "恢复被隐藏的snippet", Access: models.RoleModerator, Form: adminSnippetDeleteForm{}, Response: respRedirect},
+	{Method: http.MethodGet, Path: "/admin/reports", Tag: "admin", Summary: "举报的审核队列", Access: models.RoleModerator},
+	{Method: http.MethodPost, Path: "/admin/reports/:id", Tag: "admin", Summary: "处理snippet的举报", Access: models.RoleModerator,
+		Form: adminReportForm{}, Enums: map[string][]string{"action": reportActionEnum, "reason": models.ReportReasons}, Response: respRedirect},
+	{Method: http.MethodGet, Path: "/admin/users", Tag: "admin", Summary: "按照昵称或邮箱搜索用户", Access: models.RoleAdmin, Query: adminUserQueryParams{}},
+	{Method: http.MethodPost, Path: "/admin/users/:id/disable", Tag: "admin", Summary: "停用或启用账号", Access: models.RoleAdmin, Form: adminDisableForm{}, Response: respRedirect},
+	{Method: http.MethodPost, Path: "/admin/users/:id/reset", Tag: "admin", Summary: "要求用户修改密码", Access: models.RoleAdmin, Response: respRedirect},
+	{Method: http.MethodPost, Path: "/admin/users/:id/role", Tag: "admin", Summary: "修改用户的角色", Access: models.RoleAdmin,
+		Form: adminRoleForm{}, Enums: map[string][]string{"role": roleEnum}, Response: respRedirect},
+	{Method: http.MethodGet, Path: "/admin/audit", Tag: "admin", Summary: "查询审计日志", Access: models.RoleAdmin, Query: adminAuditForm{},
+		Enums: map[string][]string{"action": auditActionEnum}, PageStatus: []int{http.StatusUnprocessableEntity}},
+}
+
+// 返回OpenAPI文档
+func (app *Application) openAPI(w http.ResponseWriter, r *http.Request) {
+	app.writeJSON(w, r, http.StatusOK, "application/json", app.openAPIDocument())
+}
+
+// 生成OpenAPI文档 map在编码时按照键排序 每次生成的结果相同
+func (app *Application) openAPIDocument() map[string]any {
+	g := &schemaGenerator{schemas: map[string]any{}}
+	pageContent := map[string]any{
+		"application/json": map[string]any{"schema": g.schema(reflect.TypeOf(jsonPage{}))},
+		"text/html":        map[string]any{"schema": map[string]any{"type": "string"}},
+	}
+	problem := g.schema(reflect.TypeOf(problemDetails{}))
+
+	paths := map[string]map[string]any{}
+	// 错误的响应都相同 放在components中引用
+	errorResponses := map[string]any{}
+	for _, op := range apiOperations {
+		path, params := openAPIPath(op.Path)
+		responses := map[string]any{}
+		switch op.Response {
+		case respPage:
+			responses["200"] = map[string]any{"description": "页面", "content": pageContent}
+		case respRedirect:
+			responses["303"] = map[string]any{
+				"description": "操作完成 重定向到结果页面 结果的提示在下一个页面的flash中",
+				"headers":     map[string]any{"Location": map[string]any{"schema": map[string]any{"type": "string"}}},
+			}
+		case respText:
+			responses["200"] = map[string]any{"description": "纯文本", "content": map[string]any{
+				"text/plain": map[string]any{"schema": map[string]any{"type": "string"}},
+			}}
+		case respZip:
+			responses["200"] = map[string]any{"description": "zip压缩包", "content": map[string]any{
+				"application/zip": map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}},
+			}}
+		case respExport:
+			responses["200"] = map[string]any{"description": "个人数据", "content": map[string]any{
+				"application/json": map[string]any{"schema": g.schema(reflect.TypeOf(userDataExport{}))},
+			}}
+		case respStatic:
+			responses["200"] = map[string]any{"description": "文件", "content": map[string]any{
+				"*/*": map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}},
+			}}
+		case respSpec:
+			responses["200"] = map[string]any{"description": "OpenAPI文档", "content": map[string]any{
+				"application/json": map[string]any{"schema": map[string]any{"type": "object"}},
+			}}
+		}
+		for _, status := range op.PageStatus {
+			responses[statusKey(status)] = map[string]any{"description": http.StatusText(status), "content": pageContent}
+		}
+		// 表单验证失败时重新渲染页面 form中为字段的错误信息
+		if _, ok := op.Form.(formValidator); ok {
+			responses[statusKey(http.StatusUnprocessableEntity)] = map[string]any{"description": "表单验证失败", "content": pageContent}
+		}
+		problems := []int{http.StatusTooManyRequests, http.StatusInternalServerError}
+		if len(params) > 0 {
+			problems = append(problems, http.StatusNotFound)
+		}
+		if op.Method == http.MethodPost || op.Query != nil {
+			problems = append(problems, http.StatusBadRequest)
+		}
+		if op.Access != "" {
+			problems = append(problems, http.StatusUnauthorized, http.StatusForbidden)
+		}
+		for _, status := range problems {
+			name := strings.ReplaceAll(http.StatusText(status), " ", "")
+			responses[statusKey(status)] = map[string]any{"$ref": "#/components/responses/" + name}
+			errorResponses[name] = map[string]any{
+				"description": http.StatusText(status),
+				"content": map[string]any{
+					"application/problem+json": map[string]any{"schema": problem},
+					"text/plain":               map[string]any{"schema": map[string]any{"type": "string"}},
+				},
+			}
+		}
+
+		if op.Query != nil {
+			for _, f := range formFields(reflect.TypeOf(op.Query), op.Enums) {
+				params = append(params, map[string]any{"name": f.name, "in": "query", "schema": f.schema})
+			}
+		}
+		operation := map[string]any{
+			"operationId": operationID(op.Method, op.Path),
+			"tags":        []string{op.Tag},
+			"summary":     op.Summary,
+			"responses":   responses,
+		}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+		if op.Method == http.MethodPost {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"application/x-www-form-urlencoded": map[string]any{"schema": formSchema(op.Form, op.Enums, !op.Stateless)},
+				},
+			}
+		}
+		if op.Access != "" {
+			operation["security"] = []map[string][]string{{"session": {}}}
+			operation["x-required-role"] = op.Access
+		}
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(op.Method)] = operation
+	}
+
+	doc := map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":   "SnippetBox",
+			"version": "1.0.0",
+			"description": "所有页面在请求头Accept为application/json时返回页面数据的JSON 错误为RFC 9457的问题详情。" +
+				"提交表单需要带上页面中的csrf_token与对应的Cookie。",
+		},
+		"tags": []map[string]string{
+			{"name": "snippets", "description": "snippet的查看 创建与收藏"},
+			{"name": "comments", "description": "评论"},
+			{"name": "auth", "description": "注册 登入与退出"},
+			{"name": "account", "description": "当前账号"},
+			{"name": "admin", "description": "管理与审核"},
+			{"name": "meta", "description": "其他"},
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas":   g.schemas,
+			"responses": errorResponses,
+			"securitySchemes": map[string]any{
+				"session": map[string]any{"type": "apiKey", "in": "cookie", "name": "session"},
+			},
+		},
+	}
+	if app.baseURL != "" {
+		doc["servers"] = []map[string]string{{"url": app.baseURL}}
+	}
+	return doc
+}
+
+// 将httprouter的路径转换为OpenAPI的格式 /snippet/view/:id -> /snippet/view/{id}
+func openAPIPath(path string) (string, []map[string]any) {
+	var params []map[string]any
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if !strings.HasPrefix(s, ":") && !strings.HasPrefix(s, "*") {
+			continue
+		}
+		name := s[1:]
+		schema := map[string]any{"type": "string"}
+		if name == "id" {
+			schema = map[string]any{"type": "integer", "minimum": 1}
+		}
+		params = append(params, map[string]any{"name": name, "in": "path", "required": true, "schema": schema})
+		segments[i] = "{" + name + "}"
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// 由方法与路径生成operationId GET /snippet/view/:id -> getSnippetView
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	words := strings.FieldsFunc(path, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != ':' && r != '*'
+	})
+	if len(words) == 0 {
+		words = []string{"home"}
+	}
+	for _, w := range words {
+		if strings.HasPrefix(w, ":") || strings.HasPrefix(w, "*") {
+			continue
+		}
+		b.WriteString(upperFirst(w))
+	}
+	return b.String()
+}
+
+func upperFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	r := []rune(s)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+// responses中以字符串形式的状态码作为键
+func statusKey(status int) string {
+	return strconv.Itoa(status)
+}
+
+// 表单中的一个字段
+type formField struct {
+	name   string
+	schema map[string]any
+}
+
+// 按照form标签列出表单的字段 匿名嵌入的结构体展开 与解码器的行为一致
+func formFields(t reflect.Type, enums map[string][]string) []formField {
+	var fields []formField
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("form")
+		if tag == "-" {
+			continue
+		}
+		if f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct {
+			fields = append(fields, formFields(f.Type, enums)...)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		name := tag
+		if name == "" {
+			name = f.Name
+		}
+		schema := formValueSchema(f.Type, enums)
+		if values, ok := enums[name]; ok && f.Type.Kind() == reflect.String {
+			schema["enum"] = values
+		}
+		fields = append(fields, formField{name: name, schema: schema})
+	}
+	return fields
+}
+
+func formValueSchema(t reflect.Type, enums map[string][]string) map[string]any {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Slice:
+		items := formValueSchema(t.Elem(), enums)
+		schema := map[string]any{"type": "array", "items": items}
+		if t.Elem().Kind() == reflect.Struct {
+			schema["description"] = "以name[0].field的形式提交"
+		}
+		return schema
+	case reflect.Struct:
+		properties := map[string]any{}
+		for _, f := range formFields(t, enums) {
+			properties[f.name] = f.schema
+		}
+		return map[string]any{"type": "object", "properties": properties}
+	}
+	return map[string]any{"type": "string"}
+}
+
+// 生成提交表单的结构 form为nil时只有csrf_token
+func formSchema(form any, enums map[string][]string, csrf bool) map[string]any {
+	properties := map[string]any{}
+	if form != nil {
+		for _, f := range formFields(reflect.TypeOf(form), enums) {
+			properties[f.name] = f.schema
+		}
+	}
+	schema := map[string]any{"type": "object", "properties": properties}
+	if csrf {
+		properties["csrf_token"] = map[string]any{"type": "string", "description": "页面中的csrf_token"}
+		schema["required"] = []string{"csrf_token"}
+	}
+	return schema
+}
+
+// 从JSON的结构体生成schema 具名的结构体放在components中
+type schemaGenerator struct {
+	schemas map[string]any
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (g *schemaGenerator) schema(t reflect.Type) map[string]any {
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Pointer:
+		return map[string]any{"anyOf": []any{g.schema(t.Elem()), map[string]any{"type": "null"}}}
+	case t.Kind() == reflect.Struct:
+		name := upperFirst(strings.TrimPrefix(t.Name(), "json"))
+		if _, ok := g.schemas[name]; !ok {
+			// 先占位 防止结构体互相引用时无限递归
+			g.schemas[name] = nil
+			g.schemas[name] = g.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	case t.Kind() == reflect.Slice:
+		// nil切片编码为null
+		return map[string]any{"type": []string{"array", "null"}, "items": g.schema(t.Elem())}
+	case t.Kind() == reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case t.Kind() == reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]any{"type": "number"}
+	case t.Kind() == reflect.String:
+		return map[string]any{"type": "string"}
+	}
+	return map[string]any{}
+}
+
+// 按照json标签生成对象的属性 没有omitempty的字段都是必需的
+func (g *schemaGenerator) object(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	required := []string{}
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if !f.IsExported() || tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = g.schema(f.Type)
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	return map[string]any{"type": "object", "properties": properties, "required": required}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"SnippetBox.mikudayo.net/internal/assert"
+)
+
+// OpenAPI文档中的一个操作 只解析测试需要的字段
+type testOperation struct {
+	OperationID string                     `json:"operationId"`
+	Responses   map[string]json.RawMessage `json:"responses"`
+	Security    []map[string][]string      `json:"security"`
+	RequestBody *struct {
+		Content map[string]struct {
+			Schema struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+				Required   []string                   `json:"required"`
+			} `json:"schema"`
+		} `json:"content"`
+	} `json:"requestBody"`
+}
+
+func TestOpenAPI(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, header, body := ts.get(t, "/api/openapi.json")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, header.Get("Content-Type"), "application/json")
+	var doc struct {
+		OpenAPI    string                              `json:"openapi"`
+		Paths      map[string]map[string]testOperation `json:"paths"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal([]byte(body), &doc); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, doc.OpenAPI, "3.1.0")
+
+	t.Run("Routes", func(t *testing.T) {
+		// 注册的路由与文档中的路由完全一致
+		var registered []string
+		for _, route := range app.router().routes {
+			method, path, _ := strings.Cut(route, " ")
+			path, _ = openAPIPath(path)
+			registered = append(registered, method+" "+path)
+		}
+		var documented []string
+		for path, item := range doc.Paths {
+			for method := range item {
+				documented = append(documented, strings.ToUpper(method)+" "+path)
+			}
+		}
+		for _, route := range registered {
+			if !slices.Contains(documented, route) {
+				t.Errorf("route %q is not documented", route)
+			}
+		}
+		for _, route := range documented {
+			if !slices.Contains(registered, route) {
+				t.Errorf("documented route %q is not registered", route)
+			}
+		}
+		assert.Equal(t, len(documented), len(registered))
+	})
+
+	t.Run("Operations", func(t *testing.T) {
+		ids := map[string]bool{}
+		for path, item := range doc.Paths {
+			for method, op := range item {
+				if ids[op.OperationID] {
+					t.Errorf("duplicate operationId %q", op.OperationID)
+				}
+				ids[op.OperationID] = true
+				// 所有的路由都可能被限流或者发生内部错误
+				for _, status := range []string{"429", "500"} {
+					if _, ok := op.Responses[status]; !ok {
+						t.Errorf("%s %s: missing %s response", method, path, status)
+					}
+				}
+				// 需要登入的路由未登入时返回401
+				if len(op.Security) > 0 {
+					if _, ok := op.Responses["401"]; !ok {
+						t.Errorf("%s %s: missing 401 response", method, path)
+					}
+				}
+				// 提交的表单都需要csrf_token
+				if method == "post" {
+					form := op.RequestBody.Content["application/x-www-form-urlencoded"].Schema
+					if _, ok := form.Properties["csrf_token"]; !ok || !slices.Contains(form.Required, "csrf_token") {
+						t.Errorf("%s %s: missing csrf_token", method, path)
+					}
+				}
+			}
+		}
+	})
+
+	t.Run("Schemas", func(t *testing.T) {
+		view := doc.Paths["/snippet/view/{id}"]["get"]
+		for _, status := range []string{"200", "404", "410", "451"} {
+			if _, ok := view.Responses[status]; !ok {
+				t.Errorf("missing %s response", status)
+			}
+		}
+		assert.StringContains(t, string(view.Responses["200"]), "#/components/schemas/Page")
+		assert.StringContains(t, string(view.Responses["404"]), "#/components/responses/NotFound")
+		for _, name := range []string{"Page", "Snippet", "User", "FormValidation", "ProblemDetails", "UserDataExport"} {
+			if _, ok := doc.Components.Schemas[name]; !ok {
+				t.Errorf("missing schema %q", name)
+			}
+		}
+		// 用户的结构中不包含密码
+		assert.Equal(t, strings.Contains(string(doc.Components.Schemas["User"]), "password\""), false)
+
+		create := doc.Paths["/snippet/create"]["post"]
+		form := create.RequestBody.Content["application/x-www-form-urlencoded"].Schema
+		// 嵌入的有效期表单被展开 验证器不是表单的字段
+		for _, field := range []string{"title", "files", "expires", "expires_amount", "tags", "private"} {
+			if _, ok := form.Properties[field]; !ok {
+				t.Errorf("missing form field %q", field)
+			}
+		}
+		_, ok := form.Properties["FieldErrors"]
+		assert.Equal(t, ok, false)
+		if _, ok := create.Responses["422"]; !ok {
+			t.Error("missing 422 response")
+		}
+	})
+}
@@ -3,21 +3,58 @@ package main
 import (
 	"net/http"
 
+	"SnippetBox.mikudayo.net/internal/models"
 	"SnippetBox.mikudayo.net/ui"
 	"github.com/julienschmidt/httprouter"
 	"github.com/justinas/alice"
 )
 
+// 注册路由的同时记录方法与路径 用于检查OpenAPI文档中是否包含所有的路由
+type routeRecorder struct {
+	*httprouter.Router
+	// 按照注册的顺序 例如GET /snippet/view/:id
+	routes []string
+}
+
+func (rr *routeRecorder) Handler(method, path string, handler http.Handler) {
+	rr.routes = append(rr.routes, method+" "+path)
+	rr.Router.Handler(method, path, handler)
+}
+
+func (rr *routeRecorder) HandlerFunc(method, path string, handler http.HandlerFunc) {
+	rr.routes = append(rr.routes, method+" "+path)
+	rr.Router.HandlerFunc(method, path, handler)
+}
+
 func (app *Application) routes() http.Handler {
+	router := app.router()
+
+	// 使用包创建一个中间件链变量方便管理 执行顺序 ->
+	// 请求的id最先生成 之后的日志都可以带上
+	// 全局的限流需要按照用户区分 放在读取session之后(见router)
+	standard := alice.New(assignRequestID, app.recoverPanic, app.logRequest, secureHeaders)
+	// 使用中间件将当前mux下的所有路由都包装起来
+	// 相当于是"重写"的在结构体中的方法
+	// 最外层的中间件会第一个进行应用 类似于栈 first in first out
+	// return app.recoverPanic(app.logRequest(secureHeaders(mux)))
+
+	// 直接调用then方法初始化路由
+	return standard.Then(router)
+}
+
+// 注册所有的路由 新增的路由需要同时在openapi.go中添加说明
+func (app *Application) router() *routeRecorder {
 	// 创建一个自定义路由
 	// mux := http.NewServeMux()
 	// 使用三方路由库建立一个可以制定处理器访问方法与url占位符的复用器
-	router := httprouter.New()
+	router := &routeRecorder{Router: httprouter.New()}
 
 	// 重写当前路由的内置notfound函数 使整个应用程序表现一致
 	// 尝试访问不存在的路由器与合法但是不存在的页面
-	router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		app.notFound(w)
+	// 不需要session的路由只能按照ip限流
+	limited := alice.New(app.rateLimit(globalRateLimit))
+	router.NotFound = limited.ThenFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.notFound(w, r)
 	})
 
 	// 调用
@@ -31,26 +68,42 @@ func (app *Application) routes() http.Handler {
 	// router.Handler(http.MethodGet, "/static/*filepath", http.StripPrefix("/static", fs))
 
 	// 不需要再去除url前缀 直接传入即可
+	// 页面引用的静态文件不计入全局的限流
 	router.Handler(http.MethodGet, "/static/*filepath", fs)
 
 	// 创建用于测试的路由
-	router.HandlerFunc(http.MethodGet, "/ping", ping)
+	router.Handler(http.MethodGet, "/ping", limited.ThenFunc(ping))
+	// 描述所有路由的OpenAPI文档
+	router.Handler(http.MethodGet, "/api/openapi.json", limited.ThenFunc(app.openAPI))
 
 	// 创建包含seesion的新中间件链对需要共享信息的路由进行手动预包装
 	// 添加防止CSRF攻击的noSurf中间件 与logout产生冲突 直接应激触发BadRequest
-	dynamic := alice.New(app.sessionManager.LoadAndSave, app.authenticate, noSurf)
+	// 全局的限流放在authenticate之后 已登入时按照用户限制 超出限制的请求同样会被记录并带有安全相关的表头
+	dynamic := alice.New(app.sessionManager.LoadAndSave, app.authenticate, app.rateLimit(globalRateLimit), app.noSurf)
 
 	// .ThenFunc()返回的还是一个handler而不是像HandlerFunc直接成为可执行的路由 所以在这里要改变原先router.HandlerFunc()为router.Handler()来注册路由
 	router.Handler(http.MethodGet, "/", dynamic.ThenFunc(app.home))
 	// 处理网站的详情页面信息
 	router.Handler(http.MethodGet, "/about", dynamic.ThenFunc(app.about))
 	router.Handler(http.MethodGet, "/snippet/view/:id", dynamic.ThenFunc(app.snippetView))
+	// 以纯文本查看单个文件 或者将所有文件打包下载
+	router.Handler(http.MethodGet, "/snippet/raw/:id/:name", dynamic.ThenFunc(app.snippetRaw))
+	router.Handler(http.MethodGet, "/snippet/download/:id", dynamic.ThenFunc(app.snippetDownload))
+	router.Handler(http.MethodGet, "/snippets", dynamic.ThenFunc(app.snippetList))
+	router.Handler(http.MethodGet, "/search", dynamic.ThenFunc(app.snippetSearch))
+	router.Handler(http.MethodGet, "/tags", dynamic.ThenFunc(app.tagList))
+	router.Handler(http.MethodGet, "/tag/:name", dynamic.ThenFunc(app.tagView))
 	// 用户信息处理相关的处理器
 	router.Handler(http.MethodGet, "/user/signup", dynamic.ThenFunc(app.userSignup))
-	router.Handler(http.MethodPost, "/user/signup", dynamic.ThenFunc(app.userSignupPost))
+	router.Handler(http.MethodPost, "/user/signup", dynamic.Append(app.rateLimit(signupRateLimit)).ThenFunc(app.userSignupPost))
 	// 用户登入相关的处理器
 	router.Handler(http.MethodGet, "/user/login", dynamic.ThenFunc(app.userLogin))
-	router.Handler(http.MethodPost, "/user/login", dynamic.ThenFunc(app.userLoginPost))
+	router.Handler(http.MethodPost, "/user/login", dynamic.Append(app.rateLimit(loginRateLimit)).ThenFunc(app.userLoginPost))
+	// 通过企业身份提供方(OIDC)登入
+	router.Handler(http.MethodGet, "/user/login/oidc", dynamic.ThenFunc(app.userLoginOIDC))
+	router.Handler(http.MethodGet, "/user/login/oidc/callback", dynamic.ThenFunc(app.userLoginOIDCCallback))
+	// 邮件中的确认链接可能在未登入的浏览器中打开 只通过token进行验证
+	router.Handler(http.MethodGet, "/account/email/confirm", dynamic.ThenFunc(app.userEmailConfirm))
 
 	// 对路由进行分组处理 上半部分的网页访问不需要用户的登入权限 在下半部分进行检测
 	// 下面还需要合适用户的身份信息就用新的中间件 不会再次从数据库进行查询 直接从ctx中进行核实
@@ -58,7 +111,22 @@ func (app *Application) routes() http.Handler {
 
 	// 创建消息相关的处理器
 	router.Handler(http.MethodGet, "/snippet/create", protected.ThenFunc(app.snippetCreate))
-	router.Handler(http.MethodPost, "/snippet/create", protected.ThenFunc(app.snippetCreatePost))
+	router.Handler(http.MethodPost, "/snippet/create", protected.Append(app.rateLimit(createRateLimit)).ThenFunc(app.snippetCreatePost))
+	// 以已有的snippet为基础创建分支 提交时同样使用/snippet/create
+	router.Handler(http.MethodGet, "/snippet/fork/:id", protected.ThenFunc(app.snippetFork))
+	// 发表 修改与删除评论
+	router.Handler(http.MethodPost, "/snippet/comment/:id", protected.ThenFunc(app.snippetCommentPost))
+	router.Handler(http.MethodGet, "/comment/edit/:id", protected.ThenFunc(app.commentEdit))
+	router.Handler(http.MethodPost, "/comment/edit/:id", protected.ThenFunc(app.commentEditPost))
+	router.Handler(http.MethodPost, "/comment/delete/:id", protected.ThenFunc(app.commentDeletePost))
+	// 收藏与收藏列表
+	router.Handler(http.MethodPost, "/snippet/star/:id", protected.ThenFunc(app.snippetStarPost))
+	// 举报snippet
+	router.Handler(http.MethodPost, "/snippet/report/:id", protected.Append(app.rateLimit(reportRateLimit)).ThenFunc(app.snippetReportPost))
+	router.Handler(http.MethodGet, "/account/favorites", protected.ThenFunc(app.userFavorites))
+	// 创建者修改snippet的有效期
+	router.Handler(http.MethodGet, "/snippet/expiry/:id", protected.ThenFunc(app.snippetExpiry))
+	router.Handler(http.MethodPost, "/snippet/expiry/:id", protected.ThenFunc(app.snippetExpiryPost))
 	// 用户退出的相关处理器
 	router.Handler(http.MethodPost, "/user/logout", protected.ThenFunc(app.userLogoutPost))
 	// 用户账号信息相关处理器
@@ -66,13 +134,33 @@ func (app *Application) routes() http.Handler {
 	// 用户账号密码更新的处理器
 	router.Handler(http.MethodGet, "/account/password/update", protected.ThenFunc(app.userPasswordUpdate))
 	router.Handler(http.MethodPost, "/account/password/update", protected.ThenFunc(app.userPasswordUpdatePost))
-	// 使用包创建一个中间件链变量方便管理 执行顺序 ->
-	standard := alice.New(app.recoverPanic, app.logRequest, secureHeaders)
-	// 使用中间件将当前mux下的所有路由都包装起来
-	// 相当于是"重写"的在结构体中的方法
-	// 最外层的中间件会第一个进行应用 类似于栈 first in first out
-	// return app.recoverPanic(app.logRequest(secureHeaders(mux)))
+	// 修改昵称与邮箱的处理器
+	router.Handler(http.MethodGet, "/account/name/update", protected.ThenFunc(app.userNameUpdate))
+	router.Handler(http.MethodPost, "/account/name/update", protected.ThenFunc(app.userNameUpdatePost))
+	router.Handler(http.MethodGet, "/account/email/update", protected.ThenFunc(app.userEmailUpdate))
+	router.Handler(http.MethodPost, "/account/email/update", protected.ThenFunc(app.userEmailUpdatePost))
+	// 注销账号与导出个人数据的处理器
+	router.Handler(http.MethodGet, "/account/delete", protected.ThenFunc(app.userAccountDelete))
+	router.Handler(http.MethodPost, "/account/delete", protected.ThenFunc(app.userAccountDeletePost))
+	router.Handler(http.MethodPost, "/account/export", protected.ThenFunc(app.userDataExport))
+	// 当前账号的安全记录
+	router.Handler(http.MethodGet, "/account/security", protected.ThenFunc(app.userSecurityActivity))
 
-	// 直接调用then方法初始化路由
-	return standard.Then(router)
+	// 管理相关的路由 在登入验证之后再检查角色
+	moderator := protected.Append(app.requireRole(models.RoleModerator))
+	admin := protected.Append(app.requireRole(models.RoleAdmin))
+	router.Handler(http.MethodGet, "/admin", moderator.ThenFunc(app.adminDashboard))
+	router.Handler(http.MethodPost, "/admin/snippets/delete", moderator.ThenFunc(app.adminSnippetDeletePost))
+	router.Handler(http.MethodPost, "/admin/snippets/restore", moderator.ThenFunc(app.adminSnippetRestorePost))
+	// 举报的审核队列
+	router.Handler(http.MethodGet, "/admin/reports", moderator.ThenFunc(app.adminReports))
+	router.Handler(http.MethodPost, "/admin/reports/:id", moderator.ThenFunc(app.adminReportPost))
+	router.Handler(http.MethodGet, "/admin/users", admin.ThenFunc(app.adminUsers))
+	router.Handler(http.MethodPost, "/admin/users/:id/disable", admin.ThenFunc(app.adminUserDisablePost))
+	router.Handler(http.MethodPost, "/admin/users/:id/reset", admin.ThenFunc(app.adminUserResetPost))
+	router.Handler(http.MethodPost, "/admin/users/:id/role", admin.ThenFunc(app.adminUserRolePost))
+	// 查询审计日志
+	router.Handler(http.MethodGet, "/admin/audit", admin.ThenFunc(app.adminAudit))
+
+	return router
 }
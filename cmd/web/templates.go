@@ -1,11 +1,18 @@
 package main
 
 import (
+	"fmt"
 	"html/template"
 	"io/fs"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
 	"time"
+	"unicode"
 
+	"SnippetBox.mikudayo.net/internal/markdown"
 	"SnippetBox.mikudayo.net/internal/models"
 	"SnippetBox.mikudayo.net/ui"
 )
@@ -25,7 +32,81 @@ type TemplateData struct {
 	IsAuthenticated bool
 	// 实现三方包中防止CSRF攻击的逻辑
 	CSRFToken string
-	User      *UserAccountInfo
+	User      *models.User
+	// 当前登入用户的角色
+	UserRole string
+	// 管理页面中展示的用户列表
+	Users []*models.User
+	// 是否在登入页面展示企业账号登入的入口
+	OIDCEnabled bool
+	// 搜索页面是否进行了搜索 用于区分没有结果与尚未搜索
+	Searched bool
+	// 列表页面的翻页地址 为空时不显示对应的链接
+	NextPage string
+	PrevPage string
+	// 标签页面当前的标签
+	Tag      string
+	TagCloud []tagCloudItem
+	// 是否允许选择永不过期
+	AllowNeverExpire bool
+	// 当前登入用户的id 未登入时为0
+	AuthenticatedUserID int
+	// 消息页面中展示的分支
+	Forks []*models.Snippet
+	// 消息页面中的评论与正在修改的评论
+	Comments []*models.Comment
+	Comment  *models.Comment
+	// 当前用户是否收藏了正在查看的snippet
+	Starred bool
+	// 主页中本周收藏最多的snippet
+	MostStarred []*models.Snippet
+	// 创建者查看自己的snippet时展示的访问统计
+	ViewStats *models.ViewStats
+	// 创建页面是否展示Markdown文件的预览
+	Preview bool
+	// 审核页面中未处理的举报
+	ReportQueue []*models.ReportedSnippet
+	// 安全记录与管理页面中的审计记录
+	AuditEvents []*models.AuditEvent
+}
+
+// 主页中统计收藏的时间范围与展示的数量
+const (
+	mostStarredPeriod = 7 * 24 * time.Hour
+	maxMostStarred    = 5
+)
+
+// 安全记录页面最多展示的记录数
+const maxSecurityEvents = 50
+
+// 访问统计展示的天数
+const viewStatsDays = 14
+
+// 标签云中最多展示的标签数
+const maxTagCloud = 50
+
+// 标签云中的一项 Weight为1到5 使用越多的标签字号越大
+type tagCloudItem struct {
+	Name   string
+	Count  int
+	Weight int
+}
+
+// 按照使用次数相对于最多的标签计算权重
+func newTagCloud(counts []models.TagCount) []tagCloudItem {
+	most := 0
+	for _, c := range counts {
+		most = max(most, c.Count)
+	}
+	cloud := make([]tagCloudItem, 0, len(counts))
+	for _, c := range counts {
+		cloud = append(cloud, tagCloudItem{
+			Name:   c.Name,
+			Count:  c.Count,
+			Weight: 1 + c.Count*4/most,
+		})
+	}
+	return cloud
 }
 
 // 自定义时间格式化函数
@@ -42,6 +123,201 @@ func hunmanDate(t time.Time) string {
 // 创建template.FuncMap用于存储自定义函数
 var functions = template.FuncMap{
 	"humanDate": hunmanDate,
+	// 在模板中判断角色的权限等级
+	"roleAtLeast": models.RoleAtLeast,
+	// 创建与搜索页面中可选的语言
+	"languages": func() []string { return models.Languages },
+	// 举报时可以选择的原因与原因的说明
+	"reportReasons":    func() []string { return models.ReportReasons },
+	"reportReason":     models.ReportReasonLabel,
+	"maxReportDetails": func() int { return models.MaxReportDetails },
+	// 审计日志中的操作类型与分类
+	"auditActions":    func() []string { return models.AuditActions },
+	"auditCategories": func() []string { return models.AuditCategories },
+	"auditAction":     models.AuditActionLabel,
+	// 一条snippet最多可以包含的文件数
+	"maxFiles":  func() int { return models.MaxFiles },
+	"excerpt":   excerpt,
+	"highlight": highlight,
+	"expiresIn": expiresIn,
+	"isoDate":   isoDate,
+	"shortDate": shortDate,
+	// 按行展示代码 每一行都可以作为评论的锚点
+	"codeLines": codeLines,
+	// 评论中的Markdown-lite格式
+	"markdownLite": markdownLite,
+	// Markdown文件渲染为经过清理的HTML
+	"markdown": markdown.Render,
+}
+
+// 代码中的一行 Number从1开始
+type codeLine struct {
+	Number int
+	Text   string
+}
+
+// 按照换行符拆分内容 与models.File.Lines的计数一致
+func codeLines(content string) []codeLine {
+	parts := strings.Split(content, "\n")
+	lines := make([]codeLine, len(parts))
+	for i, p := range parts {
+		lines[i] = codeLine{Number: i + 1, Text: p}
+	}
+	return lines
+}
+
+// Markdown-lite中支持的行内格式 作用于已经转义过的文本
+// 链接只允许http与https 地址中不能包含*防止与强调的格式交叉
+var (
+	mdLink   = regexp.MustCompile(`\[([^\]]+)\]\((https?://[^\s)*]+)\)`)
+	mdBold   = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+	mdItalic = regexp.MustCompile(`\*([^*]+)\*`)
+)
+
+// 将评论转换为HTML 支持`代码` **加粗** *斜体* [文字](链接)与换行
+// 先对每一段文本进行转义再添加标签 用户输入的HTML不会被执行
+func markdownLite(text string) template.HTML {
+	var b strings.Builder
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteString("<br>")
+		}
+		// 奇数下标的部分在一对反引号之间 其中的内容不再处理其他格式
+		parts := strings.Split(line, "`")
+		for j, p := range parts {
+			escaped := template.HTMLEscapeString(p)
+			if j%2 == 1 && j < len(parts)-1 {
+				b.WriteString("<code>" + escaped + "</code>")
+				continue
+			}
+			// 没有闭合的反引号原样输出
+			if j%2 == 1 {
+				b.WriteString("`")
+			}
+			escaped = mdLink.ReplaceAllString(escaped, `<a href="$2" rel="nofollow noopener">$1</a>`)
+			escaped = mdBold.ReplaceAllString(escaped, "<strong>$1</strong>")
+			escaped = mdItalic.ReplaceAllString(escaped, "<em>$1</em>")
+			b.WriteString(escaped)
+		}
+	}
+	return template.HTML(b.String())
+}
+
+// 用于<time>标签的datetime属性
+func isoDate(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// 访问统计中按天展示的日期 例如"10-19"
+func shortDate(t time.Time) string {
+	return t.UTC().Format("01-02")
+}
+
+// 以相对时间展示过期时间 例如"2小时后过期"
+func expiresIn(t time.Time) string {
+	d := time.Until(t)
+	if d <= 0 {
+		return "已过期"
+	}
+	return relativeDuration(d) + "后过期"
+}
+
+// 将时长转换为粗略的中文描述 只保留最大的单位
+func relativeDuration(d time.Duration) string {
+	const day = 24 * time.Hour
+	switch {
+	case d < time.Minute:
+		return "不到1分钟"
+	case d < time.Hour:
+		return fmt.Sprintf("%d分钟", d/time.Minute)
+	case d < 2*day:
+		return fmt.Sprintf("%d小时", d/time.Hour)
+	case d < 60*day:
+		return fmt.Sprintf("%d天", d/day)
+	case d < 2*365*day:
+		return fmt.Sprintf("%d个月", d/(30*day))
+	default:
+		return fmt.Sprintf("%d年", d/(365*day))
+	}
+}
+
+// 搜索结果中展示的摘要长度(字符数)
+const excerptLength = 160
+
+// 截取内容中第一个关键字附近的片段作为搜索结果的摘要
+func excerpt(text, query string) string {
+	runes := []rune(text)
+	if len(runes) <= excerptLength {
+		return text
+	}
+	start := 0
+	if i, _ := findTerm(toLowerRunes(runes), searchTerms(query), 0); i >= 0 {
+		// 关键字前面保留一小段上下文
+		start = max(0, min(i-excerptLength/4, len(runes)-excerptLength))
+	}
+	end := start + excerptLength
+	out := string(runes[start:end])
+	if start > 0 {
+		out = "…" + out
+	}
+	if end < len(runes) {
+		out += "…"
+	}
+	return out
+}
+
+// 转义文本并用<mark>标出其中的关键字(不区分大小写)
+func highlight(text, query string) template.HTML {
+	runes := []rune(text)
+	lower := toLowerRunes(runes)
+	terms := searchTerms(query)
+	var b strings.Builder
+	pos := 0
+	for pos < len(runes) {
+		i, n := findTerm(lower, terms, pos)
+		if i < 0 {
+			break
+		}
+		b.WriteString(template.HTMLEscapeString(string(runes[pos:i])))
+		b.WriteString("<mark>")
+		b.WriteString(template.HTMLEscapeString(string(runes[i : i+n])))
+		b.WriteString("</mark>")
+		pos = i + n
+	}
+	b.WriteString(template.HTMLEscapeString(string(runes[pos:])))
+	return template.HTML(b.String())
+}
+
+// 将关键字拆分成小写的词 较长的词优先匹配
+func searchTerms(query string) [][]rune {
+	var terms [][]rune
+	for _, f := range strings.Fields(query) {
+		terms = append(terms, toLowerRunes([]rune(f)))
+	}
+	sort.Slice(terms, func(i, j int) bool { return len(terms[i]) > len(terms[j]) })
+	return terms
+}
+
+// 逐个字符转换为小写 保证与原文的下标一一对应
+func toLowerRunes(runes []rune) []rune {
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+	return lower
+}
+
+// 从from开始查找最先出现的关键字 返回其下标与长度 找不到时返回-1
+func findTerm(text []rune, terms [][]rune, from int) (int, int) {
+	for i := from; i < len(text); i++ {
+		for _, t := range terms {
+			if len(t) > 0 && i+len(t) <= len(text) && slices.Equal(text[i:i+len(t)], t) {
+				return i, len(t)
+			}
+		}
+	}
+	return -1, 0
 }
 
 // 将网页模板渲染并存储到内存中 提高运行效率
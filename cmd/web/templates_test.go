@@ -2,6 +2,7 @@ package main
 
 import (
 	"SnippetBox.mikudayo.net/internal/assert"
+	"strings"
 	"testing"
 	"time"
 )
@@ -50,3 +51,83 @@ func TestHumanDate(t *testing.T) {
 	// 	t.Errorf("got %q;want %q", hd, "2022-03-17 10:15:00")
 	// }
 }
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		query string
+		want  string
+	}{
+		{name: "Case insensitive", text: "Hello Miku", query: "miku", want: "Hello <mark>Miku</mark>"},
+		{name: "Multiple terms", text: "miku and luka", query: "luka miku", want: "<mark>miku</mark> and <mark>luka</mark>"},
+		{name: "Escaped", text: "<b>miku</b>", query: "miku", want: "&lt;b&gt;<mark>miku</mark>&lt;/b&gt;"},
+		{name: "Chinese", text: "初音未来的歌", query: "未来", want: "初音<mark>未来</mark>的歌"},
+		{name: "No query", text: "a<b", query: "", want: "a&lt;b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, string(highlight(tt.text, tt.query)), tt.want)
+		})
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	long := strings.Repeat("a", 200) + "miku" + strings.Repeat("b", 200)
+	got := excerpt(long, "miku")
+	assert.StringContains(t, got, "miku")
+	assert.Equal(t, strings.HasPrefix(got, "…"), true)
+	assert.Equal(t, strings.HasSuffix(got, "…"), true)
+	assert.Equal(t, excerpt("short miku", "miku"), "short miku")
+}
+
+func TestRelativeDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{d: 30 * time.Second, want: "不到1分钟"},
+		{d: 10 * time.Minute, want: "10分钟"},
+		{d: 2*time.Hour + 30*time.Minute, want: "2小时"},
+		{d: 47 * time.Hour, want: "47小时"},
+		{d: 7 * 24 * time.Hour, want: "7天"},
+		{d: 90 * 24 * time.Hour, want: "3个月"},
+		{d: 3 * 365 * 24 * time.Hour, want: "3年"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			assert.Equal(t, relativeDuration(tt.d), tt.want)
+		})
+	}
+	assert.Equal(t, expiresIn(time.Now().Add(-time.Hour)), "已过期")
+	assert.Equal(t, expiresIn(time.Now().Add(2*time.Hour+time.Minute)), "2小时后过期")
+}
+
+func TestMarkdownLite(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "Plain", text: "mikudayo", want: "mikudayo"},
+		{name: "Escaped", text: "<script>alert('miku')</script>", want: "&lt;script&gt;alert(&#39;miku&#39;)&lt;/script&gt;"},
+		{name: "Bold and italic", text: "**miku** *dayo*", want: "<strong>miku</strong> <em>dayo</em>"},
+		{name: "Code is not formatted", text: "`**a** <b>`", want: "<code>**a** &lt;b&gt;</code>"},
+		{name: "Unclosed code", text: "a`b", want: "a`b"},
+		{name: "Link", text: "[miku](https://example.com/?a=1&b=2)", want: `<a href="https://example.com/?a=1&amp;b=2" rel="nofollow noopener">miku</a>`},
+		{name: "Script link", text: "[miku](javascript:alert(1))", want: "[miku](javascript:alert(1))"},
+		{name: "Quote in link", text: `[miku](https://example.com/"onclick="x)`, want: `<a href="https://example.com/&#34;onclick=&#34;x" rel="nofollow noopener">miku</a>`},
+		{name: "Line breaks", text: "miku\r\ndayo", want: "miku<br>dayo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, string(markdownLite(tt.text)), tt.want)
+		})
+	}
+}
+
+func TestCodeLines(t *testing.T) {
+	lines := codeLines("package main\n\nfunc main() {}")
+	assert.Equal(t, len(lines), 3)
+	assert.Equal(t, lines[2], codeLine{Number: 3, Text: "func main() {}"})
+}
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"SnippetBox.mikudayo.net/internal/assert"
+	"SnippetBox.mikudayo.net/internal/models"
 	"SnippetBox.mikudayo.net/internal/models/mocks"
+	"SnippetBox.mikudayo.net/internal/ratelimit"
 	"bytes"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
 	"html"
 	"net/url"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"io"
@@ -30,6 +35,23 @@ func extractCSRFToken(t *testing.T, body string) string {
 	return html.UnescapeString(string(matches[1]))
 }
 
+// 注册页面中的工作量证明挑战与难度
+var powChallengeRX = regexp.MustCompile(`<input type="hidden" name="pow_challenge" value="(.+)" data-difficulty="(\d+)">`)
+
+// 从注册页面中提取挑战并计算solution 代替浏览器中的pow.js
+func solveSignupChallenge(t *testing.T, body string) (challenge, solution string) {
+	matches := powChallengeRX.FindStringSubmatch(body)
+	if len(matches) < 3 {
+		t.Fatal("no proof of work challenge found in body...")
+	}
+	difficulty, err := strconv.Atoi(matches[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge = html.UnescapeString(matches[1])
+	return challenge, models.SolveChallenge(challenge, difficulty)
+}
+
 // 生成用于测试的Application 只初始化必要的底层依赖
 func newTestApplication(t *testing.T) *Application {
 	// 创建所有必要的依赖
@@ -44,6 +66,12 @@ func newTestApplication(t *testing.T) *Application {
 	sessionManager := scs.New()
 	sessionManager.Lifetime = 12 * time.Hour
 	sessionManager.Cookie.Secure = true
+	views := &mocks.ViewModel{}
+	// 测试中使用较低的难度
+	signupPoW, err := models.NewProofOfWork(bytes.Repeat([]byte{39}, models.PoWKeySize), 4, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
 	return &Application{
 		// app中各处的方法都用到了自定义log 不初始化会发生panic
 		errlog:         log.New(io.Discard, "", 0),
@@ -53,6 +81,19 @@ func newTestApplication(t *testing.T) *Application {
 		sessionManager: sessionManager,
 		snippets:       &mocks.SnippetModel{},
 		users:          &mocks.UserModel{},
+		comments:       &mocks.CommentModel{},
+		stars:          &mocks.StarModel{},
+		reports:        &mocks.ReportModel{},
+		views:          views,
+		viewBuffer:     models.NewViewBuffer(views),
+		audit:          &mocks.AuditModel{},
+		mailer:         &mocks.Mailer{},
+		baseURL:        "https://localhost:3939",
+		passwordPolicy: models.DefaultPasswordPolicy,
+		pageSize:       models.DefaultPageSize,
+		secrets:        models.NewSecretScanner(models.DefaultSecretPatterns...),
+		rateLimits:     ratelimit.NewMemoryStore(ratelimit.DefaultMaxKeys),
+		signupPoW:      signupPoW,
 	}
 }
 
@@ -99,6 +140,27 @@ func (ts *testServer) get(t *testing.T, urlPath string) (int, http.Header, strin
 	return rs.StatusCode, rs.Header, string(body)
 }
 
+// 与get相同 但是附带额外的请求头
+func (ts *testServer) getWithHeader(t *testing.T, urlPath string, header http.Header) (int, http.Header, string) {
+	req, err := http.NewRequest(http.MethodGet, ts.URL+urlPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Body.Close()
+	body, err := io.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rs.StatusCode, rs.Header, string(body)
+}
+
 // 在测试服上绑定一个post方法接收指定的url(目标路由)与要发送的目标值 返回状态码,响应头,响应体
 func (ts *testServer) postForm(t *testing.T, urlPath string, form url.Values) (int, http.Header, string) {
 	// 向指定的路由发送post请求
@@ -119,3 +181,59 @@ func (ts *testServer) postForm(t *testing.T, urlPath string, form url.Values) (i
 	// 返回响应体的信息
 	return rs.StatusCode, rs.Header, string(body)
 }
+
+// 与postForm相同 但是可以附加请求头
+func (ts *testServer) postFormWithHeader(t *testing.T, urlPath string, form url.Values, header http.Header) (int, http.Header, string) {
+	req, err := http.NewRequest(http.MethodPost, ts.URL+urlPath, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Body.Close()
+	body, err := io.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rs.StatusCode, rs.Header, string(body)
+}
+
+// 使用给定的邮箱与密码登入测试服 后续请求会自动携带登入后的cookie
+func (ts *testServer) login(t *testing.T, email, password string) {
+	_, _, body := ts.get(t, "/user/login")
+	form := url.Values{}
+	form.Add("email", email)
+	form.Add("password", password)
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, _, _ := ts.postForm(t, "/user/login", form)
+	if code != http.StatusSeeOther {
+		t.Fatalf("login failed with status %d", code)
+	}
+}
+
+// 通过身份提供方完成一次完整的登入流程 返回回调处理器的状态码与重定向地址
+func (ts *testServer) loginOIDC(t *testing.T) (int, string) {
+	code, header, _ := ts.get(t, "/user/login/oidc")
+	assert.Equal(t, code, http.StatusSeeOther)
+	// 访问身份提供方的授权端点 会被重定向回测试服的回调地址
+	rs, err := ts.Client().Get(header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.Body.Close()
+	assert.Equal(t, rs.StatusCode, http.StatusFound)
+	callback := rs.Header.Get("Location")
+	assert.StringContains(t, callback, ts.URL+"/user/login/oidc/callback")
+	rs, err = ts.Client().Get(callback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.Body.Close()
+	return rs.StatusCode, rs.Header.Get("Location")
+}
@@ -0,0 +1,53 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"strings"
+)
+
+// Mailer 定义发送邮件的接口 便于在测试中替换为不真正发送邮件的实现
+type Mailer interface {
+	Send(recipient, subject, body string) error
+}
+
+// SMTPMailer 通过SMTP服务器发送纯文本邮件
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	Sender   string
+}
+
+// 发送一封纯文本邮件
+func (m *SMTPMailer) Send(recipient, subject, body string) error {
+	addr := fmt.Sprintf("%s:%d", m.Host, m.Port)
+	// 没有配置用户名时不进行身份验证(例如本地的开发用SMTP服务器)
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	// 组装邮件头部与正文 头部与正文之间用空行分隔
+	msg := strings.Join([]string{
+		"From: " + m.Sender,
+		"To: " + recipient,
+		"Subject: " + subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+	return smtp.SendMail(addr, auth, m.Sender, []string{recipient}, []byte(msg))
+}
+
+// LogMailer 不发送邮件而是将内容输出到日志 用于没有配置SMTP服务器的开发环境
+type LogMailer struct {
+	Logger *log.Logger
+}
+
+func (m *LogMailer) Send(recipient, subject, body string) error {
+	m.Logger.Printf("mail to %s - %s\n%s", recipient, subject, body)
+	return nil
+}
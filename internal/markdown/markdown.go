@@ -0,0 +1,100 @@
+// Package markdown 将snippet中的Markdown文件转换为经过清理的HTML
+package markdown
+
+import (
+	"bytes"
+	"html/template"
+	"net/url"
+	"regexp"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// 代码高亮使用的class前缀 与ui/static/css/highlight.css一致
+const ClassPrefix = "hl-"
+
+// 代码块的高亮只输出class 内联的style会被CSP拦截
+var md = goldmark.New(
+	goldmark.WithExtensions(
+		extension.GFM,
+		highlighting.NewHighlighting(
+			highlighting.WithFormatOptions(
+				chromahtml.WithClasses(true),
+				chromahtml.ClassPrefix(ClassPrefix),
+			),
+		),
+	),
+	goldmark.WithParserOptions(
+		parser.WithASTTransformers(util.Prioritized(remoteImages{}, 100)),
+	),
+)
+
+// 白名单之外的标签与属性全部删除 原始的HTML在渲染时就已经被goldmark省略 这里是第二道防线
+var policy = newPolicy()
+
+func newPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	// 高亮生成的class与没有被高亮的代码块的语言
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^(`+ClassPrefix+`[a-z0-9]+ ?)+$`)).OnElements("pre", "span")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#-]+$`)).OnElements("code")
+	// 任务列表中的复选框
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").OnElements("input")
+	// 图片只允许使用本站的地址 其他网站的图片已经被转换为链接
+	p.AllowURLSchemes("http", "https", "mailto")
+	p.RequireNoFollowOnLinks(true)
+	p.RequireNoReferrerOnLinks(true)
+	return p
+}
+
+// Render 将Markdown转换为可以直接输出到模板中的HTML
+func Render(src string) (template.HTML, error) {
+	var buf bytes.Buffer
+	if err := md.Convert([]byte(src), &buf); err != nil {
+		return "", err
+	}
+	return template.HTML(policy.SanitizeBytes(buf.Bytes())), nil
+}
+
+// remoteImages 将其他网站的图片替换为指向图片的链接
+// CSP只允许加载本站的图片 同时避免浏览页面时向第三方泄露访客的信息
+type remoteImages struct{}
+
+func (remoteImages) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	var images []*ast.Image
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if img, ok := n.(*ast.Image); ok && entering && remote(string(img.Destination)) {
+			images = append(images, img)
+		}
+		return ast.WalkContinue, nil
+	})
+	// 遍历结束之后再修改树
+	for _, img := range images {
+		link := ast.NewLink()
+		link.Destination = img.Destination
+		link.Title = img.Title
+		for c := img.FirstChild(); c != nil; {
+			next := c.NextSibling()
+			link.AppendChild(link, c)
+			c = next
+		}
+		if !link.HasChildren() {
+			link.AppendChild(link, ast.NewString(img.Destination))
+		}
+		img.Parent().ReplaceChild(img.Parent(), img, link)
+	}
+}
+
+// 带有协议或者域名的地址视为其他网站
+func remote(dest string) bool {
+	u, err := url.Parse(dest)
+	return err != nil || u.Scheme != "" || u.Host != ""
+}
@@ -0,0 +1,69 @@
+package markdown
+
+import (
+	"SnippetBox.mikudayo.net/internal/assert"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		want     []string
+		wantNone []string
+	}{
+		{
+			name: "Basic",
+			src:  "# Runbook\n\n1. **重启** `nginx`\n2. ~~等待~~\n\n- [x] done",
+			want: []string{
+				"<h1>Runbook</h1>",
+				"<strong>重启</strong> <code>nginx</code>",
+				"<del>等待</del>",
+				`<input checked="" disabled="" type="checkbox">`,
+			},
+		},
+		{
+			name:     "Raw HTML",
+			src:      "<script>alert(1)</script>\n\n<img src=x onerror=alert(1)>\n\n<div style=\"color:red\">red</div>",
+			wantNone: []string{"<script", "onerror", "style=", "<div"},
+		},
+		{
+			name:     "Unsafe links",
+			src:      "[a](javascript:alert(1)) [b](data:text/html,x) [c](https://example.com)",
+			want:     []string{`<a href="https://example.com" rel="nofollow noreferrer">c</a>`},
+			wantNone: []string{"javascript:", "data:"},
+		},
+		{
+			name:     "Remote image",
+			src:      "![kitten](https://example.com/kitten.png) ![](//example.com/tracker.gif) ![logo](/static/img/logo.png)",
+			want:     []string{`<a href="https://example.com/kitten.png" rel="nofollow noreferrer">kitten</a>`, `<img src="/static/img/logo.png" alt="logo">`},
+			wantNone: []string{`src="https:`, `src="//`},
+		},
+		{
+			name:     "Fenced code",
+			src:      "```go\nfunc main() {}\n```",
+			want:     []string{`<pre class="hl-chroma"><code>`, `<span class="hl-kd">func</span>`},
+			wantNone: []string{"style=", "tabindex"},
+		},
+		{
+			name: "Fenced code without language",
+			src:  "```\n<b>plain</b>\n```",
+			want: []string{"&lt;b&gt;plain&lt;/b&gt;"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			html, err := Render(tt.src)
+			assert.NilError(t, err)
+			for _, want := range tt.want {
+				assert.StringContains(t, string(html), want)
+			}
+			for _, none := range tt.wantNone {
+				if strings.Contains(string(html), none) {
+					t.Errorf("got: %q;should not contain: %q", html, none)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package models
+
+import (
+	"database/sql"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 审计日志中记录的操作类型
+const (
+	// 注册 登入与退出 登入失败时记录尝试登入的账号(邮箱存在时)
+	AuditSignup      = "auth.signup"
+	AuditLogin       = "auth.login"
+	AuditLoginFailed = "auth.login_failed"
+	AuditLogout      = "auth.logout"
+	// 账号相关的操作
+	AuditPasswordChanged = "account.password_change"
+	AuditAccountDeleted  = "account.delete"
+	AuditDataExported    = "account.export"
+	AuditNameUpdated     = "account.name_update"
+	// 发起修改邮箱的请求与通过确认链接完成修改分别记录
+	AuditEmailChangeRequested = "account.email_change_request"
+	AuditEmailChanged         = "account.email_change"
+	// 创建snippet
+	AuditSnippetCreated = "snippet.create"
+	// 管理员与协管员的操作
+	AuditAdminUserDisabled   = "admin.user_disable"
+	AuditAdminUserEnabled    = "admin.user_enable"
+	AuditAdminPasswordReset  = "admin.password_reset"
+	AuditAdminRoleChanged    = "admin.role_change"
+	AuditAdminSnippetDeleted = "admin.snippet_delete"
+	// 处理举报 自动隐藏时记录触发隐藏的举报者
+	AuditModerationHidden    = "moderation.hide"
+	AuditModerationDismissed = "moderation.dismiss"
+	AuditModerationDeleted   = "moderation.delete"
+	AuditModerationRestored  = "moderation.restore"
+	AuditSnippetAutoHidden   = "moderation.auto_hide"
+)
+
+// AuditActions 所有的操作类型 用于管理页面中的筛选
+var AuditActions = []string{
+	AuditSignup, AuditLogin, AuditLoginFailed, AuditLogout,
+	AuditPasswordChanged, AuditAccountDeleted, AuditDataExported, AuditNameUpdated,
+	AuditEmailChangeRequested, AuditEmailChanged, AuditSnippetCreated,
+	AuditAdminUserDisabled, AuditAdminUserEnabled, AuditAdminPasswordReset, AuditAdminRoleChanged, AuditAdminSnippetDeleted,
+	AuditModerationHidden, AuditModerationDismissed, AuditModerationDeleted, AuditModerationRestored, AuditSnippetAutoHidden,
+}
+
+var auditActionLabels = map[string]string{
+	AuditSignup:               "注册",
+	AuditLogin:                "登入",
+	AuditLoginFailed:          "登入失败",
+	AuditLogout:               "退出",
+	AuditPasswordChanged:      "修改密码",
+	AuditAccountDeleted:       "注销账号",
+	AuditDataExported:         "导出个人数据",
+	AuditNameUpdated:          "修改昵称",
+	AuditEmailChangeRequested: "申请修改邮箱",
+	AuditEmailChanged:         "修改邮箱",
+	AuditSnippetCreated:       "创建消息",
+	AuditAdminUserDisabled:    "停用账号",
+	AuditAdminUserEnabled:     "启用账号",
+	AuditAdminPasswordReset:   "要求修改密码",
+	AuditAdminRoleChanged:     "修改角色",
+	AuditAdminSnippetDeleted:  "删除消息",
+	AuditModerationHidden:     "隐藏被举报的消息",
+	AuditModerationDismissed:  "驳回举报",
+	AuditModerationDeleted:    "删除被举报的消息",
+	AuditModerationRestored:   "恢复消息",
+	AuditSnippetAutoHidden:    "举报触发自动隐藏",
+	"auth.":                   "注册与登入",
+	"account.":                "账号",
+	"snippet.":                "消息",
+	"admin.":                  "管理",
+	"moderation.":             "审核",
+}
+
+// AuditActionLabel 返回操作类型或分类的说明 未知的类型原样返回
+func AuditActionLabel(action string) string {
+	if label, ok := auditActionLabels[action]; ok {
+		return label
+	}
+	return action
+}
+
+// AuditCategories 操作类型的分类 查询时按照前缀匹配
+var AuditCategories = []string{"auth.", "account.", "snippet.", "admin.", "moderation."}
+
+// ValidAuditAction 检查查询条件中的操作类型是否为已知的类型或分类
+func ValidAuditAction(action string) bool {
+	return slices.Contains(AuditActions, action) || slices.Contains(AuditCategories, action)
+}
+
+// 存储一条审计记录(与数据库中表的结构一致)
+type AuditEvent struct {
+	ID int
+	// 操作者 未登入或者账号不存在时为0
+	UserID    int
+	Action    string
+	IP        string
+	UserAgent string
+	// 请求的id 与请求日志中的id一致 用于关联同一个请求的日志
+	RequestID string
+	Details   string
+	Created   time.Time
+}
+
+// 每次查询最多返回的审计记录数
+const MaxAuditResults = 200
+
+// AuditQuery 管理页面中查询审计日志的条件 为零值的条件不进行筛选
+type AuditQuery struct {
+	UserID int
+	// AuditCategories中的分类按照前缀匹配 例如admin.匹配所有管理员的操作
+	Action string
+	IP     string
+	// 时间范围[From,To)
+	From time.Time
+	To   time.Time
+	// 最多返回的数量 超出MaxAuditResults时按照MaxAuditResults处理
+	Limit int
+}
+
+// AuditLogger 定义接口用于解决模拟依赖注入时编译报错的问题
+type AuditLogger interface {
+	Record(event *AuditEvent) error
+	ListByUser(userID int) ([]*AuditEvent, error)
+	Query(q AuditQuery) ([]*AuditEvent, error)
+}
+
+// 注入数据库依赖
+type AuditModel struct {
+	DB *sql.DB
+}
+
+// 追加一条审计记录 审计表只进行插入不会修改已有的记录
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *AuditModel) Record(event *AuditEvent) error {
+	stmt := `INSERT INTO audit_events(user_id,action,ip,user_agent,request_id,details,created)
+	VALUES(?,?,?,?,?,?,UTC_TIMESTAMP())`
+	_, err := m.DB.Exec(stmt, event.UserID, event.Action, event.IP, event.UserAgent, event.RequestID, event.Details)
+	return err
+}
+
+// 返回指定用户的所有审计记录 按时间倒序排列
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *AuditModel) ListByUser(userID int) ([]*AuditEvent, error) {
+	stmt := `SELECT id,user_id,action,ip,user_agent,request_id,details,created FROM audit_events
+	WHERE user_id = ?
+	ORDER BY id DESC`
+	return m.query(stmt, userID)
+}
+
+// Query 按照条件查询审计记录 按时间倒序排列
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *AuditModel) Query(q AuditQuery) ([]*AuditEvent, error) {
+	where := []string{"true"}
+	var args []any
+	if q.UserID != 0 {
+		where = append(where, "user_id = ?")
+		args = append(args, q.UserID)
+	}
+	switch {
+	case strings.HasSuffix(q.Action, "."):
+		where = append(where, "action LIKE ?")
+		args = append(args, q.Action+"%")
+	case q.Action != "":
+		where = append(where, "action = ?")
+		args = append(args, q.Action)
+	}
+	if q.IP != "" {
+		where = append(where, "ip = ?")
+		args = append(args, q.IP)
+	}
+	if !q.From.IsZero() {
+		where = append(where, "created >= ?")
+		args = append(args, q.From.UTC())
+	}
+	if !q.To.IsZero() {
+		where = append(where, "created < ?")
+		args = append(args, q.To.UTC())
+	}
+	limit := q.Limit
+	if limit < 1 || limit > MaxAuditResults {
+		limit = MaxAuditResults
+	}
+	args = append(args, limit)
+	stmt := `SELECT id,user_id,action,ip,user_agent,request_id,details,created FROM audit_events
+	WHERE ` + strings.Join(where, " AND ") + `
+	ORDER BY id DESC
+	LIMIT ?`
+	return m.query(stmt, args...)
+}
+
+// 执行查询并读取审计记录
+func (m *AuditModel) query(stmt string, args ...any) ([]*AuditEvent, error) {
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []*AuditEvent{}
+	for rows.Next() {
+		e := &AuditEvent{}
+		err = rows.Scan(&e.ID, &e.UserID, &e.Action, &e.IP, &e.UserAgent, &e.RequestID, &e.Details, &e.Created)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
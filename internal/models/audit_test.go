@@ -0,0 +1,59 @@
+package models
+
+import (
+	"SnippetBox.mikudayo.net/internal/assert"
+	"testing"
+	"time"
+)
+
+func TestValidAuditAction(t *testing.T) {
+	assert.Equal(t, ValidAuditAction(AuditLogin), true)
+	assert.Equal(t, ValidAuditAction("admin."), true)
+	assert.Equal(t, ValidAuditAction("admin"), false)
+	assert.Equal(t, ValidAuditAction("auth.%"), false)
+	assert.Equal(t, AuditActionLabel(AuditLoginFailed), "登入失败")
+	assert.Equal(t, AuditActionLabel("unknown"), "unknown")
+}
+
+func TestAuditModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models:skipping integration test...")
+	}
+	m := AuditModel{DB: newTestDB(t)}
+	events := []*AuditEvent{
+		{UserID: 39, Action: AuditLoginFailed, IP: "192.0.2.1", UserAgent: "curl", RequestID: "a1", Details: "reason=invalid_credentials"},
+		{UserID: 39, Action: AuditLogin, IP: "192.0.2.2", UserAgent: "Firefox", RequestID: "a2", Details: "method=password"},
+		{UserID: 2, Action: AuditAdminRoleChanged, IP: "192.0.2.2", UserAgent: "Firefox", RequestID: "a3", Details: "user_id=39 role=moderator"},
+	}
+	for _, e := range events {
+		assert.NilError(t, m.Record(e))
+	}
+
+	list, err := m.ListByUser(39)
+	assert.NilError(t, err)
+	assert.Equal(t, len(list), 2)
+	assert.Equal(t, list[0].Action, AuditLogin)
+	assert.Equal(t, list[0].RequestID, "a2")
+
+	tests := []struct {
+		name  string
+		query AuditQuery
+		want  int
+	}{
+		{"All", AuditQuery{}, 3},
+		{"User", AuditQuery{UserID: 2}, 1},
+		{"Action", AuditQuery{Action: AuditLogin}, 1},
+		{"Category", AuditQuery{Action: "auth."}, 2},
+		{"IP", AuditQuery{IP: "192.0.2.2"}, 2},
+		{"Time range", AuditQuery{From: time.Now().Add(-time.Hour), To: time.Now().Add(time.Hour)}, 3},
+		{"Future", AuditQuery{From: time.Now().Add(time.Hour)}, 0},
+		{"Limit", AuditQuery{Limit: 1}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.Query(tt.query)
+			assert.NilError(t, err)
+			assert.Equal(t, len(got), tt.want)
+		})
+	}
+}
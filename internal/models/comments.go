@@ -0,0 +1,152 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// 评论内容的最大长度(字符数)
+const MaxCommentLength = 2000
+
+// Comment snippet下的一条评论
+type Comment struct {
+	ID        int
+	SnippetID int
+	// 评论者的id 注销账号匿名化之后为0
+	UserID int
+	// 评论者的昵称 匿名化之后为空
+	Author string
+	// 评论针对的文件与行号 不针对某一行时File为空Line为0
+	File string
+	Line int
+	// 未经处理的原文 展示时再转换格式
+	Body    string
+	Created time.Time
+	// 没有编辑过时为零值
+	Updated time.Time
+}
+
+// Anchored 判断评论是否针对某一行
+func (c *Comment) Anchored() bool {
+	return c.Line > 0
+}
+
+// CommentModelInterface 定义接口用于解决模拟依赖注入时编译报错的问题
+type CommentModelInterface interface {
+	Insert(snippetID, userID int, file string, line int, body string) (int, error)
+	Get(id int) (*Comment, error)
+	BySnippet(snippetID int) ([]*Comment, error)
+	Update(id int, body string) error
+	Delete(id int) error
+}
+
+// 注入数据库依赖
+type CommentModel struct {
+	DB *sql.DB
+}
+
+// Insert 添加一条评论 file与line由调用方确认在snippet中存在
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *CommentModel) Insert(snippetID, userID int, file string, line int, body string) (int, error) {
+	stmt := `INSERT INTO comments(snippet_id,user_id,file_name,line,body,created)
+	VALUES(?,?,?,?,?,UTC_TIMESTAMP())`
+	res, err := m.DB.Exec(stmt, snippetID, userID, file, line, body)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+// Get 按照id查询评论 不存在时返回ErrNoRecord
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *CommentModel) Get(id int) (*Comment, error) {
+	stmt := `SELECT c.id,c.snippet_id,COALESCE(c.user_id,0),COALESCE(u.name,''),c.file_name,c.line,c.body,c.created,c.updated
+	FROM comments c LEFT JOIN users u ON u.id = c.user_id
+	WHERE c.id = ?`
+	c, err := scanComment(m.DB.QueryRow(stmt, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// BySnippet 返回snippet下的所有评论 从旧到新排列
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *CommentModel) BySnippet(snippetID int) ([]*Comment, error) {
+	stmt := `SELECT c.id,c.snippet_id,COALESCE(c.user_id,0),COALESCE(u.name,''),c.file_name,c.line,c.body,c.created,c.updated
+	FROM comments c LEFT JOIN users u ON u.id = c.user_id
+	WHERE c.snippet_id = ?
+	ORDER BY c.id`
+	rows, err := m.DB.Query(stmt, snippetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	comments := []*Comment{}
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+// Update 修改评论的内容并记录修改时间
+// 内容没有变化时MySQL不会计入受影响的行 所以由调用方先确认评论存在
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *CommentModel) Update(id int, body string) error {
+	stmt := `UPDATE comments SET body = ?, updated = UTC_TIMESTAMP() WHERE id = ?`
+	_, err := m.DB.Exec(stmt, body, id)
+	return err
+}
+
+// Delete 删除一条评论 不存在时返回ErrNoRecord
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *CommentModel) Delete(id int) error {
+	res, err := m.DB.Exec(`DELETE FROM comments WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// sql.Row与sql.Rows共同的扫描方法
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanComment(row rowScanner) (*Comment, error) {
+	c := &Comment{}
+	var updated sql.NullTime
+	err := row.Scan(&c.ID, &c.SnippetID, &c.UserID, &c.Author, &c.File, &c.Line, &c.Body, &c.Created, &updated)
+	if err != nil {
+		return nil, err
+	}
+	c.Updated = updated.Time
+	return c, nil
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"SnippetBox.mikudayo.net/internal/assert"
+	"testing"
+	"time"
+)
+
+func TestCommentModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models:skipping integration test...")
+	}
+	db := newTestDB(t)
+	snippets := SnippetModel{DB: db}
+	m := CommentModel{DB: db}
+	snippetID, err := snippets.Insert("miku", oneFile("go", "package main\nfunc main() {}"), time.Now().AddDate(0, 0, 7), 39, nil, false, false)
+	assert.NilError(t, err)
+
+	first, err := m.Insert(snippetID, 39, "", 0, "**mikudayo**")
+	assert.NilError(t, err)
+	second, err := m.Insert(snippetID, 39, "snippet.go", 2, "main")
+	assert.NilError(t, err)
+
+	comments, err := m.BySnippet(snippetID)
+	assert.NilError(t, err)
+	assert.Equal(t, len(comments), 2)
+	assert.Equal(t, comments[0].ID, first)
+	assert.Equal(t, comments[0].Author, "Miku")
+	assert.Equal(t, comments[0].Anchored(), false)
+	assert.Equal(t, comments[1].Anchored(), true)
+	assert.Equal(t, comments[1].File, "snippet.go")
+	assert.Equal(t, comments[1].Updated.IsZero(), true)
+
+	assert.NilError(t, m.Update(second, "func main"))
+	c, err := m.Get(second)
+	assert.NilError(t, err)
+	assert.Equal(t, c.Body, "func main")
+	assert.Equal(t, c.Updated.IsZero(), false)
+
+	assert.NilError(t, m.Delete(first))
+	assert.Equal(t, m.Delete(first), ErrNoRecord)
+
+	// 删除snippet时一并删除评论
+	assert.NilError(t, snippets.Delete(snippetID))
+	_, err = m.Get(second)
+	assert.Equal(t, err, ErrNoRecord)
+}
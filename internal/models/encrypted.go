@@ -0,0 +1,35 @@
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// 端到端加密的密文格式为 CiphertextPrefix + base64(12字节的IV + AES-GCM密文与16字节的认证标签)
+// 由ui/static/js/encrypted.js在浏览器中生成 服务器只检查格式
+const CiphertextPrefix = "e2e1:"
+
+const (
+	ciphertextIVSize  = 12
+	ciphertextTagSize = 16
+)
+
+// 加密后的内容合计的上限 base64编码增加了三分之一 每个文件还有IV与认证标签的开销
+const MaxCiphertextBytes = MaxContentBytes/3*4 + MaxFiles*64
+
+// 加密的snippet中包含不符合格式的内容
+var ErrInvalidCiphertext = errors.New("models:invalid ciphertext")
+
+// ValidCiphertext 检查内容是否是浏览器生成的密文 防止明文以加密的名义存入
+func ValidCiphertext(content string) bool {
+	data, ok := strings.CutPrefix(content, CiphertextPrefix)
+	if !ok {
+		return false
+	}
+	raw, err := base64.StdEncoding.Strict().DecodeString(data)
+	if err != nil {
+		return false
+	}
+	return len(raw) >= ciphertextIVSize+ciphertextTagSize
+}
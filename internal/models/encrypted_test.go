@@ -0,0 +1,62 @@
+package models
+
+import (
+	"SnippetBox.mikudayo.net/internal/assert"
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestValidCiphertext(t *testing.T) {
+	encode := func(n int) string {
+		return base64.StdEncoding.EncodeToString(make([]byte, n))
+	}
+	tests := []struct {
+		name    string
+		content string
+		want    bool
+	}{
+		{"Valid", CiphertextPrefix + encode(ciphertextIVSize+ciphertextTagSize+8), true},
+		{"Empty plaintext", CiphertextPrefix + encode(ciphertextIVSize+ciphertextTagSize), true},
+		{"Plaintext", "package main", false},
+		{"Missing prefix", encode(64), false},
+		{"Too short", CiphertextPrefix + encode(ciphertextIVSize+ciphertextTagSize-1), false},
+		{"Not base64", CiphertextPrefix + "mikudayo!", false},
+		{"Empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, ValidCiphertext(tt.content), tt.want)
+		})
+	}
+	// 64KB的明文加密后仍然在限制之内
+	assert.Equal(t, len(CiphertextPrefix+encode(MaxContentBytes+ciphertextIVSize+ciphertextTagSize)) <= MaxCiphertextBytes, true)
+}
+
+func TestSnippetModelEncrypted(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models:skipping integration test...")
+	}
+	m := SnippetModel{DB: newTestDB(t)}
+	week := time.Now().AddDate(0, 0, 7)
+	ciphertext := CiphertextPrefix + base64.StdEncoding.EncodeToString([]byte("0123456789ab mikudayo encrypted"))
+
+	// 明文不能以加密的名义存入
+	_, err := m.Insert("secret", oneFile("text", "mikudayo"), week, 39, nil, false, true)
+	assert.Equal(t, err, ErrInvalidCiphertext)
+
+	id, err := m.Insert("secret", oneFile("text", ciphertext), week, 39, nil, false, true)
+	assert.NilError(t, err)
+	s, err := m.Get(id)
+	assert.NilError(t, err)
+	assert.Equal(t, s.Encrypted, true)
+	assert.Equal(t, s.Files[0].Content, ciphertext)
+	// 合并的内容只用于搜索 加密的snippet不保存
+	assert.Equal(t, s.Content, "")
+
+	found, err := m.Search(SearchQuery{Query: "secret"})
+	assert.NilError(t, err)
+	assert.Equal(t, len(found), 0)
+	_, err = m.Fork(id, "secret fork", oneFile("text", ciphertext), week, 39, nil, false, true)
+	assert.Equal(t, err, ErrNoRecord)
+}
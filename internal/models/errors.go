@@ -9,4 +9,6 @@ var (
 	ErrInvalidCredentials = errors.New("models:invalid credential")
 	// 尝试通过一个重复的邮箱进行注册
 	ErrDuplicateEmail = errors.New("models:duplicate email")
+	// 账号已经被管理员停用
+	ErrAccountDisabled = errors.New("models:account disabled")
 )
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// 有效期的范围 最长按照日历计算为10年
+const (
+	MinExpiry      = time.Minute
+	MaxExpiryYears = 10
+)
+
+// NeverExpire 永不过期的snippet使用的过期时间
+// 使用一个足够远的时间而不是NULL 所有按照expires筛选的查询都不需要修改
+var NeverExpire = time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC)
+
+// 有效期的单位不存在或者超出了允许的范围
+var ErrInvalidExpiry = errors.New("models:invalid expiry")
+
+// 可以选择的有效期单位 月与年按照日历计算
+var expiryUnits = map[string]func(t time.Time, n int) time.Time{
+	"minute": func(t time.Time, n int) time.Time { return t.Add(time.Duration(n) * time.Minute) },
+	"hour":   func(t time.Time, n int) time.Time { return t.Add(time.Duration(n) * time.Hour) },
+	"day":    func(t time.Time, n int) time.Time { return t.AddDate(0, 0, n) },
+	"week":   func(t time.Time, n int) time.Time { return t.AddDate(0, 0, 7*n) },
+	"month":  func(t time.Time, n int) time.Time { return t.AddDate(0, n, 0) },
+	"year":   func(t time.Time, n int) time.Time { return t.AddDate(n, 0, 0) },
+}
+
+// ExpiryAfter 返回从now开始经过n个unit之后的过期时间
+func ExpiryAfter(now time.Time, n int, unit string) (time.Time, error) {
+	add, ok := expiryUnits[unit]
+	// 先限制数量 防止计算时溢出
+	if !ok || n < 1 || n > 100000 {
+		return time.Time{}, ErrInvalidExpiry
+	}
+	t := add(now, n).UTC()
+	if !ValidExpiry(now, t) {
+		return time.Time{}, ErrInvalidExpiry
+	}
+	// 数据库中只精确到秒
+	return t.Truncate(time.Second), nil
+}
+
+// ValidExpiry 检查过期时间是否在now之后的允许范围内
+func ValidExpiry(now, t time.Time) bool {
+	return t.Sub(now) >= MinExpiry && !t.After(now.AddDate(MaxExpiryYears, 0, 0))
+}
+
+// Permanent 判断snippet是否永不过期
+func (s *Snippet) Permanent() bool {
+	return !s.Expires.Before(NeverExpire)
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"SnippetBox.mikudayo.net/internal/assert"
+	"testing"
+	"time"
+)
+
+func TestExpiryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 31, 12, 0, 0, 500, time.UTC)
+	tests := []struct {
+		name    string
+		n       int
+		unit    string
+		want    time.Time
+		wantErr error
+	}{
+		{name: "Minutes", n: 10, unit: "minute", want: time.Date(2024, 1, 31, 12, 10, 0, 0, time.UTC)},
+		{name: "Hours", n: 2, unit: "hour", want: time.Date(2024, 1, 31, 14, 0, 0, 0, time.UTC)},
+		{name: "Week", n: 1, unit: "week", want: time.Date(2024, 2, 7, 12, 0, 0, 0, time.UTC)},
+		{name: "Calendar month", n: 1, unit: "month", want: time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC)},
+		{name: "Years", n: 10, unit: "year", want: time.Date(2034, 1, 31, 12, 0, 0, 0, time.UTC)},
+		{name: "Too long", n: 11, unit: "year", wantErr: ErrInvalidExpiry},
+		{name: "Zero", n: 0, unit: "day", wantErr: ErrInvalidExpiry},
+		{name: "Unknown unit", n: 1, unit: "fortnight", wantErr: ErrInvalidExpiry},
+		{name: "Overflow", n: 1 << 40, unit: "hour", wantErr: ErrInvalidExpiry},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExpiryAfter(now, tt.n, tt.unit)
+			assert.Equal(t, err, tt.wantErr)
+			if tt.wantErr == nil {
+				assert.Equal(t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnippetPermanent(t *testing.T) {
+	assert.Equal(t, (&Snippet{Expires: NeverExpire}).Permanent(), true)
+	assert.Equal(t, (&Snippet{Expires: time.Now().AddDate(1, 0, 0)}).Permanent(), false)
+}
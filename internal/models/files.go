@@ -0,0 +1,221 @@
+package models
+
+import (
+	"database/sql"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// 一条snippet中文件的数量 文件名长度与内容大小的限制
+const (
+	MaxFiles        = 10
+	MaxFileNameSize = 255
+	// 合并后的内容需要存入snippets.content(TEXT)
+	MaxContentBytes = 65535
+)
+
+// File snippet中的一个文件
+type File struct {
+	Name     string
+	Language string
+	Content  string
+}
+
+// 语言对应的常用扩展名 用于生成默认文件名与根据文件名推断语言
+var languageExts = map[string]string{
+	"text":       ".txt",
+	"go":         ".go",
+	"python":     ".py",
+	"javascript": ".js",
+	"typescript": ".ts",
+	"java":       ".java",
+	"c":          ".c",
+	"cpp":        ".cpp",
+	"rust":       ".rs",
+	"sql":        ".sql",
+	"shell":      ".sh",
+	"html":       ".html",
+	"css":        ".css",
+	"json":       ".json",
+	"yaml":       ".yaml",
+	"markdown":   ".md",
+}
+
+// DefaultFileName 返回只有一个文件时使用的默认文件名 例如snippet.go
+func DefaultFileName(language string) string {
+	ext, ok := languageExts[language]
+	if !ok {
+		ext = ".txt"
+	}
+	return "snippet" + ext
+}
+
+// LanguageFromName 根据文件名推断语言 无法推断时返回text
+func LanguageFromName(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	switch ext {
+	case ".yml":
+		return "yaml"
+	case ".h":
+		return "c"
+	case ".cc", ".hpp":
+		return "cpp"
+	case ".bash":
+		return "shell"
+	}
+	// Dockerfile等没有扩展名的文件按纯文本处理
+	for language, e := range languageExts {
+		if e == ext {
+			return language
+		}
+	}
+	return "text"
+}
+
+// ValidFileName 文件名不能包含路径分隔符 也不能以.开头 保证可以安全地用在raw地址与压缩包中
+func ValidFileName(name string) bool {
+	if name == "" || utf8.RuneCountInString(name) > MaxFileNameSize {
+		return false
+	}
+	if strings.HasPrefix(name, ".") || strings.ContainsAny(name, "/\\:*?\"<>|#%") {
+		return false
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// ContentSize 返回所有文件的内容合并后的字节数
+func ContentSize(files []*File) int {
+	return len(joinContent(files))
+}
+
+// 合并所有文件的内容 存入snippets.content用于全文搜索与摘要
+func joinContent(files []*File) string {
+	parts := make([]string, len(files))
+	for i, f := range files {
+		parts[i] = f.Content
+	}
+	return strings.Join(parts, "\n")
+}
+
+// 返回内容使用数据密钥加密后的文件副本 不修改传入的文件
+func sealFiles(dataKey []byte, snippetID int, files []*File) ([]*File, error) {
+	sealed := make([]*File, len(files))
+	for i, f := range files {
+		content, err := sealContent(dataKey, f.Content, snippetID, f.Name)
+		if err != nil {
+			return nil, err
+		}
+		sealed[i] = &File{Name: f.Name, Language: f.Language, Content: content}
+	}
+	return sealed, nil
+}
+
+// 在事务中写入snippet的所有文件
+//
+//goland:noinspection SqlNoDataSourceInspection
+func insertFiles(tx *sql.Tx, snippetID int, files []*File) error {
+	stmt := `INSERT INTO snippet_files(snippet_id,position,name,language,content) VALUES(?,?,?,?,?)`
+	for i, f := range files {
+		_, err := tx.Exec(stmt, snippetID, i, f.Name, f.Language, f.Content)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 一次查询取出多条snippet的所有文件 静态加密的内容在这里解密
+// 多文件功能之前创建的snippet没有文件记录 将内容作为一个文件返回
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *SnippetModel) attachFiles(snippets []*Snippet) error {
+	if len(snippets) == 0 {
+		return nil
+	}
+	byID := make(map[int]*Snippet, len(snippets))
+	args := make([]any, 0, len(snippets))
+	for _, s := range snippets {
+		s.Files = []*File{}
+		if _, ok := byID[s.ID]; !ok {
+			byID[s.ID] = s
+			args = append(args, s.ID)
+		}
+	}
+	stmt := `SELECT f.snippet_id,f.name,f.language,f.content,s.data_key,COALESCE(s.key_id,'')
+	FROM snippet_files f JOIN snippets s ON s.id = f.snippet_id
+	WHERE f.snippet_id IN (` + placeholders(len(args)) + `)
+	ORDER BY f.snippet_id, f.position`
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	// 每条snippet的数据密钥只解开一次
+	dataKeys := map[int][]byte{}
+	for rows.Next() {
+		var id int
+		var wrapped []byte
+		var keyID string
+		f := &File{}
+		if err = rows.Scan(&id, &f.Name, &f.Language, &f.Content, &wrapped, &keyID); err != nil {
+			return err
+		}
+		// 没有数据密钥的是启用静态加密之前保存的明文
+		if wrapped != nil {
+			if m.Keyring == nil {
+				return ErrNoKeyring
+			}
+			key, ok := dataKeys[id]
+			if !ok {
+				if key, err = m.Keyring.unwrap(keyID, wrapped); err != nil {
+					return err
+				}
+				dataKeys[id] = key
+			}
+			if f.Content, err = openContent(key, f.Content, id, f.Name); err != nil {
+				return err
+			}
+		}
+		byID[id].Files = append(byID[id].Files, f)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for _, s := range snippets {
+		if len(s.Files) == 0 {
+			s.Files = []*File{{Name: DefaultFileName(s.Language), Language: s.Language, Content: s.Content}}
+		}
+	}
+	return nil
+}
+
+// File 按照文件名查找snippet中的文件 找不到时返回nil
+func (s *Snippet) File(name string) *File {
+	for _, f := range s.Files {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// FileIndex 返回文件在snippet中的序号 找不到时返回-1
+func (s *Snippet) FileIndex(name string) int {
+	for i, f := range s.Files {
+		if f.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Lines 返回文件的行数 与展示时按照换行符拆分的结果一致
+func (f *File) Lines() int {
+	return strings.Count(f.Content, "\n") + 1
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"SnippetBox.mikudayo.net/internal/assert"
+	"strings"
+	"testing"
+)
+
+func TestValidFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		want bool
+	}{
+		{name: "Simple", file: "main.go", want: true},
+		{name: "No extension", file: "Dockerfile", want: true},
+		{name: "Chinese", file: "说明.md", want: true},
+		{name: "Empty", file: "", want: false},
+		{name: "Hidden", file: ".env", want: false},
+		{name: "Path", file: "../main.go", want: false},
+		{name: "Backslash", file: `a\b.go`, want: false},
+		{name: "Fragment", file: "a#b.go", want: false},
+		{name: "Control character", file: "a\nb.go", want: false},
+		{name: "Too long", file: strings.Repeat("a", MaxFileNameSize+1), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, ValidFileName(tt.file), tt.want)
+		})
+	}
+}
+
+func TestLanguageFromName(t *testing.T) {
+	tests := map[string]string{
+		"main.go":    "go",
+		"MAIN.PY":    "python",
+		"app.yml":    "yaml",
+		"util.h":     "c",
+		"run.bash":   "shell",
+		"Dockerfile": "text",
+		"a.unknown":  "text",
+	}
+	for name, want := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, LanguageFromName(name), want)
+		})
+	}
+	// 默认文件名可以推断回原来的语言
+	for _, language := range Languages {
+		assert.Equal(t, LanguageFromName(DefaultFileName(language)), language)
+	}
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// 原消息页面中最多列出的分支数
+const MaxForkList = 50
+
+// Fork 以parentID为原消息创建一个属于userID的分支
+// 原消息不存在 已经过期 被隐藏 是其他用户的私有消息或者是加密的消息时返回ErrNoRecord
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *SnippetModel) Fork(parentID int, title string, files []*File, expires time.Time, userID int, tags []string, private, encrypted bool) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	// 加上共享锁 防止原消息在创建分支的过程中被删除或修改有效期
+	stmt := `SELECT COALESCE(user_id,0), private, encrypted FROM snippets
+	WHERE id = ? AND expires > UTC_TIMESTAMP() AND hidden_reason = ''
+	LOCK IN SHARE MODE`
+	var ownerID int
+	var parentPrivate, parentEncrypted bool
+	err = tx.QueryRow(stmt, parentID).Scan(&ownerID, &parentPrivate, &parentEncrypted)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		}
+		return 0, err
+	}
+	// 私有消息对其他用户来说不存在
+	if parentPrivate && ownerID != userID {
+		return 0, ErrNoRecord
+	}
+	// 服务器无法读取加密消息的内容 也就无法以此为基础创建分支
+	if parentEncrypted {
+		return 0, ErrNoRecord
+	}
+	id, err := m.insertSnippet(tx, title, files, expires, userID, tags, private, encrypted, parentID)
+	if err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// Forks 返回snippet未过期的公开分支 从新到旧排列
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *SnippetModel) Forks(parentID int) ([]*Snippet, error) {
+	stmt := `SELECT id,title,content,created,expires,COALESCE(user_id,0),language,COALESCE(parent_id,0) FROM snippets
+	WHERE parent_id = ? AND expires > UTC_TIMESTAMP() AND NOT private AND hidden_reason = ''
+	ORDER BY id DESC
+	LIMIT ?`
+	rows, err := m.DB.Query(stmt, parentID, MaxForkList)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	snippets := []*Snippet{}
+	for rows.Next() {
+		s := &Snippet{}
+		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.UserID, &s.Language, &s.ParentID)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return snippets, nil
+}
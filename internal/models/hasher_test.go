@@ -0,0 +1,116 @@
+package models
+
+import (
+	"SnippetBox.mikudayo.net/internal/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 单元测试使用较小的参数 避免拖慢测试
+var testHasher = &PasswordHasher{
+	Algorithm:  HashArgon2id,
+	Argon2:     Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+	BcryptCost: 4,
+}
+
+func TestPasswordHasher(t *testing.T) {
+	argon2Hash, err := testHasher.Hash("mikudayo3939")
+	assert.NilError(t, err)
+	assert.Equal(t, strings.HasPrefix(argon2Hash, "$argon2id$v=19$m=1024,t=1,p=1$"), true)
+
+	bcryptHasher := *testHasher
+	bcryptHasher.Algorithm = HashBcrypt
+	bcryptHash, err := bcryptHasher.Hash("mikudayo3939")
+	assert.NilError(t, err)
+
+	// 参数更强的配置 用于判断旧参数生成的哈希需要重新生成
+	stronger := *testHasher
+	stronger.Argon2.Iterations = 2
+	stronger.BcryptCost = 5
+	strongerBcrypt := stronger
+	strongerBcrypt.Algorithm = HashBcrypt
+
+	tests := []struct {
+		name        string
+		hasher      *PasswordHasher
+		password    string
+		encoded     string
+		match       bool
+		needsRehash bool
+	}{
+		{name: "Argon2id", hasher: testHasher, password: "mikudayo3939", encoded: argon2Hash, match: true},
+		{name: "Argon2id wrong password", hasher: testHasher, password: "mikudayo39", encoded: argon2Hash},
+		{name: "Bcrypt", hasher: &bcryptHasher, password: "mikudayo3939", encoded: bcryptHash, match: true},
+		{name: "Bcrypt wrong password", hasher: &bcryptHasher, password: "mikudayo39", encoded: bcryptHash},
+		{name: "Bcrypt to Argon2id", hasher: testHasher, password: "mikudayo3939", encoded: bcryptHash, match: true, needsRehash: true},
+		{name: "Argon2id to bcrypt", hasher: &bcryptHasher, password: "mikudayo3939", encoded: argon2Hash, match: true, needsRehash: true},
+		{name: "Outdated Argon2id parameters", hasher: &stronger, password: "mikudayo3939", encoded: argon2Hash, match: true, needsRehash: true},
+		{name: "Outdated bcrypt cost", hasher: &strongerBcrypt, password: "mikudayo3939", encoded: bcryptHash, match: true, needsRehash: true},
+		{name: "No rehash on mismatch", hasher: &stronger, password: "mikudayo39", encoded: argon2Hash},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, needsRehash, err := tt.hasher.Verify(tt.password, tt.encoded)
+			assert.NilError(t, err)
+			assert.Equal(t, match, tt.match)
+			assert.Equal(t, needsRehash, tt.needsRehash)
+		})
+	}
+	t.Run("Unknown format", func(t *testing.T) {
+		_, _, err := testHasher.Verify("mikudayo3939", "plaintext")
+		assert.Equal(t, err, ErrUnknownHash)
+		_, _, err = testHasher.Verify("mikudayo3939", "$argon2id$v=19$m=1024$salt$key")
+		assert.Equal(t, err, ErrUnknownHash)
+	})
+}
+
+func TestPasswordHasherValidate(t *testing.T) {
+	assert.NilError(t, DefaultPasswordHasher.Validate())
+	assert.NilError(t, testHasher.Validate())
+
+	invalid := *DefaultPasswordHasher
+	invalid.Algorithm = "md5"
+	assert.Equal(t, invalid.Validate() != nil, true)
+
+	invalid = *DefaultPasswordHasher
+	invalid.Argon2.Iterations = 0
+	assert.Equal(t, invalid.Validate() != nil, true)
+
+	invalid = *DefaultPasswordHasher
+	invalid.Algorithm = HashBcrypt
+	invalid.BcryptCost = 40
+	assert.Equal(t, invalid.Validate() != nil, true)
+}
+
+// 一次登入中验证密码的目标耗时
+// 太快意味着离线破解的成本过低 太慢则会拖慢登入并放大拒绝服务的风险
+const (
+	minLoginHashLatency = 50 * time.Millisecond
+	maxLoginHashLatency = 1000 * time.Millisecond
+)
+
+// 基准测试验证默认参数在当前机器上的耗时落在目标范围内 go test -run=^$ -bench=PasswordHasher ./internal/models
+func BenchmarkPasswordHasherVerify(b *testing.B) {
+	bcryptHasher := *DefaultPasswordHasher
+	bcryptHasher.Algorithm = HashBcrypt
+	for _, h := range []*PasswordHasher{DefaultPasswordHasher, &bcryptHasher} {
+		b.Run(h.Algorithm, func(b *testing.B) {
+			encoded, err := h.Hash("mikudayo3939")
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if match, _, err := h.Verify("mikudayo3939", encoded); err != nil || !match {
+					b.Fatal("password did not match")
+				}
+			}
+			perOp := b.Elapsed() / time.Duration(b.N)
+			b.ReportMetric(float64(perOp.Microseconds())/1000, "ms/login")
+			if perOp < minLoginHashLatency || perOp > maxLoginHashLatency {
+				b.Errorf("%s verify took %s, want between %s and %s; retune DefaultPasswordHasher", h.Algorithm, perOp, minLoginHashLatency, maxLoginHashLatency)
+			}
+		})
+	}
+}
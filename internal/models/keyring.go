@@ -0,0 +1,207 @@
+package models
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 静态加密(信封加密) 每条snippet使用随机生成的数据密钥加密文件内容
+// 数据密钥由主密钥包装后与snippet保存在一起 更换主密钥时只需要重新包装数据密钥
+
+const (
+	// 主密钥与数据密钥都是AES-256的密钥
+	MasterKeySize = 32
+	dataKeySize   = 32
+	// 静态加密后的内容格式为 sealedPrefix + base64(12字节的nonce + 密文与认证标签)
+	sealedPrefix = "enc1:"
+)
+
+var (
+	// 数据密钥使用的主密钥不在密钥环中
+	ErrUnknownMasterKey = errors.New("models:unknown master key")
+	// snippet已经静态加密 但是没有配置主密钥
+	ErrNoKeyring = errors.New("models:snippet is encrypted at rest but no master key is configured")
+)
+
+// Keyring 主密钥环 第一个主密钥用于包装新的数据密钥 其余的只用于解开旧的数据密钥
+// 主密钥的id为密钥SHA-256的前16位十六进制字符 不需要另外配置
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring 使用一个或多个主密钥创建密钥环 第一个为当前使用的主密钥
+func NewKeyring(keys ...[]byte) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("models:at least one master key is required")
+	}
+	k := &Keyring{keys: map[string]cipher.AEAD{}}
+	for i, key := range keys {
+		if len(key) != MasterKeySize {
+			return nil, fmt.Errorf("models:master key must be %d bytes, got %d", MasterKeySize, len(key))
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		id := KeyID(key)
+		if i == 0 {
+			k.current = id
+		}
+		k.keys[id] = aead
+	}
+	return k, nil
+}
+
+// ParseKeyring 解析密钥文件 每行一个base64编码的主密钥 第一个为当前使用的主密钥
+// 空行与#开头的行会被忽略
+func ParseKeyring(text string) (*Keyring, error) {
+	var keys [][]byte
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			return nil, fmt.Errorf("models:invalid master key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return NewKeyring(keys...)
+}
+
+// OpenKeyring 按照配置读取主密钥 key为base64编码的单个主密钥 path为密钥文件
+// 两者都为空时返回nil 表示不启用静态加密
+func OpenKeyring(key, path string) (*Keyring, error) {
+	switch {
+	case key != "" && path != "":
+		return nil, errors.New("models:only one of master key and key file can be set")
+	case key != "":
+		return ParseKeyring(key)
+	case path != "":
+		text, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return ParseKeyring(string(text))
+	default:
+		return nil, nil
+	}
+}
+
+// KeyID 返回主密钥的id
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// CurrentID 返回当前使用的主密钥的id
+func (k *Keyring) CurrentID() string {
+	return k.current
+}
+
+// 生成新的数据密钥 返回数据密钥与使用当前主密钥包装后的结果
+func (k *Keyring) newDataKey() (dataKey, wrapped []byte, err error) {
+	dataKey = make([]byte, dataKeySize)
+	if _, err = rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+	wrapped, err = seal(k.keys[k.current], dataKey, []byte(k.current))
+	if err != nil {
+		return nil, nil, err
+	}
+	return dataKey, wrapped, nil
+}
+
+// 使用keyID对应的主密钥解开数据密钥 主密钥的id作为附加数据 防止包装结果被挪用到其他id下
+func (k *Keyring) unwrap(keyID string, wrapped []byte) ([]byte, error) {
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, ErrUnknownMasterKey
+	}
+	return open(aead, wrapped, []byte(keyID))
+}
+
+// 使用当前的主密钥重新包装数据密钥 数据密钥本身不变 所以不需要重新加密内容
+func (k *Keyring) rewrap(keyID string, wrapped []byte) ([]byte, error) {
+	dataKey, err := k.unwrap(keyID, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	return seal(k.keys[k.current], dataKey, []byte(k.current))
+}
+
+// 使用数据密钥加密内容 结果可以直接存入文本列
+// snippet的id与文件名作为附加数据 密文不能被换到其他文件或者同一个数据密钥的其他位置
+func sealContent(dataKey []byte, content string, snippetID int, name string) (string, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(aead, []byte(content), contentAAD(snippetID, name))
+	if err != nil {
+		return "", err
+	}
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// 使用数据密钥解密sealContent的结果
+func openContent(dataKey []byte, content string, snippetID int, name string) (string, error) {
+	data, ok := strings.CutPrefix(content, sealedPrefix)
+	if !ok {
+		return "", errors.New("models:content is not encrypted at rest")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plain, err := open(aead, sealed, contentAAD(snippetID, name))
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// 文件名中不能包含/ 所以拼接的结果不会有歧义
+func contentAAD(snippetID int, name string) []byte {
+	return []byte(strconv.Itoa(snippetID) + "/" + name)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// 返回随机的nonce与密文拼接的结果
+func seal(aead cipher.AEAD, plain, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, additional), nil
+}
+
+func open(aead cipher.AEAD, sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return nil, errors.New("models:sealed data is too short")
+	}
+	n := aead.NonceSize()
+	return aead.Open(nil, sealed[:n], sealed[n:], additional)
+}
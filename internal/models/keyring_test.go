@@ -0,0 +1,210 @@
+package models
+
+import (
+	"SnippetBox.mikudayo.net/internal/assert"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 测试使用的主密钥 每个字节都是b
+func testMasterKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, MasterKeySize)
+}
+
+func TestParseKeyring(t *testing.T) {
+	first := base64.StdEncoding.EncodeToString(testMasterKey(1))
+	second := base64.StdEncoding.EncodeToString(testMasterKey(2))
+
+	k, err := ParseKeyring("# 新的主密钥在第一行\n" + first + "\n\n  " + second + "  \n")
+	assert.NilError(t, err)
+	assert.Equal(t, k.CurrentID(), KeyID(testMasterKey(1)))
+	assert.Equal(t, len(k.keys), 2)
+	assert.Equal(t, len(k.CurrentID()), 16)
+
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"Empty", "\n# nothing\n"},
+		{"Not base64", "mikudayo!"},
+		{"Too short", base64.StdEncoding.EncodeToString(make([]byte, 16))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseKeyring(tt.text)
+			assert.Equal(t, err != nil, true)
+		})
+	}
+}
+
+func TestOpenKeyring(t *testing.T) {
+	k, err := OpenKeyring("", "")
+	assert.NilError(t, err)
+	assert.Equal(t, k == nil, true)
+
+	path := filepath.Join(t.TempDir(), "master.key")
+	encoded := base64.StdEncoding.EncodeToString(testMasterKey(1))
+	assert.NilError(t, os.WriteFile(path, []byte(encoded+"\n"), 0600))
+	k, err = OpenKeyring("", path)
+	assert.NilError(t, err)
+	assert.Equal(t, k.CurrentID(), KeyID(testMasterKey(1)))
+	k, err = OpenKeyring(encoded, "")
+	assert.NilError(t, err)
+	assert.Equal(t, k.CurrentID(), KeyID(testMasterKey(1)))
+
+	_, err = OpenKeyring(encoded, path)
+	assert.Equal(t, err != nil, true)
+}
+
+func TestKeyringWrap(t *testing.T) {
+	oldRing, err := NewKeyring(testMasterKey(1))
+	assert.NilError(t, err)
+	dataKey, wrapped, err := oldRing.newDataKey()
+	assert.NilError(t, err)
+	assert.Equal(t, bytes.Contains(wrapped, dataKey), false)
+	got, err := oldRing.unwrap(oldRing.CurrentID(), wrapped)
+	assert.NilError(t, err)
+	assert.Equal(t, bytes.Equal(got, dataKey), true)
+
+	// 新的密钥环保留旧的主密钥 重新包装后只需要新的主密钥
+	newRing, err := NewKeyring(testMasterKey(2), testMasterKey(1))
+	assert.NilError(t, err)
+	rewrapped, err := newRing.rewrap(oldRing.CurrentID(), wrapped)
+	assert.NilError(t, err)
+	onlyNew, err := NewKeyring(testMasterKey(2))
+	assert.NilError(t, err)
+	got, err = onlyNew.unwrap(onlyNew.CurrentID(), rewrapped)
+	assert.NilError(t, err)
+	assert.Equal(t, bytes.Equal(got, dataKey), true)
+
+	_, err = onlyNew.unwrap(oldRing.CurrentID(), wrapped)
+	assert.Equal(t, errors.Is(err, ErrUnknownMasterKey), true)
+	// 包装结果不能挪用到其他主密钥的id下
+	_, err = newRing.unwrap(oldRing.CurrentID(), rewrapped)
+	assert.Equal(t, err != nil, true)
+}
+
+func TestSealContent(t *testing.T) {
+	dataKey := testMasterKey(3)
+	sealed, err := sealContent(dataKey, "mikudayo 初音未来", 39, "miku.txt")
+	assert.NilError(t, err)
+	assert.Equal(t, strings.HasPrefix(sealed, sealedPrefix), true)
+	assert.Equal(t, strings.Contains(sealed, "mikudayo"), false)
+	// 每次加密使用不同的nonce
+	again, err := sealContent(dataKey, "mikudayo 初音未来", 39, "miku.txt")
+	assert.NilError(t, err)
+	assert.Equal(t, sealed == again, false)
+
+	plain, err := openContent(dataKey, sealed, 39, "miku.txt")
+	assert.NilError(t, err)
+	assert.Equal(t, plain, "mikudayo 初音未来")
+
+	_, err = openContent(testMasterKey(4), sealed, 39, "miku.txt")
+	assert.Equal(t, err != nil, true)
+	raw, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, sealedPrefix))
+	raw[len(raw)-1] ^= 1
+	_, err = openContent(dataKey, sealedPrefix+base64.StdEncoding.EncodeToString(raw), 39, "miku.txt")
+	assert.Equal(t, err != nil, true)
+	_, err = openContent(dataKey, "mikudayo", 39, "miku.txt")
+	assert.Equal(t, err != nil, true)
+	// 密文换到其他snippet或者其他文件下都无法解密
+	_, err = openContent(dataKey, sealed, 3939, "miku.txt")
+	assert.Equal(t, err != nil, true)
+	_, err = openContent(dataKey, sealed, 39, "luka.txt")
+	assert.Equal(t, err != nil, true)
+}
+
+func TestSnippetModelAtRest(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models:skipping integration test...")
+	}
+	db := newTestDB(t)
+	oldRing, err := NewKeyring(testMasterKey(1))
+	assert.NilError(t, err)
+	m := SnippetModel{DB: db, Keyring: oldRing}
+	week := time.Now().AddDate(0, 0, 7)
+	files := []*File{
+		{Name: "main.go", Language: "go", Content: "package main // mikudayo"},
+		{Name: "README.md", Language: "markdown", Content: "# 初音未来"},
+	}
+	id, err := m.Insert("miku", files, week, 39, nil, false, false)
+	assert.NilError(t, err)
+	// 调用方传入的文件不会被修改
+	assert.Equal(t, files[0].Content, "package main // mikudayo")
+
+	// 数据库中的内容列不包含明文
+	assertNoPlaintext := func(t *testing.T) {
+		var content string
+		assert.NilError(t, db.QueryRow(`SELECT content FROM snippets WHERE id = ?`, id).Scan(&content))
+		assert.Equal(t, content, "")
+		rows, err := db.Query(`SELECT content FROM snippet_files WHERE snippet_id = ?`, id)
+		assert.NilError(t, err)
+		defer rows.Close()
+		n := 0
+		for rows.Next() {
+			assert.NilError(t, rows.Scan(&content))
+			assert.Equal(t, strings.HasPrefix(content, sealedPrefix), true)
+			assert.Equal(t, strings.Contains(content, "mikudayo") || strings.Contains(content, "初音未来"), false)
+			n++
+		}
+		assert.NilError(t, rows.Err())
+		assert.Equal(t, n, 2)
+	}
+	assertNoPlaintext(t)
+
+	s, err := m.Get(id)
+	assert.NilError(t, err)
+	assert.Equal(t, s.Files[0].Content, "package main // mikudayo")
+	assert.Equal(t, s.Files[1].Content, "# 初音未来")
+	_, err = (&SnippetModel{DB: db}).Get(id)
+	assert.Equal(t, err, ErrNoKeyring)
+
+	// 启用静态加密之前保存的明文
+	plain := SnippetModel{DB: db}
+	legacy, err := plain.Insert("legacy", oneFile("text", "mikudayo legacy"), week, 39, nil, false, false)
+	assert.NilError(t, err)
+
+	// 轮换期间密钥环同时包含新旧主密钥
+	newRing, err := NewKeyring(testMasterKey(2), testMasterKey(1))
+	assert.NilError(t, err)
+	m.Keyring = newRing
+	n, err := m.RotateKeys(1)
+	assert.NilError(t, err)
+	assert.Equal(t, n, 1)
+	n, err = m.RotateKeys(100)
+	assert.NilError(t, err)
+	assert.Equal(t, n, 1)
+	n, err = m.RotateKeys(100)
+	assert.NilError(t, err)
+	assert.Equal(t, n, 0)
+	assertNoPlaintext(t)
+
+	// 轮换完成后不再需要旧的主密钥
+	onlyNew, err := NewKeyring(testMasterKey(2))
+	assert.NilError(t, err)
+	m.Keyring = onlyNew
+	s, err = m.Get(id)
+	assert.NilError(t, err)
+	assert.Equal(t, s.Files[1].Content, "# 初音未来")
+	s, err = m.Get(legacy)
+	assert.NilError(t, err)
+	assert.Equal(t, s.Files[0].Content, "mikudayo legacy")
+	var content string
+	assert.NilError(t, db.QueryRow(`SELECT content FROM snippet_files WHERE snippet_id = ?`, legacy).Scan(&content))
+	assert.Equal(t, strings.Contains(content, "mikudayo"), false)
+
+	// 交换同一条snippet中两个文件的密文后无法解密
+	var first, second string
+	assert.NilError(t, db.QueryRow(`SELECT content FROM snippet_files WHERE snippet_id = ? AND position = 0`, id).Scan(&first))
+	assert.NilError(t, db.QueryRow(`SELECT content FROM snippet_files WHERE snippet_id = ? AND position = 1`, id).Scan(&second))
+	_, err = db.Exec(`UPDATE snippet_files SET content = IF(position = 0, ?, ?) WHERE snippet_id = ?`, second, first, id)
+	assert.NilError(t, err)
+	_, err = m.Get(id)
+	assert.Equal(t, err != nil, true)
+}
@@ -0,0 +1,65 @@
+package mocks
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"SnippetBox.mikudayo.net/internal/models"
+)
+
+// AuditModel 将记录保存在内存中 便于测试检查处理器是否写入了审计日志
+type AuditModel struct {
+	mu     sync.Mutex
+	Events []*models.AuditEvent
+}
+
+func (m *AuditModel) Record(event *models.AuditEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// 与数据库一致 由写入时的时间决定
+	if event.Created.IsZero() {
+		event.Created = time.Now().UTC()
+	}
+	m.Events = append(m.Events, event)
+	return nil
+}
+
+func (m *AuditModel) ListByUser(userID int) ([]*models.AuditEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// 与数据库一致 新的在前
+	events := []*models.AuditEvent{}
+	for i := len(m.Events) - 1; i >= 0; i-- {
+		if m.Events[i].UserID == userID {
+			events = append(events, m.Events[i])
+		}
+	}
+	return events, nil
+}
+
+// 按照条件筛选内存中的记录 新的在前
+func (m *AuditModel) Query(q models.AuditQuery) ([]*models.AuditEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	limit := q.Limit
+	if limit < 1 || limit > models.MaxAuditResults {
+		limit = models.MaxAuditResults
+	}
+	events := []*models.AuditEvent{}
+	for i := len(m.Events) - 1; i >= 0 && len(events) < limit; i-- {
+		e := m.Events[i]
+		switch {
+		case q.UserID != 0 && e.UserID != q.UserID:
+		case strings.HasSuffix(q.Action, ".") && !strings.HasPrefix(e.Action, q.Action):
+		case q.Action != "" && !strings.HasSuffix(q.Action, ".") && e.Action != q.Action:
+		case q.IP != "" && e.IP != q.IP:
+		// 时间范围[From,To)
+		case !q.From.IsZero() && e.Created.Before(q.From):
+		case !q.To.IsZero() && !e.Created.Before(q.To):
+		default:
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
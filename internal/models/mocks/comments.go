@@ -0,0 +1,76 @@
+package mocks
+
+import (
+	"time"
+
+	"SnippetBox.mikudayo.net/internal/models"
+)
+
+// mockSnippet(39)下的评论 1由创建者Miku(39)发表 2由Rin(2)针对main.go的第1行发表
+// 3由Rin(2)在自己创建的mockFork(40)下发表
+var mockComments = []*models.Comment{
+	{
+		ID:        1,
+		SnippetID: 39,
+		UserID:    39,
+		Author:    "Miku",
+		Body:      "**mikudayo** `go run .`",
+		Created:   time.Now(),
+	},
+	{
+		ID:        2,
+		SnippetID: 39,
+		UserID:    2,
+		Author:    "Rin",
+		File:      "main.go",
+		Line:      1,
+		Body:      "<script>alert('rin')</script>",
+		Created:   time.Now(),
+	},
+	{
+		ID:        3,
+		SnippetID: 40,
+		UserID:    2,
+		Author:    "Rin",
+		Body:      "rindayo",
+		Created:   time.Now(),
+	},
+}
+
+// CommentModel 不链接真实的数据库
+type CommentModel struct {
+}
+
+func (m *CommentModel) Insert(snippetID, userID int, file string, line int, body string) (int, error) {
+	return 4, nil
+}
+
+func (m *CommentModel) Get(id int) (*models.Comment, error) {
+	for _, c := range mockComments {
+		if c.ID == id {
+			return c, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+func (m *CommentModel) BySnippet(snippetID int) ([]*models.Comment, error) {
+	comments := []*models.Comment{}
+	for _, c := range mockComments {
+		if c.SnippetID == snippetID {
+			comments = append(comments, c)
+		}
+	}
+	return comments, nil
+}
+
+func (m *CommentModel) Update(id int, body string) error {
+	return nil
+}
+
+func (m *CommentModel) Delete(id int) error {
+	if _, err := m.Get(id); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package mocks
+
+import "sync"
+
+// Mail 存储一封被"发送"的邮件
+type Mail struct {
+	Recipient string
+	Subject   string
+	Body      string
+}
+
+// Mailer 不发送邮件 只将邮件保存在内存中供测试检查
+type Mailer struct {
+	mu   sync.Mutex
+	Sent []Mail
+}
+
+func (m *Mailer) Send(recipient, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Sent = append(m.Sent, Mail{Recipient: recipient, Subject: subject, Body: body})
+	return nil
+}
@@ -0,0 +1,47 @@
+package mocks
+
+import (
+	"time"
+
+	"SnippetBox.mikudayo.net/internal/models"
+)
+
+// ReportModel Luka(1)已经举报过Rin(2)的分支 举报为恶意软件时会触发自动隐藏
+type ReportModel struct {
+	// 处理过举报的snippet与处理结果
+	Resolved map[int]string
+}
+
+func (m *ReportModel) Insert(snippetID, userID int, reason, details string) (bool, error) {
+	if snippetID == 40 && userID == 1 {
+		return false, models.ErrDuplicateReport
+	}
+	return reason == models.ReportMalware, nil
+}
+
+// 审核队列中只有被隐藏的snippet 收到了两条举报
+func (m *ReportModel) Queue() ([]*models.ReportedSnippet, error) {
+	return []*models.ReportedSnippet{
+		{
+			SnippetID:    43,
+			Title:        "spam",
+			UserID:       2,
+			HiddenReason: models.ReportSpam,
+			Reports: []*models.Report{
+				{ID: 1, SnippetID: 43, UserID: 39, Reason: models.ReportSpam, Details: "mikureport", Status: models.ReportOpen, Created: time.Now()},
+				{ID: 2, SnippetID: 43, UserID: 1, Reason: models.ReportAbuse, Status: models.ReportOpen, Created: time.Now()},
+			},
+		},
+	}, nil
+}
+
+func (m *ReportModel) Resolve(snippetID int, status string) (int, error) {
+	if m.Resolved == nil {
+		m.Resolved = map[int]string{}
+	}
+	m.Resolved[snippetID] = status
+	if snippetID == 43 {
+		return 2, nil
+	}
+	return 0, nil
+}
@@ -2,32 +2,153 @@ package mocks
 
 import (
 	"SnippetBox.mikudayo.net/internal/models"
+	"slices"
+	"strings"
 	"time"
 )
 
 // 创建固定的snippet信息用于测试
 var mockSnippet = &models.Snippet{
-	ID:      39,
-	Title:   "miku",
-	Content: "mikudayo",
-	Created: time.Now(),
-	Expires: time.Now(),
+	ID:       39,
+	Title:    "miku",
+	Content:  "mikudayo",
+	Created:  time.Now(),
+	Expires:  time.Now().Add(2 * time.Hour),
+	UserID:   39,
+	Language: "go",
+	Tags:     []string{"k8s", "oncall"},
+	Files: []*models.File{
+		{Name: "main.go", Language: "go", Content: "mikudayo"},
+		{Name: "Dockerfile", Language: "text", Content: "FROM golang:1.24"},
+	},
+	ForkCount: 1,
+	Stars:     3,
 }
 
+// mockFork中的Markdown文件 包含需要被清理的脚本与其他网站的图片
+const mockMarkdown = "# rindayo\n\n<script>alert('rin')</script>\n\n" +
+	"```go\nfmt.Println(\"rin\")\n```\n\n![len](https://example.com/len.png) ![logo](/static/img/logo.png)\n"
+
+// Rin(2)从mockSnippet创建的分支
+var mockFork = &models.Snippet{
+	ID:       40,
+	Title:    "miku fork",
+	Content:  "rindayo",
+	Created:  time.Now(),
+	Expires:  time.Now().Add(2 * time.Hour),
+	UserID:   2,
+	Language: "go",
+	Tags:     []string{},
+	Files: []*models.File{
+		{Name: "main.go", Language: "go", Content: "rindayo"},
+		{Name: "README.md", Language: "markdown", Content: mockMarkdown},
+	},
+	ParentID: 39,
+}
+
+// Miku(39)的私有snippet
+var mockPrivateSnippet = &models.Snippet{
+	ID:       41,
+	Title:    "secret",
+	Content:  "mikusecret",
+	Created:  time.Now(),
+	Expires:  time.Now().Add(2 * time.Hour),
+	UserID:   39,
+	Language: "text",
+	Tags:     []string{},
+	Files:    []*models.File{{Name: "snippet.txt", Language: "text", Content: "mikusecret"}},
+	Private:  true,
+}
+
+// Miku(39)的端到端加密snippet
+var mockEncryptedSnippet = &models.Snippet{
+	ID:        42,
+	Title:     "encrypted",
+	Created:   time.Now(),
+	Expires:   time.Now().Add(2 * time.Hour),
+	UserID:    39,
+	Language:  "text",
+	Tags:      []string{},
+	Files:     []*models.File{{Name: "snippet.txt", Language: "text", Content: MockCiphertext}},
+	Encrypted: true,
+}
+
+// Rin(2)被举报为垃圾信息后隐藏的snippet
+var mockHiddenSnippet = &models.Snippet{
+	ID:           43,
+	Title:        "spam",
+	Content:      "rinspam",
+	Created:      time.Now(),
+	Expires:      time.Now().Add(2 * time.Hour),
+	UserID:       2,
+	Language:     "text",
+	Tags:         []string{},
+	Files:        []*models.File{{Name: "snippet.txt", Language: "text", Content: "rinspam"}},
+	HiddenReason: models.ReportSpam,
+}
+
+// Rin(2)因侵犯版权隐藏的snippet
+var mockCopyrightSnippet = &models.Snippet{
+	ID:           44,
+	Title:        "lyrics",
+	Content:      "rinlyrics",
+	Created:      time.Now(),
+	Expires:      time.Now().Add(2 * time.Hour),
+	UserID:       2,
+	Language:     "text",
+	Tags:         []string{},
+	Files:        []*models.File{{Name: "snippet.txt", Language: "text", Content: "rinlyrics"}},
+	HiddenReason: models.ReportCopyright,
+}
+
+// MockCiphertext 符合格式的密文 12字节的IV与24字节的密文与认证标签
+const MockCiphertext = models.CiphertextPrefix + "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8gISIj"
+
 // MockSnippetModel 不链接真实的数据库
 type SnippetModel struct {
 }
 
 // 测试错误数据是否都正确返回
 
-func (m *SnippetModel) Insert(title string, content string, expires int) (int, error) {
+func (m *SnippetModel) Insert(title string, files []*models.File, expires time.Time, userID int, tags []string, private, encrypted bool) (int, error) {
 	return 2, nil
 }
 
+// 新的分支id为3 私有snippet只有创建者可以创建分支
+func (m *SnippetModel) Fork(parentID int, title string, files []*models.File, expires time.Time, userID int, tags []string, private, encrypted bool) (int, error) {
+	switch {
+	case parentID == 39 || parentID == 40:
+		return 3, nil
+	case parentID == 41 && userID == 39:
+		return 3, nil
+	default:
+		return 0, models.ErrNoRecord
+	}
+}
+
+func (m *SnippetModel) Forks(parentID int) ([]*models.Snippet, error) {
+	switch parentID {
+	case 39:
+		return []*models.Snippet{mockFork}, nil
+	default:
+		return []*models.Snippet{}, nil
+	}
+}
+
 func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
 	switch id {
 	case 39:
 		return mockSnippet, nil
+	case 40:
+		return mockFork, nil
+	case 41:
+		return mockPrivateSnippet, nil
+	case 42:
+		return mockEncryptedSnippet, nil
+	case 43:
+		return mockHiddenSnippet, nil
+	case 44:
+		return mockCopyrightSnippet, nil
 	default:
 		return nil, models.ErrNoRecord
 	}
@@ -36,3 +157,74 @@ func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
 func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
 	return []*models.Snippet{mockSnippet}, nil
 }
+
+// 只有一页数据 第一页之后的游标都返回空页
+func (m *SnippetModel) Page(q models.PageQuery) (*models.SnippetPage, error) {
+	switch {
+	case q.Tag != "" && !slices.Contains(mockSnippet.Tags, q.Tag):
+		return &models.SnippetPage{Snippets: []*models.Snippet{}}, nil
+	case q.After != nil:
+		return &models.SnippetPage{Snippets: []*models.Snippet{}, Prev: q.After}, nil
+	case q.Before != nil:
+		return &models.SnippetPage{Snippets: []*models.Snippet{}, Next: q.Before}, nil
+	}
+	page := &models.SnippetPage{Snippets: []*models.Snippet{mockSnippet}}
+	// 分页大小为1时还有下一页
+	if q.Size == 1 {
+		page.Next = models.CursorOf(mockSnippet)
+	}
+	return page, nil
+}
+
+func (m *SnippetModel) AllByUser(userID int) ([]*models.Snippet, error) {
+	switch userID {
+	case 39:
+		return []*models.Snippet{mockSnippet}, nil
+	default:
+		return []*models.Snippet{}, nil
+	}
+}
+
+func (m *SnippetModel) Delete(id int) error {
+	switch id {
+	case 39:
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
+
+// 只有包含miku的关键字能搜索到固定的snippet 其余筛选条件按字段比较
+func (m *SnippetModel) Search(q models.SearchQuery) ([]*models.Snippet, error) {
+	s := mockSnippet
+	switch {
+	case q.Query != "" && !strings.Contains(strings.ToLower(q.Query), "miku"):
+	case q.Language != "" && q.Language != s.Language:
+	case q.Author != "" && q.Author != "Miku":
+	case !q.From.IsZero() && s.Created.Before(q.From):
+	case !q.To.IsZero() && !s.Created.Before(q.To):
+	default:
+		return []*models.Snippet{s}, nil
+	}
+	return []*models.Snippet{}, nil
+}
+
+func (m *SnippetModel) TagCounts(limit int) ([]models.TagCount, error) {
+	return []models.TagCount{{Name: "k8s", Count: 3}, {Name: "oncall", Count: 1}}, nil
+}
+
+func (m *SnippetModel) UpdateExpiry(id int, expires time.Time) error {
+	switch id {
+	case 39:
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
+
+func (m *SnippetModel) SetHidden(id int, reason string) error {
+	if id < 39 || id > 44 {
+		return models.ErrNoRecord
+	}
+	return nil
+}
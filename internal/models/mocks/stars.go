@@ -0,0 +1,50 @@
+package mocks
+
+import (
+	"sync"
+	"time"
+
+	"SnippetBox.mikudayo.net/internal/models"
+)
+
+// StarModel 将收藏保存在内存中 便于测试重复收藏与取消收藏
+type StarModel struct {
+	mu      sync.Mutex
+	starred map[[2]int]bool
+}
+
+func (m *StarModel) Star(userID, snippetID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.starred == nil {
+		m.starred = map[[2]int]bool{}
+	}
+	m.starred[[2]int{userID, snippetID}] = true
+	return nil
+}
+
+func (m *StarModel) Unstar(userID, snippetID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.starred, [2]int{userID, snippetID})
+	return nil
+}
+
+func (m *StarModel) Starred(userID, snippetID int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.starred[[2]int{userID, snippetID}], nil
+}
+
+// 只有mockSnippet(39)可以出现在收藏列表中
+func (m *StarModel) Favorites(userID int) ([]*models.Snippet, error) {
+	starred, _ := m.Starred(userID, 39)
+	if !starred {
+		return []*models.Snippet{}, nil
+	}
+	return []*models.Snippet{mockSnippet}, nil
+}
+
+func (m *StarModel) MostStarred(since time.Time, limit int) ([]*models.Snippet, error) {
+	return []*models.Snippet{mockSnippet}, nil
+}
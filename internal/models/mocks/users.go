@@ -2,54 +2,184 @@ package mocks
 
 import (
 	"SnippetBox.mikudayo.net/internal/models"
+	"strings"
 	"time"
 )
 
 type UserModel struct {
+	// 测试中设置的密码 用户id到密码的映射
+	passwords map[int]string
+}
+
+// 测试中使用的固定用户 分别拥有不同的角色
+var mockUsers = map[int]*models.User{
+	39: {
+		ID:          39,
+		Name:        "Miku",
+		Email:       "miku@vocaloid.com",
+		Created:     time.Date(2007, 8, 31, 0, 0, 0, 0, time.UTC),
+		Role:        models.RoleUser,
+		HasPassword: true,
+	},
+	1: {
+		ID:          1,
+		Name:        "Luka",
+		Email:       "luka@vocaloid.com",
+		Created:     time.Date(2009, 1, 30, 0, 0, 0, 0, time.UTC),
+		Role:        models.RoleModerator,
+		HasPassword: true,
+	},
+	2: {
+		ID:          2,
+		Name:        "Rin",
+		Email:       "rin@vocaloid.com",
+		Created:     time.Date(2007, 12, 27, 0, 0, 0, 0, time.UTC),
+		Role:        models.RoleAdmin,
+		HasPassword: true,
+	},
+	4: {
+		ID:                    4,
+		Name:                  "Len",
+		Email:                 "len@vocaloid.com",
+		Created:               time.Date(2007, 12, 27, 0, 0, 0, 0, time.UTC),
+		Role:                  models.RoleUser,
+		PasswordResetRequired: true,
+		HasPassword:           true,
+	},
+	// 通过外部身份即时创建的账号 还没有设置过密码
+	6: {
+		ID:      6,
+		Name:    "Gumi",
+		Email:   "gumi@vocaloid.com",
+		Created: time.Date(2009, 6, 26, 0, 0, 0, 0, time.UTC),
+		Role:    models.RoleUser,
+	},
 }
 
 // 测试错误数据是否都正确返回
 
-func (m *UserModel) Insert(name, email, password string) error {
+func (m *UserModel) Insert(name, email, password string) (int, error) {
 	// 在后续测试会进行调用 用于判断是否使用了重复的邮箱
 	switch email {
 	case "teto@vocaloid.com":
-		return models.ErrDuplicateEmail
+		return 0, models.ErrDuplicateEmail
 	default:
-		return nil
+		return 5, nil
 	}
 }
 func (m *UserModel) Authenticate(email, password string) (int, error) {
-	if email == "miku@vocaloid.com" && password == "mikudayo3939" {
+	switch {
+	case email == "miku@vocaloid.com" && password == "mikudayo3939":
 		return 39, nil
+	case email == "luka@vocaloid.com" && password == "lukadayo0130":
+		return 1, nil
+	case email == "rin@vocaloid.com" && password == "rindayo1227":
+		return 2, nil
+	case email == "len@vocaloid.com" && password == "lendayo1227":
+		return 4, nil
+	case email == "kaito@vocaloid.com" && password == "kaitodayo0217":
+		return 0, models.ErrAccountDisabled
+	case email == "miku@vocaloid.com":
+		// 邮箱存在时密码错误同样返回id
+		return 39, models.ErrInvalidCredentials
 	}
 	return 0, models.ErrInvalidCredentials
 }
 func (m *UserModel) Exists(id int) (bool, error) {
-	switch id {
-	case 39:
-		return true, nil
+	_, ok := mockUsers[id]
+	return ok, nil
+}
+
+// 返回用户的账号信息
+func (m *UserModel) GetUser(id int) (*models.User, error) {
+	u, ok := mockUsers[id]
+	if !ok {
+		return nil, models.ErrNoRecord
+	}
+	// 返回副本防止处理器修改共享的测试数据
+	user := *u
+	if _, ok := m.passwords[id]; ok {
+		user.HasPassword = true
+	}
+	return &user, nil
+}
+
+func (m *UserModel) UpdateName(id int, name string) error {
+	return nil
+}
+
+func (m *UserModel) RequestEmailChange(id int, newEmail string) (string, error) {
+	switch newEmail {
+	case "teto@vocaloid.com":
+		return "", models.ErrDuplicateEmail
 	default:
-		return false, nil
+		return "mikutoken", nil
 	}
 }
 
-// 返回用户的账号名
-func (m *UserModel) GetName(id int) (string, error) {
-	return "", nil
+func (m *UserModel) ConfirmEmailChange(token string) (int, error) {
+	if token == "mikutoken" {
+		return 39, nil
+	}
+	return 0, models.ErrNoRecord
+}
+
+func (m *UserModel) UpdatePassword(currentPD, newPD string, id int) error {
+	// 记录新的密码 之后可以用于注销账号
+	if m.passwords == nil {
+		m.passwords = map[int]string{}
+	}
+	m.passwords[id] = newPD
+	return nil
 }
 
-// 返回用户账号的创建时间
-func (m *UserModel) GetJoinedTime(id int) (time.Time, error) {
-	return time.Time{}, nil
+func (m *UserModel) Delete(id int, password string, deleteSnippets bool) error {
+	if id == 39 && password == "mikudayo3939" {
+		return nil
+	}
+	if pd, ok := m.passwords[id]; ok && pd == password {
+		return nil
+	}
+	return models.ErrInvalidCredentials
 }
 
-// 返回用户的邮箱
-func (m *UserModel) GetEmail(id int) (string, error) {
+func (m *UserModel) List(query string) ([]*models.User, error) {
+	users := []*models.User{}
+	for _, id := range []int{39, 2, 1} {
+		u := *mockUsers[id]
+		if query == "" || strings.Contains(u.Name, query) || strings.Contains(u.Email, query) {
+			users = append(users, &u)
+		}
+	}
+	return users, nil
+}
 
-	return "", nil
+func (m *UserModel) SetRole(id int, role string) error {
+	return nil
+}
 
+func (m *UserModel) SetDisabled(id int, disabled bool) error {
+	return nil
 }
-func (m *UserModel) UpdatePassword(currentPD, newPD string, id int) error {
+
+func (m *UserModel) RequirePasswordReset(id int) error {
 	return nil
 }
+
+func (m *UserModel) AuthenticateExternal(issuer, subject, email, name string, emailVerified bool) (int, error) {
+	switch {
+	case subject == "miku":
+		return 39, nil
+	case !emailVerified:
+		return 0, models.ErrInvalidCredentials
+	case email == "kaito@vocaloid.com":
+		return 0, models.ErrAccountDisabled
+	}
+	// 其余情况视为通过邮箱关联或即时创建了账号
+	for id, u := range mockUsers {
+		if u.Email == email {
+			return id, nil
+		}
+	}
+	return 39, nil
+}
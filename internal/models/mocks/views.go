@@ -0,0 +1,36 @@
+package mocks
+
+import (
+	"sync"
+	"time"
+
+	"SnippetBox.mikudayo.net/internal/models"
+)
+
+// ViewModel 将写入的访问记录保存在内存中
+type ViewModel struct {
+	mu    sync.Mutex
+	Views []models.View
+}
+
+func (m *ViewModel) Record(views []models.View) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Views = append(m.Views, views...)
+	return nil
+}
+
+// mockSnippet(39)今天有5次访问 其中3次来自github.com
+func (m *ViewModel) Stats(snippetID int, days int, now time.Time) (*models.ViewStats, error) {
+	stats := &models.ViewStats{Referrers: []models.ReferrerCount{}}
+	from := models.ViewDay(now).AddDate(0, 0, 1-days)
+	for i := 0; i < days; i++ {
+		stats.Daily = append(stats.Daily, models.DailyViews{Day: from.AddDate(0, 0, i)})
+	}
+	if snippetID == 39 {
+		stats.Total = 12
+		stats.Daily[days-1].Views = 5
+		stats.Referrers = append(stats.Referrers, models.ReferrerCount{Referrer: "github.com", Views: 3})
+	}
+	return stats, nil
+}
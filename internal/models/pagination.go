@@ -0,0 +1,89 @@
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 分页大小的默认值与上限
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// 无法解析的分页游标
+var ErrInvalidCursor = errors.New("models:invalid cursor")
+
+// Cursor 分页游标 记录一页边界上的snippet的(created,id)
+// 使用键集分页(keyset pagination)而不是OFFSET 翻到很后面的页时也只需要扫描一页的数据
+type Cursor struct {
+	Created time.Time
+	ID      int
+}
+
+// CursorOf 返回指向指定snippet的游标
+func CursorOf(s *Snippet) *Cursor {
+	return &Cursor{Created: s.Created, ID: s.ID}
+}
+
+// String 将游标编码为可以放在url中的不透明字符串
+func (c Cursor) String() string {
+	raw := fmt.Sprintf("%d:%d", c.Created.UTC().UnixNano(), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseCursor 解析由Cursor.String生成的字符串
+func ParseCursor(s string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	created, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	nano, err := strconv.ParseInt(created, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	c := &Cursor{Created: time.Unix(0, nano).UTC()}
+	c.ID, err = strconv.Atoi(id)
+	if err != nil || c.ID < 1 {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// PageQuery 分页查询的条件 After与Before最多设置一个 都为nil时返回第一页
+type PageQuery struct {
+	// 返回比游标更旧的snippet(下一页)
+	After *Cursor
+	// 返回比游标更新的snippet(上一页)
+	Before *Cursor
+	Size   int
+	// 只返回带有该标签的snippet 为空时不筛选
+	Tag string
+}
+
+// SnippetPage 一页snippet(从新到旧) Next与Prev为nil表示没有下一页或上一页
+type SnippetPage struct {
+	Snippets []*Snippet
+	Next     *Cursor
+	Prev     *Cursor
+}
+
+// 将分页大小限制在1到MaxPageSize之间
+func clampPageSize(size int) int {
+	switch {
+	case size < 1:
+		return DefaultPageSize
+	case size > MaxPageSize:
+		return MaxPageSize
+	default:
+		return size
+	}
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"SnippetBox.mikudayo.net/internal/assert"
+	"testing"
+	"time"
+)
+
+func TestCursor(t *testing.T) {
+	c := Cursor{Created: time.Date(2007, 8, 31, 12, 0, 0, 39, time.FixedZone("JST", 9*60*60)), ID: 39}
+	parsed, err := ParseCursor(c.String())
+	assert.NilError(t, err)
+	assert.Equal(t, parsed.Created.Equal(c.Created), true)
+	assert.Equal(t, parsed.ID, 39)
+
+	for _, s := range []string{"", "!!", "bWlrdQ", "MTIzOm1pa3U", "MTIzOjA"} {
+		t.Run("Invalid "+s, func(t *testing.T) {
+			_, err := ParseCursor(s)
+			assert.Equal(t, err, ErrInvalidCursor)
+		})
+	}
+}
+
+func TestClampPageSize(t *testing.T) {
+	assert.Equal(t, clampPageSize(0), DefaultPageSize)
+	assert.Equal(t, clampPageSize(-1), DefaultPageSize)
+	assert.Equal(t, clampPageSize(5), 5)
+	assert.Equal(t, clampPageSize(MaxPageSize+1), MaxPageSize)
+}
@@ -0,0 +1,210 @@
+package models
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PasswordPolicy 密码策略 在注册与修改密码时统一使用
+type PasswordPolicy struct {
+	// 最少字符数
+	MinLength int
+	// 估算的最低熵(bit)
+	MinEntropy float64
+	// 已泄露密码列表 为nil时不进行检查
+	Breached *BreachedPasswords
+}
+
+// DefaultPasswordPolicy 未进行配置时使用的默认策略
+var DefaultPasswordPolicy = &PasswordPolicy{
+	MinLength:  8,
+	MinEntropy: 30,
+}
+
+// 最常见的一批弱密码 即使没有载入泄露密码列表也会被拒绝
+var commonPasswords = map[string]bool{
+	"password": true, "password1": true, "passw0rd": true, "12345678": true,
+	"123456789": true, "1234567890": true, "qwertyui": true, "qwerty123": true,
+	"iloveyou": true, "11111111": true, "00000000": true, "abc12345": true,
+	"abcd1234": true, "1q2w3e4r": true, "sunshine": true, "princess": true,
+	"football": true, "baseball": true, "letmein1": true, "welcome1": true,
+	"admin123": true, "trustno1": true, "superman": true, "whatever": true,
+}
+
+// PasswordEntropy 粗略估算密码的熵(bit)
+// 按照出现的字符类别计算每个字符的熵 重复或连续的字符(aaa 123 cba)只计1bit
+func PasswordEntropy(password string) float64 {
+	if commonPasswords[strings.ToLower(password)] {
+		return 0
+	}
+	var lower, upper, digit, symbol, other bool
+	for _, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < utf8.RuneSelf && unicode.IsPrint(r):
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	pool := 0
+	if lower {
+		pool += 26
+	}
+	if upper {
+		pool += 26
+	}
+	if digit {
+		pool += 10
+	}
+	if symbol {
+		pool += 33
+	}
+	if other {
+		pool += 100
+	}
+	if pool == 0 {
+		return 0
+	}
+	perChar := math.Log2(float64(pool))
+	entropy := 0.0
+	prev := rune(-1)
+	for _, r := range password {
+		d := r - prev
+		if prev >= 0 && (d >= -1 && d <= 1) {
+			entropy++
+		} else {
+			entropy += perChar
+		}
+		prev = r
+	}
+	return entropy
+}
+
+// 去掉密码中包含的个人信息(昵称 邮箱及邮箱的用户名部分) 剩余部分才用于估算强度
+func stripPersonalInfo(password string, personal []string) string {
+	lowered := strings.ToLower(password)
+	var parts []string
+	for _, p := range personal {
+		p = strings.ToLower(strings.TrimSpace(p))
+		parts = append(parts, p)
+		if local, _, ok := strings.Cut(p, "@"); ok {
+			parts = append(parts, local)
+		}
+	}
+	// 先去掉较长的片段 防止昵称是邮箱的一部分时只去掉了较短的部分
+	sort.Slice(parts, func(i, j int) bool { return len(parts[i]) > len(parts[j]) })
+	for _, p := range parts {
+		// 太短的片段容易误伤 例如只有两个字母的昵称
+		if utf8.RuneCountInString(p) < 3 {
+			continue
+		}
+		lowered = strings.ReplaceAll(lowered, p, "")
+	}
+	return lowered
+}
+
+// CheckPassword 按照策略检查密码 personal为用户的昵称与邮箱等个人信息
+// 不符合策略时向key添加字段错误 只有读取泄露密码列表失败时才返回error
+func (v *Validator) CheckPassword(policy *PasswordPolicy, key, password string, personal ...string) error {
+	if policy == nil {
+		policy = DefaultPasswordPolicy
+	}
+	if !v.MinChars(password, policy.MinLength) {
+		v.AddFieldError(key, fmt.Sprintf("密码长度必须大于%d...", policy.MinLength))
+		return nil
+	}
+	rest := stripPersonalInfo(password, personal)
+	if utf8.RuneCountInString(rest) < utf8.RuneCountInString(password) && PasswordEntropy(rest) < policy.MinEntropy {
+		v.AddFieldError(key, "密码不能主要由昵称或邮箱组成...")
+		return nil
+	}
+	if PasswordEntropy(password) < policy.MinEntropy {
+		v.AddFieldError(key, "密码过于简单,请混合使用字母、数字与符号...")
+		return nil
+	}
+	if policy.Breached != nil {
+		breached, err := policy.Breached.Contains(password)
+		if err != nil {
+			return err
+		}
+		if breached {
+			v.AddFieldError(key, "该密码出现在已泄露的密码列表中,请更换...")
+		}
+	}
+	return nil
+}
+
+// BreachedPasswords 本地的已泄露密码列表 使用k-anonymity前缀文件的格式:
+// 目录中每个文件以密码SHA-1的前5位十六进制字符命名(可带.txt后缀)
+// 文件中每行为 剩余35位哈希:出现次数 与Have I Been Pwned的range接口返回的内容一致
+type BreachedPasswords struct {
+	Dir string
+	// 出现次数小于该值的记录会被忽略
+	MinCount int
+}
+
+// NewBreachedPasswords 检查目录是否存在并返回列表
+func NewBreachedPasswords(dir string, minCount int) (*BreachedPasswords, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("models:%s is not a directory", dir)
+	}
+	return &BreachedPasswords{Dir: dir, MinCount: minCount}, nil
+}
+
+// Contains 判断密码是否出现在泄露列表中 只需要读取对应前缀的文件
+func (b *BreachedPasswords) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	f, err := os.Open(filepath.Join(b.Dir, prefix))
+	if errors.Is(err, fs.ErrNotExist) {
+		f, err = os.Open(filepath.Join(b.Dir, prefix+".txt"))
+	}
+	if err != nil {
+		// 没有该前缀的文件说明列表中没有相应的密码
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		s, count, _ := strings.Cut(line, ":")
+		if !strings.EqualFold(s, suffix) {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(count))
+		if err != nil {
+			// 没有次数的记录视为出现过一次
+			n = 1
+		}
+		return n >= b.MinCount, nil
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"SnippetBox.mikudayo.net/internal/assert"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckPassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		personal []string
+		wantErr  string
+	}{
+		{
+			name:     "Valid",
+			password: "mikudayo3939",
+			personal: []string{"miku", "miku@vocaloid.com"},
+		},
+		{
+			name:     "Too short",
+			password: "pa$$",
+			wantErr:  "密码长度必须大于8...",
+		},
+		{
+			name:     "Common password",
+			password: "password",
+			wantErr:  "密码过于简单,请混合使用字母、数字与符号...",
+		},
+		{
+			name:     "Digit sequence",
+			password: "12345678",
+			wantErr:  "密码过于简单,请混合使用字母、数字与符号...",
+		},
+		{
+			name:     "Repeated character",
+			password: "aaaaaaaaaaaa",
+			wantErr:  "密码过于简单,请混合使用字母、数字与符号...",
+		},
+		{
+			name:     "Email local part",
+			password: "hatsunemiku1",
+			personal: []string{"Miku", "hatsunemiku@vocaloid.com"},
+			wantErr:  "密码不能主要由昵称或邮箱组成...",
+		},
+		{
+			name:     "Name",
+			password: "HatsuneMiku!",
+			personal: []string{"hatsunemiku", "miku@vocaloid.com"},
+			wantErr:  "密码不能主要由昵称或邮箱组成...",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v Validator
+			err := v.CheckPassword(DefaultPasswordPolicy, "password", tt.password, tt.personal...)
+			assert.NilError(t, err)
+			assert.Equal(t, v.FieldErrors["password"], tt.wantErr)
+		})
+	}
+}
+
+func TestBreachedPasswords(t *testing.T) {
+	dir := t.TempDir()
+	// 使用range接口的格式写入前缀文件 同一个文件中还有其他的哈希与出现次数
+	write := func(name, content string) {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	hash := sha1Hex("Tr0ub4dor&3")
+	write(hash[:5], "0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n"+hash[5:]+":42\r\n")
+	rare := sha1Hex("correcthorsebatterystaple")
+	write(rare[:5]+".txt", rare[5:]+":1\n")
+
+	b, err := NewBreachedPasswords(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		password string
+		want     bool
+	}{
+		{name: "Breached", password: "Tr0ub4dor&3", want: true},
+		{name: "Below minimum count", password: "correcthorsebatterystaple", want: false},
+		{name: "Missing prefix file", password: "mikudayo3939", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			breached, err := b.Contains(tt.password)
+			assert.NilError(t, err)
+			assert.Equal(t, breached, tt.want)
+		})
+	}
+
+	t.Run("Policy", func(t *testing.T) {
+		var v Validator
+		policy := &PasswordPolicy{MinLength: 8, MinEntropy: 30, Breached: b}
+		err := v.CheckPassword(policy, "password", "Tr0ub4dor&3")
+		assert.NilError(t, err)
+		assert.Equal(t, v.FieldErrors["password"], "该密码出现在已泄露的密码列表中,请更换...")
+	})
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,199 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 自托管的工作量证明 代替外部的验证码服务
+// 服务器签发带有签名与有效期的挑战 浏览器不断尝试solution
+// 直到sha256(挑战 + ":" + solution)的前导零不少于难度规定的bit数 服务器只需要计算一次哈希就能验证
+// 最近注册的数量越多难度越高 每增加1bit计算量翻倍
+
+const (
+	// 挑战的有效期
+	ChallengeTTL = 10 * time.Minute
+	// PoWKeySize 签名挑战使用的密钥长度
+	PoWKeySize = 32
+	// 统计最近注册数量的时间窗口
+	powWindow = time.Hour
+	// 最多记录的注册次数 超出后难度已经是最高
+	maxRecentSignups = 100000
+	// solution只能是不超过这个长度的十进制数
+	maxSolutionLength = 20
+)
+
+var (
+	// 挑战的格式或签名不正确 或者用于其他操作
+	ErrInvalidChallenge = errors.New("models:invalid proof of work challenge")
+	// 挑战已经过期
+	ErrChallengeExpired = errors.New("models:proof of work challenge expired")
+	// 挑战已经使用过
+	ErrChallengeUsed = errors.New("models:proof of work challenge already used")
+	// solution不满足难度要求
+	ErrInsufficientWork = errors.New("models:insufficient proof of work")
+)
+
+// ProofOfWork 签发与验证工作量证明的挑战
+// 使用过的挑战只记录在内存中 多个实例部署时同一个挑战在每个实例上各能使用一次
+type ProofOfWork struct {
+	key []byte
+	// 难度的范围(前导零的bit数)
+	MinDifficulty int
+	MaxDifficulty int
+	// 最近一小时内的注册每多StepVolume次难度增加1bit 之后每翻一倍再增加1bit
+	StepVolume int
+	mu         sync.Mutex
+	used       map[string]time.Time
+	recent     []time.Time
+}
+
+// NewProofOfWork 使用key签名挑战 难度在minDifficulty与maxDifficulty之间变化
+func NewProofOfWork(key []byte, minDifficulty, maxDifficulty int) (*ProofOfWork, error) {
+	if len(key) < PoWKeySize {
+		return nil, fmt.Errorf("models:proof of work key must be at least %d bytes", PoWKeySize)
+	}
+	if minDifficulty < 1 || maxDifficulty < minDifficulty || maxDifficulty > 32 {
+		return nil, errors.New("models:proof of work difficulty must satisfy 1 <= min <= max <= 32")
+	}
+	return &ProofOfWork{
+		key:           key,
+		MinDifficulty: minDifficulty,
+		MaxDifficulty: maxDifficulty,
+		StepVolume:    10,
+		used:          map[string]time.Time{},
+	}, nil
+}
+
+// Difficulty 按照最近一小时内的注册数量返回当前的难度
+func (p *ProofOfWork) Difficulty(now time.Time) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pruneRecent(now)
+	return min(p.MaxDifficulty, p.MinDifficulty+bits.Len(uint(len(p.recent)/p.StepVolume)))
+}
+
+// Record 记录一次成功的注册 用于调整之后的难度
+func (p *ProofOfWork) Record(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pruneRecent(now)
+	if len(p.recent) < maxRecentSignups {
+		p.recent = append(p.recent, now)
+	}
+}
+
+// 删除超出时间窗口的注册记录 需要持有锁
+func (p *ProofOfWork) pruneRecent(now time.Time) {
+	i := 0
+	for i < len(p.recent) && now.Sub(p.recent[i]) >= powWindow {
+		i++
+	}
+	p.recent = p.recent[i:]
+}
+
+// Challenge 为action签发一个新的挑战 返回挑战与难度
+// 挑战的格式为 action.过期时间.难度.随机数.签名
+func (p *ProofOfWork) Challenge(action string, now time.Time) (string, int) {
+	difficulty := p.Difficulty(now)
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	payload := fmt.Sprintf("%s.%d.%d.%s", action, now.Add(ChallengeTTL).Unix(), difficulty, hex.EncodeToString(nonce))
+	return payload + "." + p.sign(payload), difficulty
+}
+
+func (p *ProofOfWork) sign(payload string) string {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify 验证挑战由当前密钥为action签发 没有过期或使用过 并且solution满足难度
+// 验证成功后挑战不能再次使用
+func (p *ProofOfWork) Verify(action, challenge, solution string, now time.Time) error {
+	parts := strings.Split(challenge, ".")
+	if len(parts) != 5 || parts[0] != action {
+		return ErrInvalidChallenge
+	}
+	payload := strings.Join(parts[:4], ".")
+	if !hmac.Equal([]byte(p.sign(payload)), []byte(parts[4])) {
+		return ErrInvalidChallenge
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return ErrInvalidChallenge
+	}
+	difficulty, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return ErrInvalidChallenge
+	}
+	expiry := time.Unix(expires, 0)
+	if !now.Before(expiry) {
+		return ErrChallengeExpired
+	}
+	if !validSolution(solution) || leadingZeroBits(powHash(challenge, solution)) < difficulty {
+		return ErrInsufficientWork
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.used[parts[3]]; ok {
+		return ErrChallengeUsed
+	}
+	// 过期的挑战无法通过验证 不需要继续记录
+	for nonce, t := range p.used {
+		if !now.Before(t) {
+			delete(p.used, nonce)
+		}
+	}
+	p.used[parts[3]] = expiry
+	return nil
+}
+
+// SolveChallenge 计算满足难度的solution 浏览器中由pow.js完成同样的计算
+func SolveChallenge(challenge string, difficulty int) string {
+	for i := 0; ; i++ {
+		solution := strconv.Itoa(i)
+		if leadingZeroBits(powHash(challenge, solution)) >= difficulty {
+			return solution
+		}
+	}
+}
+
+func powHash(challenge, solution string) []byte {
+	sum := sha256.Sum256([]byte(challenge + ":" + solution))
+	return sum[:]
+}
+
+func validSolution(solution string) bool {
+	if solution == "" || len(solution) > maxSolutionLength {
+		return false
+	}
+	for _, c := range solution {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// 返回哈希开头连续为0的bit数
+func leadingZeroBits(sum []byte) int {
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
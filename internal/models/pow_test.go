@@ -0,0 +1,122 @@
+package models
+
+import (
+	"SnippetBox.mikudayo.net/internal/assert"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestProofOfWork(t *testing.T) *ProofOfWork {
+	p, err := NewProofOfWork(bytes.Repeat([]byte{39}, PoWKeySize), 4, 8)
+	assert.NilError(t, err)
+	return p
+}
+
+func TestNewProofOfWork(t *testing.T) {
+	key := bytes.Repeat([]byte{39}, PoWKeySize)
+	tests := []struct {
+		name     string
+		key      []byte
+		min, max int
+	}{
+		{"Short key", key[:16], 4, 8},
+		{"Zero difficulty", key, 0, 8},
+		{"Max below min", key, 8, 4},
+		{"Too difficult", key, 4, 33},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewProofOfWork(tt.key, tt.min, tt.max)
+			assert.Equal(t, err != nil, true)
+		})
+	}
+}
+
+func TestProofOfWorkVerify(t *testing.T) {
+	p := newTestProofOfWork(t)
+	now := time.Now()
+	challenge, difficulty := p.Challenge("signup", now)
+	assert.Equal(t, difficulty, 4)
+	solution := SolveChallenge(challenge, difficulty)
+	assert.Equal(t, leadingZeroBits(powHash(challenge, solution)) >= difficulty, true)
+
+	// 找到一个不满足难度的solution
+	wrong := "0"
+	for i := 0; leadingZeroBits(powHash(challenge, wrong)) >= difficulty; i++ {
+		wrong = strings.Repeat("1", i+1)
+	}
+	// 修改难度后签名不再匹配
+	parts := strings.Split(challenge, ".")
+	parts[2] = "1"
+	tampered := strings.Join(parts, ".")
+
+	tests := []struct {
+		name      string
+		action    string
+		challenge string
+		solution  string
+		now       time.Time
+		wantErr   error
+	}{
+		{"Wrong action", "login", challenge, solution, now, ErrInvalidChallenge},
+		{"Malformed", "signup", "signup.39", solution, now, ErrInvalidChallenge},
+		{"Tampered difficulty", "signup", tampered, SolveChallenge(tampered, 1), now, ErrInvalidChallenge},
+		{"Expired", "signup", challenge, solution, now.Add(ChallengeTTL + time.Second), ErrChallengeExpired},
+		{"Empty solution", "signup", challenge, "", now, ErrInsufficientWork},
+		{"Not a number", "signup", challenge, "mikudayo", now, ErrInsufficientWork},
+		{"Insufficient work", "signup", challenge, wrong, now, ErrInsufficientWork},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.Verify(tt.action, tt.challenge, tt.solution, tt.now)
+			assert.Equal(t, errors.Is(err, tt.wantErr), true)
+		})
+	}
+
+	// 失败的尝试不会使挑战失效 成功之后不能再次使用
+	assert.NilError(t, p.Verify("signup", challenge, solution, now))
+	err := p.Verify("signup", challenge, solution, now)
+	assert.Equal(t, errors.Is(err, ErrChallengeUsed), true)
+
+	// 其他密钥签发的挑战无效
+	other, err := NewProofOfWork(bytes.Repeat([]byte{1}, PoWKeySize), 4, 8)
+	assert.NilError(t, err)
+	challenge, difficulty = other.Challenge("signup", now)
+	err = p.Verify("signup", challenge, SolveChallenge(challenge, difficulty), now)
+	assert.Equal(t, errors.Is(err, ErrInvalidChallenge), true)
+}
+
+func TestProofOfWorkDifficulty(t *testing.T) {
+	p := newTestProofOfWork(t)
+	now := time.Now()
+	assert.Equal(t, p.Difficulty(now), 4)
+	for i := 0; i < 9; i++ {
+		p.Record(now)
+	}
+	assert.Equal(t, p.Difficulty(now), 4)
+	p.Record(now)
+	assert.Equal(t, p.Difficulty(now), 5)
+	for i := 0; i < 30; i++ {
+		p.Record(now)
+	}
+	assert.Equal(t, p.Difficulty(now), 7)
+	// 签发的挑战带有当时的难度
+	_, difficulty := p.Challenge("signup", now)
+	assert.Equal(t, difficulty, 7)
+	// 不会超过最高难度
+	for i := 0; i < 1000; i++ {
+		p.Record(now)
+	}
+	assert.Equal(t, p.Difficulty(now), 8)
+	// 一小时之后恢复最低难度
+	assert.Equal(t, p.Difficulty(now.Add(time.Hour)), 4)
+}
+
+func TestLeadingZeroBits(t *testing.T) {
+	assert.Equal(t, leadingZeroBits([]byte{0xff}), 0)
+	assert.Equal(t, leadingZeroBits([]byte{0x00, 0x10}), 11)
+	assert.Equal(t, leadingZeroBits([]byte{0x00, 0x00}), 16)
+}
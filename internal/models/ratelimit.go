@@ -0,0 +1,49 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"SnippetBox.mikudayo.net/internal/ratelimit"
+)
+
+// RateLimitModel 在数据库中保存限流的桶 多个实例共享同一个限制
+// 实现ratelimit.Store
+type RateLimitModel struct {
+	DB *sql.DB
+}
+
+// Take 锁定桶所在的行后按照policy取出令牌
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *RateLimitModel) Take(key string, policy ratelimit.Policy, now time.Time) (bool, time.Duration, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return false, 0, err
+	}
+	defer tx.Rollback()
+	var tat time.Time
+	err = tx.QueryRow(`SELECT tat FROM rate_limits WHERE bucket = ? FOR UPDATE`, key).Scan(&tat)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, 0, err
+	}
+	next, ok, retryAfter := policy.Take(tat, now)
+	if !ok {
+		return false, retryAfter, nil
+	}
+	stmt := `INSERT INTO rate_limits(bucket,tat) VALUES(?,?)
+	ON DUPLICATE KEY UPDATE tat = VALUES(tat)`
+	if _, err = tx.Exec(stmt, key, next.UTC()); err != nil {
+		return false, 0, err
+	}
+	return true, 0, tx.Commit()
+}
+
+// Evict 删除已经回满的桶
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *RateLimitModel) Evict(now time.Time) error {
+	_, err := m.DB.Exec(`DELETE FROM rate_limits WHERE tat <= ?`, now.UTC())
+	return err
+}
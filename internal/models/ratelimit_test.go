@@ -0,0 +1,39 @@
+package models
+
+import (
+	"SnippetBox.mikudayo.net/internal/assert"
+	"testing"
+	"time"
+
+	"SnippetBox.mikudayo.net/internal/ratelimit"
+)
+
+func TestRateLimitModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models:skipping integration test...")
+	}
+	db := newTestDB(t)
+	m := RateLimitModel{DB: db}
+	p := ratelimit.Policy{Name: "test", Interval: time.Minute, Burst: 2}
+	now := time.Now().Truncate(time.Second)
+
+	for i := 0; i < 2; i++ {
+		ok, _, err := m.Take("test:ip:192.0.2.1", p, now)
+		assert.NilError(t, err)
+		assert.Equal(t, ok, true)
+	}
+	ok, retryAfter, err := m.Take("test:ip:192.0.2.1", p, now)
+	assert.NilError(t, err)
+	assert.Equal(t, ok, false)
+	assert.Equal(t, retryAfter, time.Minute)
+	// 不同的key使用不同的桶
+	ok, _, err = m.Take("test:ip:192.0.2.2", p, now)
+	assert.NilError(t, err)
+	assert.Equal(t, ok, true)
+
+	// 回满的桶被删除
+	assert.NilError(t, m.Evict(now.Add(90*time.Second)))
+	var n int
+	assert.NilError(t, db.QueryRow(`SELECT COUNT(*) FROM rate_limits`).Scan(&n))
+	assert.Equal(t, n, 1)
+}
@@ -0,0 +1,211 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// 举报的原因
+const (
+	ReportSpam         = "spam"
+	ReportAbuse        = "abuse"
+	ReportMalware      = "malware"
+	ReportPersonalData = "personal_data"
+	ReportCopyright    = "copyright"
+	ReportOther        = "other"
+)
+
+// ReportReasons 可以选择的举报原因 按照展示的顺序排列
+var ReportReasons = []string{ReportSpam, ReportAbuse, ReportMalware, ReportPersonalData, ReportCopyright, ReportOther}
+
+var reportReasonLabels = map[string]string{
+	ReportSpam:         "垃圾信息",
+	ReportAbuse:        "骚扰或仇恨言论",
+	ReportMalware:      "恶意软件或钓鱼",
+	ReportPersonalData: "泄露他人的个人信息",
+	ReportCopyright:    "侵犯版权",
+	ReportOther:        "其他",
+}
+
+// ValidReportReason 检查举报原因是否在列表中
+func ValidReportReason(reason string) bool {
+	return slices.Contains(ReportReasons, reason)
+}
+
+// ReportReasonLabel 返回举报原因的说明 不在列表中时原样返回
+func ReportReasonLabel(reason string) string {
+	if label, ok := reportReasonLabels[reason]; ok {
+		return label
+	}
+	return reason
+}
+
+// 举报的处理结果
+const (
+	ReportOpen      = "open"
+	ReportDismissed = "dismissed"
+	ReportHidden    = "hidden"
+	ReportDeleted   = "deleted"
+)
+
+const (
+	// DefaultAutoHideReports 默认收到这么多个不同用户的举报后自动隐藏
+	DefaultAutoHideReports = 3
+	// 举报说明的最大长度
+	MaxReportDetails = 1000
+)
+
+// 同一个用户对同一条snippet只能举报一次
+var ErrDuplicateReport = errors.New("models:duplicate report")
+
+// Report 一条举报(与数据库中表的结构一致)
+type Report struct {
+	ID        int
+	SnippetID int
+	// 举报者 注销账号后为0
+	UserID  int
+	Reason  string
+	Details string
+	Status  string
+	Created time.Time
+}
+
+// ReportedSnippet 审核队列中的一项 包含同一条snippet所有未处理的举报
+type ReportedSnippet struct {
+	SnippetID int
+	Title     string
+	// snippet的创建者
+	UserID int
+	// 已经被隐藏时为隐藏的原因
+	HiddenReason string
+	Reports      []*Report
+}
+
+// ReportModelInterface 定义接口用于解决模拟依赖注入时编译报错的问题
+type ReportModelInterface interface {
+	Insert(snippetID, userID int, reason, details string) (hidden bool, err error)
+	Queue() ([]*ReportedSnippet, error)
+	Resolve(snippetID int, status string) (int, error)
+}
+
+// 注入数据库依赖
+type ReportModel struct {
+	DB *sql.DB
+	// 收到这么多个不同用户的未处理举报时自动隐藏snippet 为0时不自动隐藏
+	AutoHide int
+}
+
+// Insert 记录一条举报 未处理的举报达到AutoHide个时以最多的原因隐藏snippet
+// 返回这条举报是否使snippet被自动隐藏
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *ReportModel) Insert(snippetID, userID int, reason, details string) (bool, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	stmt := `INSERT INTO reports(snippet_id,user_id,reason,details,status,created)
+	VALUES(?,?,?,?,?,UTC_TIMESTAMP())`
+	_, err = tx.Exec(stmt, snippetID, userID, reason, details, ReportOpen)
+	if err != nil {
+		var mySQLError *mysql.MySQLError
+		if errors.As(err, &mySQLError) && mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "reports_uc_snippet_user") {
+			return false, ErrDuplicateReport
+		}
+		return false, err
+	}
+	hidden := false
+	if m.AutoHide > 0 {
+		var open int
+		err = tx.QueryRow(`SELECT COUNT(*) FROM reports WHERE snippet_id = ? AND status = ?`, snippetID, ReportOpen).Scan(&open)
+		if err != nil {
+			return false, err
+		}
+		if open >= m.AutoHide {
+			// 以最多的原因作为隐藏的原因 数量相同时取最早的
+			stmt = `SELECT reason FROM reports WHERE snippet_id = ? AND status = ?
+			GROUP BY reason
+			ORDER BY COUNT(*) DESC, MIN(id)
+			LIMIT 1`
+			var most string
+			if err = tx.QueryRow(stmt, snippetID, ReportOpen).Scan(&most); err != nil {
+				return false, err
+			}
+			res, err := tx.Exec(`UPDATE snippets SET hidden_reason = ? WHERE id = ? AND hidden_reason = ''`, most, snippetID)
+			if err != nil {
+				return false, err
+			}
+			n, err := res.RowsAffected()
+			if err != nil {
+				return false, err
+			}
+			hidden = n == 1
+		}
+	}
+	return hidden, tx.Commit()
+}
+
+// Queue 返回所有未处理的举报 按照snippet分组 举报多的排在前面
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *ReportModel) Queue() ([]*ReportedSnippet, error) {
+	stmt := `SELECT r.id,r.snippet_id,COALESCE(r.user_id,0),r.reason,r.details,r.status,r.created,
+	s.title,COALESCE(s.user_id,0),s.hidden_reason
+	FROM reports r JOIN snippets s ON s.id = r.snippet_id
+	WHERE r.status = ?
+	ORDER BY r.id`
+	rows, err := m.DB.Query(stmt, ReportOpen)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	queue := []*ReportedSnippet{}
+	bySnippet := map[int]*ReportedSnippet{}
+	for rows.Next() {
+		r := &Report{}
+		s := &ReportedSnippet{}
+		err = rows.Scan(&r.ID, &r.SnippetID, &r.UserID, &r.Reason, &r.Details, &r.Status, &r.Created,
+			&s.Title, &s.UserID, &s.HiddenReason)
+		if err != nil {
+			return nil, err
+		}
+		if existing, ok := bySnippet[r.SnippetID]; ok {
+			s = existing
+		} else {
+			s.SnippetID = r.SnippetID
+			bySnippet[r.SnippetID] = s
+			queue = append(queue, s)
+		}
+		s.Reports = append(s.Reports, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	// 举报数量相同时保持最早被举报的在前
+	slices.SortStableFunc(queue, func(a, b *ReportedSnippet) int {
+		return len(b.Reports) - len(a.Reports)
+	})
+	return queue, nil
+}
+
+// Resolve 以status处理snippet所有未处理的举报 返回处理的数量
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *ReportModel) Resolve(snippetID int, status string) (int, error) {
+	stmt := `UPDATE reports SET status = ?, resolved = UTC_TIMESTAMP() WHERE snippet_id = ? AND status = ?`
+	res, err := m.DB.Exec(stmt, status, snippetID, ReportOpen)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
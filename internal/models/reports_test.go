@@ -0,0 +1,79 @@
+package models
+
+import (
+	"SnippetBox.mikudayo.net/internal/assert"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReportReasons(t *testing.T) {
+	for _, reason := range ReportReasons {
+		assert.Equal(t, ValidReportReason(reason), true)
+		assert.Equal(t, ReportReasonLabel(reason) != reason, true)
+	}
+	assert.Equal(t, ValidReportReason("boring"), false)
+	assert.Equal(t, ReportReasonLabel("boring"), "boring")
+}
+
+func TestReportModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models:skipping integration test...")
+	}
+	db := newTestDB(t)
+	snippets := SnippetModel{DB: db}
+	m := ReportModel{DB: db, AutoHide: 3}
+	week := time.Now().AddDate(0, 0, 7)
+	id, err := snippets.Insert("miku", oneFile("text", "mikudayo"), week, 39, nil, false, false)
+	assert.NilError(t, err)
+
+	hidden, err := m.Insert(id, 2, ReportSpam, "广告")
+	assert.NilError(t, err)
+	assert.Equal(t, hidden, false)
+	// 同一个用户只能举报一次
+	_, err = m.Insert(id, 2, ReportAbuse, "")
+	assert.Equal(t, errors.Is(err, ErrDuplicateReport), true)
+	hidden, err = m.Insert(id, 3, ReportAbuse, "")
+	assert.NilError(t, err)
+	assert.Equal(t, hidden, false)
+
+	// 第三个举报触发自动隐藏 原因取最多的 数量相同时取最早的
+	hidden, err = m.Insert(id, 4, ReportAbuse, "")
+	assert.NilError(t, err)
+	assert.Equal(t, hidden, true)
+	s, err := snippets.Get(id)
+	assert.NilError(t, err)
+	assert.Equal(t, s.HiddenReason, ReportAbuse)
+	latest, err := snippets.Latest()
+	assert.NilError(t, err)
+	assert.Equal(t, len(latest), 0)
+	// 已经隐藏后不会重复触发
+	hidden, err = m.Insert(id, 5, ReportSpam, "")
+	assert.NilError(t, err)
+	assert.Equal(t, hidden, false)
+
+	queue, err := m.Queue()
+	assert.NilError(t, err)
+	assert.Equal(t, len(queue), 1)
+	assert.Equal(t, queue[0].SnippetID, id)
+	assert.Equal(t, queue[0].HiddenReason, ReportAbuse)
+	assert.Equal(t, len(queue[0].Reports), 4)
+	assert.Equal(t, queue[0].Reports[0].Details, "广告")
+
+	// 驳回后恢复 处理过的举报不再计入自动隐藏
+	assert.NilError(t, snippets.SetHidden(id, ""))
+	n, err := m.Resolve(id, ReportDismissed)
+	assert.NilError(t, err)
+	assert.Equal(t, n, 4)
+	queue, err = m.Queue()
+	assert.NilError(t, err)
+	assert.Equal(t, len(queue), 0)
+	hidden, err = m.Insert(id, 6, ReportSpam, "")
+	assert.NilError(t, err)
+	assert.Equal(t, hidden, false)
+	latest, err = snippets.Latest()
+	assert.NilError(t, err)
+	assert.Equal(t, len(latest), 1)
+
+	assert.Equal(t, errors.Is(snippets.SetHidden(id+1, ReportSpam), ErrNoRecord), true)
+}
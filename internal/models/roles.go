@@ -0,0 +1,26 @@
+package models
+
+// 用户的角色 权限从低到高依次为 user < moderator < admin
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// 每个角色对应的权限等级 未知的角色等级为0
+var roleLevels = map[string]int{
+	RoleUser:      1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+// ValidRole 判断是否是已定义的角色
+func ValidRole(role string) bool {
+	_, ok := roleLevels[role]
+	return ok
+}
+
+// RoleAtLeast 判断role的权限是否不低于min 高等级的角色拥有低等级角色的所有权限
+func RoleAtLeast(role, min string) bool {
+	return roleLevels[role] > 0 && roleLevels[role] >= roleLevels[min]
+}
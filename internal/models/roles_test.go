@@ -0,0 +1,26 @@
+package models
+
+import (
+	"SnippetBox.mikudayo.net/internal/assert"
+	"testing"
+)
+
+func TestRoleAtLeast(t *testing.T) {
+	tests := []struct {
+		name string
+		role string
+		min  string
+		want bool
+	}{
+		{name: "Same role", role: RoleModerator, min: RoleModerator, want: true},
+		{name: "Higher role", role: RoleAdmin, min: RoleModerator, want: true},
+		{name: "Lower role", role: RoleUser, min: RoleAdmin, want: false},
+		{name: "Unknown role", role: "root", min: RoleUser, want: false},
+		{name: "Empty role", role: "", min: RoleUser, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, RoleAtLeast(tt.role, tt.min), tt.want)
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// RotateKeys 使用当前的主密钥重新包装最多batch条snippet的数据密钥 返回处理的数量 返回0时表示已经全部完成
+// 启用静态加密之前保存的明文会在这里加密
+// 每条snippet在单独的事务中处理 只锁住正在处理的行 轮换期间密钥环中同时保留新旧主密钥即可正常提供服务
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *SnippetModel) RotateKeys(batch int) (int, error) {
+	if m.Keyring == nil {
+		return 0, ErrNoKeyring
+	}
+	stmt := `SELECT id FROM snippets WHERE key_id IS NULL OR key_id <> ? ORDER BY id LIMIT ?`
+	rows, err := m.DB.Query(stmt, m.Keyring.CurrentID(), batch)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+	for i, id := range ids {
+		if err = m.rotateKey(id); err != nil {
+			return i, fmt.Errorf("models:rotate key of snippet %d: %w", id, err)
+		}
+	}
+	return len(ids), nil
+}
+
+// 处理一条snippet 已经被删除或者已经使用当前主密钥的直接跳过
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *SnippetModel) rotateKey(id int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var wrapped []byte
+	var keyID, content, language string
+	stmt := `SELECT data_key, COALESCE(key_id,''), content, language FROM snippets WHERE id = ? FOR UPDATE`
+	err = tx.QueryRow(stmt, id).Scan(&wrapped, &keyID, &content, &language)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	current := m.Keyring.CurrentID()
+	switch {
+	case keyID == current:
+		return nil
+	case wrapped != nil:
+		// 数据密钥不变 文件内容不需要重新加密
+		if wrapped, err = m.Keyring.rewrap(keyID, wrapped); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE snippets SET data_key = ?, key_id = ? WHERE id = ?`, wrapped, current, id)
+		if err != nil {
+			return err
+		}
+	default:
+		if err = m.sealPlaintext(tx, id, content, language); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// 加密启用静态加密之前保存的snippet 多文件功能之前的snippet将content写入为一个文件
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *SnippetModel) sealPlaintext(tx *sql.Tx, id int, content, language string) error {
+	dataKey, wrapped, err := m.Keyring.newDataKey()
+	if err != nil {
+		return err
+	}
+	rows, err := tx.Query(`SELECT id, name, content FROM snippet_files WHERE snippet_id = ? FOR UPDATE`, id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	plain := map[int]*File{}
+	for rows.Next() {
+		var fileID int
+		f := &File{}
+		if err = rows.Scan(&fileID, &f.Name, &f.Content); err != nil {
+			return err
+		}
+		plain[fileID] = f
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	if len(plain) == 0 {
+		files, err := sealFiles(dataKey, id, []*File{{Name: DefaultFileName(language), Language: language, Content: content}})
+		if err != nil {
+			return err
+		}
+		if err = insertFiles(tx, id, files); err != nil {
+			return err
+		}
+	}
+	for fileID, f := range plain {
+		sealed, err := sealContent(dataKey, f.Content, id, f.Name)
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec(`UPDATE snippet_files SET content = ? WHERE id = ?`, sealed, fileID); err != nil {
+			return err
+		}
+	}
+	// 与新建的snippet一致 没有指定SearchContent时不再保存用于搜索的明文
+	if !m.SearchContent {
+		content = ""
+	}
+	_, err = tx.Exec(`UPDATE snippets SET content = ?, data_key = ?, key_id = ? WHERE id = ?`, content, wrapped, m.Keyring.CurrentID(), id)
+	return err
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// 单次搜索最多返回的结果数
+const MaxSearchResults = 50
+
+// SearchQuery 搜索条件 为空值的字段不参与筛选
+type SearchQuery struct {
+	// 在标题与内容中进行全文搜索的关键字
+	Query    string
+	Language string
+	// 创建者的昵称
+	Author string
+	// 创建时间的范围 From(含)至To(不含)
+	From time.Time
+	To   time.Time
+}
+
+// Search 使用全文索引搜索未过期的公开snippet 按照相关度排序
+// 没有关键字时只按照筛选条件返回最新的结果
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *SnippetModel) Search(q SearchQuery) ([]*Snippet, error) {
+	var where []string
+	var args []any
+	// 已经过期 私有与被隐藏的snippet不应该出现在搜索结果里 加密的snippet中只有密文 同样不参与搜索
+	where = append(where, "s.expires > UTC_TIMESTAMP()", "NOT s.private", "s.hidden_reason = ''", "NOT s.encrypted")
+	order := "s.id DESC"
+	if q.Query != "" {
+		// 全文索引使用ngram解析器 中文关键字同样可以匹配
+		where = append(where, "MATCH(s.title,s.content) AGAINST(? IN NATURAL LANGUAGE MODE)")
+		args = append(args, q.Query)
+	}
+	if q.Language != "" {
+		where = append(where, "s.language = ?")
+		args = append(args, q.Language)
+	}
+	if q.Author != "" {
+		where = append(where, "u.name = ?")
+		args = append(args, q.Author)
+	}
+	if !q.From.IsZero() {
+		where = append(where, "s.created >= ?")
+		args = append(args, q.From.UTC())
+	}
+	if !q.To.IsZero() {
+		where = append(where, "s.created < ?")
+		args = append(args, q.To.UTC())
+	}
+	if q.Query != "" {
+		order = "MATCH(s.title,s.content) AGAINST(? IN NATURAL LANGUAGE MODE) DESC, " + order
+		args = append(args, q.Query)
+	}
+	args = append(args, MaxSearchResults)
+
+	stmt := `SELECT s.id,s.title,s.content,s.created,s.expires,COALESCE(s.user_id,0),s.language
+	FROM snippets s LEFT JOIN users u ON u.id = s.user_id
+	WHERE ` + strings.Join(where, " AND ") + `
+	ORDER BY ` + order + `
+	LIMIT ?`
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	snippets := []*Snippet{}
+	for rows.Next() {
+		s := &Snippet{}
+		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.UserID, &s.Language)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if err = m.attachTags(snippets); err != nil {
+		return nil, err
+	}
+	if err = m.attachStars(snippets); err != nil {
+		return nil, err
+	}
+	return snippets, nil
+}
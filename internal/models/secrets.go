@@ -0,0 +1,215 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 检测snippet内容中不小心粘贴的密钥 例如云服务的访问密钥 私钥与各种令牌
+// 正则匹配常见的格式 通用的赋值语句额外检查熵 排除changeme之类的占位符
+
+// RedactedSecret 隐去密钥后替换成的内容 已经隐去的内容不会再被检测到
+const RedactedSecret = "[REDACTED]"
+
+// SecretPattern 一种密钥的格式
+type SecretPattern struct {
+	Name string
+	// 包含名为secret的分组时只检查并隐去分组中的内容 否则为整个匹配
+	Regexp *regexp.Regexp
+	// 密钥的最低香农熵(bit/字符) 为0时不检查
+	MinEntropy float64
+}
+
+// DefaultSecretPatterns 默认检测的密钥格式 越具体的格式越靠前 重叠时以靠前的为准
+var DefaultSecretPatterns = []SecretPattern{
+	{Name: "private-key", Regexp: regexp.MustCompile(`-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY(?: BLOCK)?-----(?P<secret>[\s\S]+?)-----END (?:[A-Z0-9]+ )*PRIVATE KEY(?: BLOCK)?-----`)},
+	{Name: "aws-access-key-id", Regexp: regexp.MustCompile(`\b(?:AKIA|ASIA|AIDA|AROA|AGPA)[0-9A-Z]{16}\b`)},
+	{Name: "aws-secret-access-key", Regexp: regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?(?P<secret>[A-Za-z0-9/+=]{40})\b`), MinEntropy: 3.5},
+	{Name: "github-token", Regexp: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,255}|github_pat_[A-Za-z0-9_]{22,255})\b`)},
+	{Name: "slack-token", Regexp: regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`)},
+	{Name: "slack-webhook", Regexp: regexp.MustCompile(`https://hooks\.slack\.com/services/T[A-Za-z0-9_]+/B[A-Za-z0-9_]+/[A-Za-z0-9_]+`)},
+	{Name: "google-api-key", Regexp: regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}`)},
+	{Name: "stripe-secret-key", Regexp: regexp.MustCompile(`\b[rs]k_live_[0-9A-Za-z]{24,}\b`)},
+	{Name: "jwt", Regexp: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`)},
+	// 通用的赋值语句 例如password = "..." 或 API_KEY: ...
+	// 关键字可以带有前缀 例如DB_PASSWORD 值中不能包含括号与$ 排除函数调用与环境变量的引用
+	{Name: "generic-secret", Regexp: regexp.MustCompile(`(?i)(?:password|passwd|pwd|secret|token|api_?key|access_?key|client_?secret)["']?\s*[:=]\s*["']?(?P<secret>[^\s"'` + "`" + `,;(){}<>\[\]$]{12,})`), MinEntropy: 3.5},
+}
+
+// SecretFinding 检测到的一处密钥
+type SecretFinding struct {
+	// 匹配的格式名
+	Pattern string
+	// 密钥开始的行 从1开始
+	Line int
+	// 只保留前几个字符的密钥 可以直接展示
+	Masked string
+	// 密钥在内容中的位置
+	start, end int
+}
+
+// SecretScanner 按照一组格式检测内容中的密钥
+type SecretScanner struct {
+	patterns []SecretPattern
+}
+
+// NewSecretScanner 创建使用patterns的检测器
+func NewSecretScanner(patterns ...SecretPattern) *SecretScanner {
+	return &SecretScanner{patterns: patterns}
+}
+
+// Scan 返回content中检测到的密钥 按照出现的位置排序 重叠的匹配只保留一个
+func (s *SecretScanner) Scan(content string) []SecretFinding {
+	type match struct {
+		pattern    int
+		start, end int
+	}
+	var matches []match
+	for i, p := range s.patterns {
+		group := p.Regexp.SubexpIndex("secret")
+		for _, loc := range p.Regexp.FindAllStringSubmatchIndex(content, -1) {
+			start, end := loc[0], loc[1]
+			if group > 0 {
+				if loc[2*group] < 0 {
+					continue
+				}
+				start, end = loc[2*group], loc[2*group+1]
+			}
+			secret := strings.TrimSpace(content[start:end])
+			if secret == "" || secret == RedactedSecret {
+				continue
+			}
+			if p.MinEntropy > 0 && ShannonEntropy(secret) < p.MinEntropy {
+				continue
+			}
+			matches = append(matches, match{pattern: i, start: start, end: end})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].start != matches[j].start {
+			return matches[i].start < matches[j].start
+		}
+		return matches[i].pattern < matches[j].pattern
+	})
+	var findings []SecretFinding
+	for _, m := range matches {
+		// 与上一处重叠时跳过 同一位置开始的匹配中更具体的格式排在前面
+		if n := len(findings); n > 0 && m.start < findings[n-1].end {
+			continue
+		}
+		findings = append(findings, s.finding(content, m.pattern, m.start, m.end))
+	}
+	return findings
+}
+
+func (s *SecretScanner) finding(content string, pattern, start, end int) SecretFinding {
+	return SecretFinding{
+		Pattern: s.patterns[pattern].Name,
+		Line:    strings.Count(content[:start], "\n") + 1,
+		Masked:  maskSecret(strings.TrimSpace(content[start:end])),
+		start:   start,
+		end:     end,
+	}
+}
+
+// Redact 将content中检测到的密钥替换为RedactedSecret 返回替换后的内容与替换的数量
+// 密钥前后的空白会保留 私钥的BEGIN与END行不会被替换
+func (s *SecretScanner) Redact(content string) (string, int) {
+	findings := s.Scan(content)
+	if len(findings) == 0 {
+		return content, 0
+	}
+	var b strings.Builder
+	last := 0
+	for _, f := range findings {
+		secret := content[f.start:f.end]
+		trimmed := strings.TrimLeft(secret, " \t\r\n")
+		b.WriteString(content[last:f.start])
+		b.WriteString(secret[:len(secret)-len(trimmed)])
+		b.WriteString(RedactedSecret)
+		b.WriteString(trimmed[len(strings.TrimRight(trimmed, " \t\r\n")):])
+		last = f.end
+	}
+	b.WriteString(content[last:])
+	return b.String(), len(findings)
+}
+
+// 只展示前4个字符 较短的密钥全部隐去
+func maskSecret(secret string) string {
+	if len(secret) < 12 {
+		return "****"
+	}
+	return secret[:4] + "****"
+}
+
+// ShannonEntropy 返回s中每个字符的香农熵(bit) 随机生成的密钥通常在4以上 英文单词与占位符较低
+func ShannonEntropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	counts := map[rune]int{}
+	total := 0
+	for _, r := range s {
+		counts[r]++
+		total++
+	}
+	entropy := 0.0
+	for _, n := range counts {
+		p := float64(n) / float64(total)
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// ParseSecretPatterns 解析自定义的密钥格式 每行依次为格式名 最低熵与正则 以空白分隔
+// 正则中可以包含空白 空行与#开头的行会被忽略 例如:
+//
+//	internal-token 0 \bitk_[a-z0-9]{32}\b
+func ParseSecretPatterns(text string) ([]SecretPattern, error) {
+	var patterns []SecretPattern
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("models:line %d: want name, min entropy and regexp", i+1)
+		}
+		entropy, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || entropy < 0 {
+			return nil, fmt.Errorf("models:line %d: invalid min entropy %q", i+1, fields[1])
+		}
+		// 正则从第三个字段开始直到行尾
+		expr := strings.TrimSpace(line[len(fields[0]):])
+		expr = strings.TrimSpace(expr[len(fields[1]):])
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("models:line %d: %w", i+1, err)
+		}
+		patterns = append(patterns, SecretPattern{Name: fields[0], Regexp: re, MinEntropy: entropy})
+	}
+	return patterns, nil
+}
+
+// LoadSecretPatterns 读取path中的自定义格式 追加在默认格式之后 path为空时只使用默认格式
+func LoadSecretPatterns(path string) ([]SecretPattern, error) {
+	patterns := append([]SecretPattern(nil), DefaultSecretPatterns...)
+	if path == "" {
+		return patterns, nil
+	}
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	custom, err := ParseSecretPatterns(string(text))
+	if err != nil {
+		return nil, err
+	}
+	return append(patterns, custom...), nil
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"database/sql"
 	"errors"
+	"slices"
 	"time"
 )
 
@@ -13,30 +14,132 @@ type Snippet struct {
 	Content string
 	Created time.Time
 	Expires time.Time
+	// 创建者的id 匿名化之后为0
+	UserID int
+	// 内容使用的语言 用于搜索时进行筛选
+	Language string
+	// 按名称排序的标签
+	Tags []string
+	// snippet中的文件 只有Get与AllByUser会取出 Content为所有文件内容的合并
+	Files []*File
+	// 私有的snippet只有创建者可以查看 不会出现在列表 搜索与标签中
+	Private bool
+	// 分支来源的snippet 不是分支时为0
+	ParentID int
+	// 未过期的公开分支数量 只有Get会取出
+	ForkCount int
+	// 收藏数
+	Stars int
+	// 端到端加密的snippet 文件内容是浏览器加密后的密文 服务器无法读取
+	// 不会出现在搜索结果中 也不会渲染Markdown
+	Encrypted bool
+	// 被审核隐藏的原因(举报的原因之一) 为空表示没有隐藏
+	// 隐藏的snippet只有版主可以查看 不会出现在列表 搜索 标签与分支中
+	HiddenReason string
+}
+
+// 创建snippet时可以选择的语言
+var Languages = []string{
+	"text", "go", "python", "javascript", "typescript", "java", "c", "cpp", "rust",
+	"sql", "shell", "html", "css", "json", "yaml", "markdown",
+}
+
+// ValidLanguage 检查语言是否在可选列表中
+func ValidLanguage(language string) bool {
+	return PermittedValue(language, Languages...)
 }
 
 // SnippetModelInterface 定义接口用于解决模拟依赖注入时编译报错的问题
 type SnippetModelInterface interface {
-	Insert(title string, content string, expires int) (int, error)
+	Insert(title string, files []*File, expires time.Time, userID int, tags []string, private, encrypted bool) (int, error)
+	Fork(parentID int, title string, files []*File, expires time.Time, userID int, tags []string, private, encrypted bool) (int, error)
+	Forks(parentID int) ([]*Snippet, error)
 	Get(id int) (*Snippet, error)
 	Latest() ([]*Snippet, error)
+	Page(q PageQuery) (*SnippetPage, error)
+	AllByUser(userID int) ([]*Snippet, error)
+	Delete(id int) error
+	UpdateExpiry(id int, expires time.Time) error
+	SetHidden(id int, reason string) error
+	Search(q SearchQuery) ([]*Snippet, error)
+	TagCounts(limit int) ([]TagCount, error)
 }
 
 // 定义模型存储数据库链接,有点类似依赖注入,在这之后定义方法
 type SnippetModel struct {
 	DB *sql.DB
+	// 主密钥环 不为nil时文件内容使用信封加密后保存 为nil时保存明文
+	Keyring *Keyring
+	// 启用静态加密时仍然以明文保存content 搜索可以匹配内容并展示摘要
+	// 为false时content不保存 搜索只能匹配标题
+	SearchContent bool
 }
 
 // 都是直接返回错误由调用该函数的线程来处理错误 而不是在函数中直接处理错误
 
 // 插入新的snippet
-func (m *SnippetModel) Insert(title string, content string, expires int) (int, error) {
+// 第一个文件的语言作为snippet的语言 所有文件的内容合并后存入content用于搜索
+// encrypted为true时文件内容必须是ValidCiphertext格式的密文
+func (m *SnippetModel) Insert(title string, files []*File, expires time.Time, userID int, tags []string, private, encrypted bool) (int, error) {
+	// snippet 文件与标签在同一个事务中写入
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	id, err := m.insertSnippet(tx, title, files, expires, userID, tags, private, encrypted, 0)
+	if err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// 在事务中写入snippet及其文件与标签 parentID为0表示不是分支
+func (m *SnippetModel) insertSnippet(tx *sql.Tx, title string, files []*File, expires time.Time, userID int, tags []string, private, encrypted bool, parentID int) (int, error) {
+	if len(files) == 0 {
+		return 0, errors.New("models:snippet must contain at least one file")
+	}
+	// 不能让明文以加密的名义存入
+	if encrypted {
+		for _, f := range files {
+			if !ValidCiphertext(f.Content) {
+				return 0, ErrInvalidCiphertext
+			}
+		}
+	}
+	// 不是分支时parent_id存为NULL
+	var parent sql.NullInt64
+	if parentID != 0 {
+		parent = sql.NullInt64{Int64: int64(parentID), Valid: true}
+	}
+	// content只用于全文搜索与摘要 加密的snippet不保存
+	content := joinContent(files)
+	if encrypted {
+		content = ""
+	}
+	// 启用静态加密时文件内容使用新的数据密钥加密 除非指定了SearchContent content同样不保存 搜索只能匹配标题
+	// 加密需要snippet的id 在写入snippet之后再加密文件
+	var dataKey, wrappedKey []byte
+	var keyID sql.NullString
+	if m.Keyring != nil {
+		var err error
+		if dataKey, wrappedKey, err = m.Keyring.newDataKey(); err != nil {
+			return 0, err
+		}
+		keyID = sql.NullString{String: m.Keyring.CurrentID(), Valid: true}
+		if !m.SearchContent {
+			content = ""
+		}
+	}
 	// 使用占位符代替实际数据值
 	//goland:noinspection SqlNoDataSourceInspection
-	stmt := `INSERT INTO snippets(title,content,created,expires)
-	VALUES(?,?,UTC_TIMESTAMP(),DATE_ADD(UTC_TIMESTAMP,INTERVAL ? DAY))`
-	// 使用DB.Exec()执行SQL语句
-	res, err := m.DB.Exec(stmt, title, content, expires)
+	stmt := `INSERT INTO snippets(title,content,created,expires,user_id,language,private,parent_id,encrypted,data_key,key_id)
+	VALUES(?,?,UTC_TIMESTAMP(),?,?,?,?,?,?,?,?)`
+	// 使用Exec()执行SQL语句
+	res, err := tx.Exec(stmt, title, content, expires.UTC(), userID, files[0].Language, private, parent, encrypted, wrappedKey, keyID)
 	if err != nil {
 		return 0, err
 	}
@@ -45,6 +148,17 @@ func (m *SnippetModel) Insert(title string, content string, expires int) (int, e
 	if err != nil {
 		return 0, err
 	}
+	if dataKey != nil {
+		if files, err = sealFiles(dataKey, int(id), files); err != nil {
+			return 0, err
+		}
+	}
+	if err = insertFiles(tx, int(id), files); err != nil {
+		return 0, err
+	}
+	if err = insertTags(tx, int(id), tags); err != nil {
+		return 0, err
+	}
 	// id是int64 转换成int类型后正确返回
 	return int(id), nil
 }
@@ -54,8 +168,12 @@ func (m *SnippetModel) Insert(title string, content string, expires int) (int, e
 //goland:noinspection SqlNoDataSourceInspection
 func (m *SnippetModel) Get(id int) (*Snippet, error) {
 	// 创建查询表达式
-	stmt := `SELECT id,title,content,created,expires FROM snippets
-	WHERE expires > UTC_TIMESTAMP AND id = ?`
+	stmt := `SELECT s.id,s.title,s.content,s.created,s.expires,COALESCE(s.user_id,0),s.language,s.private,
+	COALESCE(s.parent_id,0),s.encrypted,
+	(SELECT COUNT(*) FROM snippets f WHERE f.parent_id = s.id AND f.expires > UTC_TIMESTAMP() AND NOT f.private AND f.hidden_reason = ''),
+	s.hidden_reason
+	FROM snippets s
+	WHERE s.expires > UTC_TIMESTAMP AND s.id = ?`
 	// 根据id获取当行的数据
 	// 传入语句与用户输入的数据 返回一个指向sql.row的指针 存储从数据库查询得到的数据
 	// 查询的语句与结果的提取是可以写到一起去的
@@ -64,7 +182,8 @@ func (m *SnippetModel) Get(id int) (*Snippet, error) {
 	s := &Snippet{}
 	// 使用row.Scan()将查询到的数据写入到准备好的结构体里
 	// 需要的参数是地址 要写入的参数个数必须与查询到的参数个数一致
-	err := row.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+	err := row.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.UserID, &s.Language, &s.Private,
+		&s.ParentID, &s.Encrypted, &s.ForkCount, &s.HiddenReason)
 	if err != nil {
 		// 先判断查询结果是不是空的 如果查询结果是空的会返回sql.ErrNoRows
 		// 使用errors.Is()进行判断 会自动解包判断err
@@ -73,6 +192,15 @@ func (m *SnippetModel) Get(id int) (*Snippet, error) {
 		}
 		return nil, err
 	}
+	if err = m.attachTags([]*Snippet{s}); err != nil {
+		return nil, err
+	}
+	if err = m.attachStars([]*Snippet{s}); err != nil {
+		return nil, err
+	}
+	if err = m.attachFiles([]*Snippet{s}); err != nil {
+		return nil, err
+	}
 	// 将查找到的数据返回
 	return s, nil
 }
@@ -82,8 +210,8 @@ func (m *SnippetModel) Get(id int) (*Snippet, error) {
 //goland:noinspection SqlNoDataSourceInspection
 func (m *SnippetModel) Latest() ([]*Snippet, error) {
 	// 通过查询语句限制返回的内容数目
-	stmt := `SELECT id,title,content,created,expires FROM snippets
-	WHERE expires > UTC_TIMESTAMP()
+	stmt := `SELECT id,title,content,created,expires,COALESCE(user_id,0),language FROM snippets
+	WHERE expires > UTC_TIMESTAMP() AND NOT private AND hidden_reason = ''
 	ORDER BY id DESC
 	LIMIT 10`
 	// 执行查询语句
@@ -98,7 +226,7 @@ func (m *SnippetModel) Latest() ([]*Snippet, error) {
 	for rows.Next() {
 		s := &Snippet{}
 		// 尝试提取数据
-		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.UserID, &s.Language)
 		if err != nil {
 			return nil, err
 		}
@@ -109,6 +237,185 @@ func (m *SnippetModel) Latest() ([]*Snippet, error) {
 	if err = rows.Err(); err != nil {
 		return nil, err
 	}
+	// 关闭数据流后再查询标签
+	rows.Close()
+	if err = m.attachTags(snippets); err != nil {
+		return nil, err
+	}
+	if err = m.attachStars(snippets); err != nil {
+		return nil, err
+	}
+	return snippets, nil
+}
 
+// 按照(created,id)从新到旧分页返回未过期的snippet
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *SnippetModel) Page(q PageQuery) (*SnippetPage, error) {
+	size := clampPageSize(q.Size)
+	// 指定标签时只返回带有该标签的snippet
+	from := "snippets s"
+	var args []any
+	if q.Tag != "" {
+		from += " JOIN snippet_tags st ON st.snippet_id = s.id JOIN tags t ON t.id = st.tag_id AND t.name = ?"
+		args = append(args, q.Tag)
+	}
+	where := "s.expires > UTC_TIMESTAMP() AND NOT s.private AND s.hidden_reason = ''"
+	order := "s.created DESC, s.id DESC"
+	switch {
+	case q.Before != nil:
+		// 向前翻页时按照从旧到新的顺序取出紧挨着游标的数据 之后再反转
+		where += " AND (s.created > ? OR (s.created = ? AND s.id > ?))"
+		order = "s.created ASC, s.id ASC"
+		args = append(args, q.Before.Created, q.Before.Created, q.Before.ID)
+	case q.After != nil:
+		where += " AND (s.created < ? OR (s.created = ? AND s.id < ?))"
+		args = append(args, q.After.Created, q.After.Created, q.After.ID)
+	}
+	// 多取一条用于判断在查询的方向上是否还有更多数据
+	args = append(args, size+1)
+	stmt := `SELECT s.id,s.title,s.content,s.created,s.expires,COALESCE(s.user_id,0),s.language
+	FROM ` + from + `
+	WHERE ` + where + `
+	ORDER BY ` + order + `
+	LIMIT ?`
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	snippets := []*Snippet{}
+	for rows.Next() {
+		s := &Snippet{}
+		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.UserID, &s.Language)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	more := len(snippets) > size
+	if more {
+		snippets = snippets[:size]
+	}
+	page := &SnippetPage{Snippets: snippets}
+	if q.Before != nil {
+		slices.Reverse(snippets)
+	}
+	if len(snippets) == 0 {
+		return page, nil
+	}
+	if err = m.attachTags(snippets); err != nil {
+		return nil, err
+	}
+	if err = m.attachStars(snippets); err != nil {
+		return nil, err
+	}
+	first, last := CursorOf(snippets[0]), CursorOf(snippets[len(snippets)-1])
+	switch {
+	case q.Before != nil:
+		// 从下一页翻回来 游标本身就说明后面还有数据
+		page.Next = last
+		if more {
+			page.Prev = first
+		}
+	case q.After != nil:
+		page.Prev = first
+		if more {
+			page.Next = last
+		}
+	default:
+		if more {
+			page.Next = last
+		}
+	}
+	return page, nil
+}
+
+// 返回指定用户创建的所有snippet(包括已经过期的) 用于导出个人数据
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *SnippetModel) AllByUser(userID int) ([]*Snippet, error) {
+	stmt := `SELECT id,title,content,created,expires,user_id,language,private,COALESCE(parent_id,0),encrypted FROM snippets
+	WHERE user_id = ?
+	ORDER BY id`
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	snippets := []*Snippet{}
+	for rows.Next() {
+		s := &Snippet{}
+		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.UserID, &s.Language, &s.Private, &s.ParentID, &s.Encrypted)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if err = m.attachTags(snippets); err != nil {
+		return nil, err
+	}
+	if err = m.attachFiles(snippets); err != nil {
+		return nil, err
+	}
 	return snippets, nil
 }
+
+// 删除指定的snippet及其文件 评论 收藏 访问统计与标签关联 不存在时返回ErrNoRecord
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *SnippetModel) Delete(id int) error {
+	stmt := `DELETE s, st, f, c, sr, v, rf FROM snippets s
+	LEFT JOIN snippet_tags st ON st.snippet_id = s.id
+	LEFT JOIN snippet_files f ON f.snippet_id = s.id
+	LEFT JOIN comments c ON c.snippet_id = s.id
+	LEFT JOIN stars sr ON sr.snippet_id = s.id
+	LEFT JOIN snippet_views v ON v.snippet_id = s.id
+	LEFT JOIN snippet_referrers rf ON rf.snippet_id = s.id
+	WHERE s.id = ?`
+	res, err := m.DB.Exec(stmt, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// 修改snippet的过期时间 只对未过期的snippet生效
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *SnippetModel) UpdateExpiry(id int, expires time.Time) error {
+	stmt := `UPDATE snippets SET expires = ? WHERE id = ? AND expires > UTC_TIMESTAMP()`
+	_, err := m.DB.Exec(stmt, expires.UTC(), id)
+	return err
+}
+
+// SetHidden 以reason隐藏snippet reason为空时恢复 不存在时返回ErrNoRecord
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *SnippetModel) SetHidden(id int, reason string) error {
+	var exists bool
+	err := m.DB.QueryRow(`SELECT EXISTS(SELECT true FROM snippets WHERE id = ?)`, id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNoRecord
+	}
+	_, err = m.DB.Exec(`UPDATE snippets SET hidden_reason = ? WHERE id = ?`, reason, id)
+	return err
+}
@@ -0,0 +1,293 @@
+package models
+
+import (
+	"SnippetBox.mikudayo.net/internal/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 只包含一个文件的snippet
+func oneFile(language, content string) []*File {
+	return []*File{{Name: DefaultFileName(language), Language: language, Content: content}}
+}
+
+func TestSnippetModelSearch(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models:skipping integration test...")
+	}
+	db := newTestDB(t)
+	m := SnippetModel{DB: db}
+	week := time.Now().AddDate(0, 0, 7)
+	// Miku(39)创建的两条snippet与一条已经过期的snippet
+	goID, err := m.Insert("甩葱歌", oneFile("go", "func main() { println(\"ievan polkka\") }"), week, 39, nil, false, false)
+	assert.NilError(t, err)
+	textID, err := m.Insert("World is Mine", oneFile("text", "世界第一的公主殿下"), week, 39, nil, false, false)
+	assert.NilError(t, err)
+	_, err = db.Exec(`INSERT INTO snippets(title,content,created,expires,user_id,language)
+	VALUES('polkka','expired polkka',UTC_TIMESTAMP(),DATE_SUB(UTC_TIMESTAMP(),INTERVAL 1 DAY),39,'go')`)
+	assert.NilError(t, err)
+
+	ids := func(snippets []*Snippet) []int {
+		out := []int{}
+		for _, s := range snippets {
+			out = append(out, s.ID)
+		}
+		return out
+	}
+	tests := []struct {
+		name string
+		q    SearchQuery
+		want []int
+	}{
+		{name: "Content", q: SearchQuery{Query: "polkka"}, want: []int{goID}},
+		{name: "Chinese", q: SearchQuery{Query: "公主"}, want: []int{textID}},
+		{name: "Language", q: SearchQuery{Language: "text"}, want: []int{textID}},
+		{name: "Author", q: SearchQuery{Author: "Miku"}, want: []int{textID, goID}},
+		{name: "Unknown author", q: SearchQuery{Author: "Luka"}, want: []int{}},
+		{name: "Date range", q: SearchQuery{Author: "Miku", To: time.Now().AddDate(0, 0, -1)}, want: []int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snippets, err := m.Search(tt.q)
+			assert.NilError(t, err)
+			got := ids(snippets)
+			assert.Equal(t, len(got), len(tt.want))
+			for i := range got {
+				assert.Equal(t, got[i], tt.want[i])
+			}
+		})
+	}
+}
+
+func TestSnippetModelSearchEncrypted(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models:skipping integration test...")
+	}
+	keyring, err := NewKeyring(testMasterKey(1))
+	assert.NilError(t, err)
+	week := time.Now().AddDate(0, 0, 7)
+
+	// 没有指定SearchContent时content不保存 只能通过标题找到
+	t.Run("Titles only", func(t *testing.T) {
+		m := SnippetModel{DB: newTestDB(t), Keyring: keyring}
+		id, err := m.Insert("甩葱歌", oneFile("go", "func main() { println(\"ievan polkka\") }"), week, 39, nil, false, false)
+		assert.NilError(t, err)
+		snippets, err := m.Search(SearchQuery{Query: "甩葱歌"})
+		assert.NilError(t, err)
+		assert.Equal(t, len(snippets), 1)
+		assert.Equal(t, snippets[0].ID, id)
+		assert.Equal(t, snippets[0].Content, "")
+		snippets, err = m.Search(SearchQuery{Query: "polkka"})
+		assert.NilError(t, err)
+		assert.Equal(t, len(snippets), 0)
+	})
+
+	// 指定SearchContent时content以明文保存 文件内容仍然加密
+	t.Run("Content", func(t *testing.T) {
+		db := newTestDB(t)
+		m := SnippetModel{DB: db, Keyring: keyring, SearchContent: true}
+		id, err := m.Insert("甩葱歌", oneFile("go", "func main() { println(\"ievan polkka\") }"), week, 39, nil, false, false)
+		assert.NilError(t, err)
+		snippets, err := m.Search(SearchQuery{Query: "polkka"})
+		assert.NilError(t, err)
+		assert.Equal(t, len(snippets), 1)
+		assert.Equal(t, snippets[0].ID, id)
+		var stored string
+		err = db.QueryRow(`SELECT content FROM snippet_files WHERE snippet_id = ?`, id).Scan(&stored)
+		assert.NilError(t, err)
+		assert.Equal(t, strings.Contains(stored, "polkka"), false)
+	})
+}
+
+func TestSnippetModelPage(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models:skipping integration test...")
+	}
+	db := newTestDB(t)
+	m := SnippetModel{DB: db}
+	week := time.Now().AddDate(0, 0, 7)
+	// 五条创建时间相同的snippet 顺序只能由id决定
+	var ids []int
+	for i := 0; i < 5; i++ {
+		id, err := m.Insert("miku", oneFile("text", "mikudayo"), week, 39, nil, false, false)
+		assert.NilError(t, err)
+		ids = append(ids, id)
+	}
+	// 从新到旧
+	want := []int{ids[4], ids[3], ids[2], ids[1], ids[0]}
+	check := func(t *testing.T, page *SnippetPage, want []int, hasPrev, hasNext bool) {
+		t.Helper()
+		assert.Equal(t, len(page.Snippets), len(want))
+		for i, s := range page.Snippets {
+			assert.Equal(t, s.ID, want[i])
+		}
+		assert.Equal(t, page.Prev != nil, hasPrev)
+		assert.Equal(t, page.Next != nil, hasNext)
+	}
+
+	first, err := m.Page(PageQuery{Size: 2})
+	assert.NilError(t, err)
+	check(t, first, want[0:2], false, true)
+
+	second, err := m.Page(PageQuery{After: first.Next, Size: 2})
+	assert.NilError(t, err)
+	check(t, second, want[2:4], true, true)
+
+	last, err := m.Page(PageQuery{After: second.Next, Size: 2})
+	assert.NilError(t, err)
+	check(t, last, want[4:], true, false)
+
+	back, err := m.Page(PageQuery{Before: second.Prev, Size: 2})
+	assert.NilError(t, err)
+	check(t, back, want[0:2], false, true)
+}
+
+func TestSnippetModelTags(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models:skipping integration test...")
+	}
+	db := newTestDB(t)
+	m := SnippetModel{DB: db}
+	week := time.Now().AddDate(0, 0, 7)
+	first, err := m.Insert("miku", oneFile("text", "mikudayo"), week, 39, []string{"k8s", "oncall"}, false, false)
+	assert.NilError(t, err)
+	second, err := m.Insert("luka", oneFile("sql", "lukadayo"), week, 39, []string{"k8s", "sql"}, false, false)
+	assert.NilError(t, err)
+
+	s, err := m.Get(first)
+	assert.NilError(t, err)
+	assert.Equal(t, strings.Join(s.Tags, ","), "k8s,oncall")
+
+	// 列表中的每一条都带有自己的标签
+	latest, err := m.Latest()
+	assert.NilError(t, err)
+	assert.Equal(t, len(latest), 2)
+	assert.Equal(t, strings.Join(latest[0].Tags, ","), "k8s,sql")
+	assert.Equal(t, strings.Join(latest[1].Tags, ","), "k8s,oncall")
+
+	page, err := m.Page(PageQuery{Tag: "sql"})
+	assert.NilError(t, err)
+	assert.Equal(t, len(page.Snippets), 1)
+	assert.Equal(t, page.Snippets[0].ID, second)
+
+	counts, err := m.TagCounts(10)
+	assert.NilError(t, err)
+	assert.Equal(t, len(counts), 3)
+	assert.Equal(t, counts[0], TagCount{Name: "k8s", Count: 2})
+
+	// 删除snippet时一并删除标签关联
+	assert.NilError(t, m.Delete(second))
+	counts, err = m.TagCounts(10)
+	assert.NilError(t, err)
+	assert.Equal(t, len(counts), 2)
+}
+
+func TestSnippetModelUpdateExpiry(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models:skipping integration test...")
+	}
+	db := newTestDB(t)
+	m := SnippetModel{DB: db}
+	id, err := m.Insert("miku", oneFile("text", "mikudayo"), time.Now().Add(time.Hour), 39, nil, false, false)
+	assert.NilError(t, err)
+
+	assert.NilError(t, m.UpdateExpiry(id, NeverExpire))
+	s, err := m.Get(id)
+	assert.NilError(t, err)
+	assert.Equal(t, s.Permanent(), true)
+
+	// 缩短到已经过去的时间后就无法再查询到
+	assert.NilError(t, m.UpdateExpiry(id, time.Now().Add(-time.Minute)))
+	_, err = m.Get(id)
+	assert.Equal(t, err, ErrNoRecord)
+}
+
+func TestSnippetModelFiles(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models:skipping integration test...")
+	}
+	db := newTestDB(t)
+	m := SnippetModel{DB: db}
+	week := time.Now().AddDate(0, 0, 7)
+	files := []*File{
+		{Name: "main.go", Language: "go", Content: "package main"},
+		{Name: "Dockerfile", Language: "text", Content: "FROM golang:1.24"},
+	}
+	id, err := m.Insert("miku", files, week, 39, nil, false, false)
+	assert.NilError(t, err)
+
+	s, err := m.Get(id)
+	assert.NilError(t, err)
+	assert.Equal(t, len(s.Files), 2)
+	// 文件按照创建时的顺序返回 第一个文件的语言作为snippet的语言
+	assert.Equal(t, *s.Files[0], *files[0])
+	assert.Equal(t, *s.Files[1], *files[1])
+	assert.Equal(t, s.Language, "go")
+	assert.Equal(t, s.Content, "package main\nFROM golang:1.24")
+	assert.Equal(t, s.File("Dockerfile").Content, "FROM golang:1.24")
+
+	// 没有文件时不能创建
+	_, err = m.Insert("miku", nil, week, 39, nil, false, false)
+	assert.Equal(t, err != nil, true)
+
+	// 多文件功能之前创建的snippet作为一个文件返回
+	res, err := db.Exec(`INSERT INTO snippets(title,content,created,expires,user_id,language)
+	VALUES('polkka','ievan polkka',UTC_TIMESTAMP(),DATE_ADD(UTC_TIMESTAMP(),INTERVAL 1 DAY),39,'python')`)
+	assert.NilError(t, err)
+	legacy, err := res.LastInsertId()
+	assert.NilError(t, err)
+	s, err = m.Get(int(legacy))
+	assert.NilError(t, err)
+	assert.Equal(t, len(s.Files), 1)
+	assert.Equal(t, s.Files[0].Name, "snippet.py")
+	assert.Equal(t, s.Files[0].Content, s.Content)
+
+	assert.NilError(t, m.Delete(id))
+	var n int
+	assert.NilError(t, db.QueryRow("SELECT COUNT(*) FROM snippet_files WHERE snippet_id = ?", id).Scan(&n))
+	assert.Equal(t, n, 0)
+}
+
+func TestSnippetModelFork(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models:skipping integration test...")
+	}
+	db := newTestDB(t)
+	m := SnippetModel{DB: db}
+	week := time.Now().AddDate(0, 0, 7)
+	parent, err := m.Insert("miku", oneFile("go", "mikudayo"), week, 39, nil, false, false)
+	assert.NilError(t, err)
+	secret, err := m.Insert("secret", oneFile("text", "mikusecret"), week, 39, nil, true, false)
+	assert.NilError(t, err)
+
+	// 其他用户可以为公开的snippet创建分支 私有的分支不计入数量
+	fork, err := m.Fork(parent, "miku fork", oneFile("go", "rindayo"), week, 2, nil, false, false)
+	assert.NilError(t, err)
+	_, err = m.Fork(parent, "miku private fork", oneFile("go", "rindayo"), week, 2, nil, true, false)
+	assert.NilError(t, err)
+	s, err := m.Get(fork)
+	assert.NilError(t, err)
+	assert.Equal(t, s.ParentID, parent)
+	s, err = m.Get(parent)
+	assert.NilError(t, err)
+	assert.Equal(t, s.ForkCount, 1)
+	forks, err := m.Forks(parent)
+	assert.NilError(t, err)
+	assert.Equal(t, len(forks), 1)
+	assert.Equal(t, forks[0].ID, fork)
+
+	// 私有snippet只有创建者可以创建分支 也不会出现在搜索结果中
+	_, err = m.Fork(secret, "secret", oneFile("text", "stolen"), week, 2, nil, false, false)
+	assert.Equal(t, err, ErrNoRecord)
+	_, err = m.Fork(secret, "secret", oneFile("text", "mikusecret"), week, 39, nil, true, false)
+	assert.NilError(t, err)
+	found, err := m.Search(SearchQuery{Query: "mikusecret"})
+	assert.NilError(t, err)
+	assert.Equal(t, len(found), 0)
+
+	// 已经过期的snippet不能创建分支
+	assert.NilError(t, m.UpdateExpiry(parent, time.Now().Add(-time.Minute)))
+	_, err = m.Fork(parent, "too late", oneFile("go", "mikudayo"), week, 2, nil, false, false)
+	assert.Equal(t, err, ErrNoRecord)
+}
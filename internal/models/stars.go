@@ -0,0 +1,129 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// StarModelInterface 定义接口用于解决模拟依赖注入时编译报错的问题
+type StarModelInterface interface {
+	Star(userID, snippetID int) error
+	Unstar(userID, snippetID int) error
+	Starred(userID, snippetID int) (bool, error)
+	Favorites(userID int) ([]*Snippet, error)
+	MostStarred(since time.Time, limit int) ([]*Snippet, error)
+}
+
+// 注入数据库依赖
+type StarModel struct {
+	DB *sql.DB
+}
+
+// Star 收藏snippet 已经收藏过时不做任何修改
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *StarModel) Star(userID, snippetID int) error {
+	stmt := `INSERT IGNORE INTO stars(user_id,snippet_id,created) VALUES(?,?,UTC_TIMESTAMP())`
+	_, err := m.DB.Exec(stmt, userID, snippetID)
+	return err
+}
+
+// Unstar 取消收藏 没有收藏过时同样返回nil
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *StarModel) Unstar(userID, snippetID int) error {
+	_, err := m.DB.Exec(`DELETE FROM stars WHERE user_id = ? AND snippet_id = ?`, userID, snippetID)
+	return err
+}
+
+// Starred 判断用户是否收藏了snippet
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *StarModel) Starred(userID, snippetID int) (bool, error) {
+	var exists bool
+	stmt := `SELECT EXISTS(SELECT true FROM stars WHERE user_id = ? AND snippet_id = ?)`
+	err := m.DB.QueryRow(stmt, userID, snippetID).Scan(&exists)
+	return exists, err
+}
+
+// Favorites 返回用户收藏的snippet 按收藏时间从新到旧排列
+// 已经过期的snippet与其他用户设为私有的snippet不会出现
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *StarModel) Favorites(userID int) ([]*Snippet, error) {
+	stmt := `SELECT s.id,s.title,s.content,s.created,s.expires,COALESCE(s.user_id,0),s.language,
+	(SELECT COUNT(*) FROM stars c WHERE c.snippet_id = s.id)
+	FROM stars st JOIN snippets s ON s.id = st.snippet_id
+	WHERE st.user_id = ? AND s.expires > UTC_TIMESTAMP() AND (NOT s.private OR s.user_id = st.user_id) AND s.hidden_reason = ''
+	ORDER BY st.created DESC, s.id DESC`
+	return m.query(stmt, userID)
+}
+
+// MostStarred 返回since之后收到收藏最多的公开snippet Stars为这段时间内的收藏数
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *StarModel) MostStarred(since time.Time, limit int) ([]*Snippet, error) {
+	stmt := `SELECT s.id,s.title,s.content,s.created,s.expires,COALESCE(s.user_id,0),s.language,COUNT(*) AS n
+	FROM stars st JOIN snippets s ON s.id = st.snippet_id
+	WHERE st.created >= ? AND s.expires > UTC_TIMESTAMP() AND NOT s.private AND s.hidden_reason = ''
+	GROUP BY s.id
+	ORDER BY n DESC, s.id DESC
+	LIMIT ?`
+	return m.query(stmt, since.UTC(), limit)
+}
+
+// 执行查询并读取snippet与收藏数
+func (m *StarModel) query(stmt string, args ...any) ([]*Snippet, error) {
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	snippets := []*Snippet{}
+	for rows.Next() {
+		s := &Snippet{}
+		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.UserID, &s.Language, &s.Stars)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return snippets, nil
+}
+
+// 一次查询取出所有snippet的收藏数
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *SnippetModel) attachStars(snippets []*Snippet) error {
+	if len(snippets) == 0 {
+		return nil
+	}
+	byID := make(map[int]*Snippet, len(snippets))
+	args := make([]any, 0, len(snippets))
+	for _, s := range snippets {
+		s.Stars = 0
+		if _, ok := byID[s.ID]; !ok {
+			byID[s.ID] = s
+			args = append(args, s.ID)
+		}
+	}
+	stmt := `SELECT snippet_id, COUNT(*) FROM stars
+	WHERE snippet_id IN (` + placeholders(len(args)) + `)
+	GROUP BY snippet_id`
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, n int
+		if err = rows.Scan(&id, &n); err != nil {
+			return err
+		}
+		byID[id].Stars = n
+	}
+	return rows.Err()
+}
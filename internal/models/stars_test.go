@@ -0,0 +1,53 @@
+package models
+
+import (
+	"SnippetBox.mikudayo.net/internal/assert"
+	"testing"
+	"time"
+)
+
+func TestStarModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models:skipping integration test...")
+	}
+	db := newTestDB(t)
+	snippets := SnippetModel{DB: db}
+	m := StarModel{DB: db}
+	week := time.Now().AddDate(0, 0, 7)
+	id, err := snippets.Insert("miku", oneFile("text", "mikudayo"), week, 39, nil, false, false)
+	assert.NilError(t, err)
+
+	// 重复收藏只记录一次
+	assert.NilError(t, m.Star(39, id))
+	assert.NilError(t, m.Star(39, id))
+	assert.NilError(t, m.Star(2, id))
+	starred, err := m.Starred(39, id)
+	assert.NilError(t, err)
+	assert.Equal(t, starred, true)
+	s, err := snippets.Get(id)
+	assert.NilError(t, err)
+	assert.Equal(t, s.Stars, 2)
+
+	favorites, err := m.Favorites(39)
+	assert.NilError(t, err)
+	assert.Equal(t, len(favorites), 1)
+	most, err := m.MostStarred(time.Now().Add(-time.Hour), 5)
+	assert.NilError(t, err)
+	assert.Equal(t, len(most), 1)
+	assert.Equal(t, most[0].Stars, 2)
+
+	assert.NilError(t, m.Unstar(2, id))
+	assert.NilError(t, m.Unstar(2, id))
+	starred, err = m.Starred(2, id)
+	assert.NilError(t, err)
+	assert.Equal(t, starred, false)
+
+	// 过期之后不再出现在收藏列表与排行中
+	assert.NilError(t, snippets.UpdateExpiry(id, time.Now().Add(-time.Minute)))
+	favorites, err = m.Favorites(39)
+	assert.NilError(t, err)
+	assert.Equal(t, len(favorites), 0)
+	most, err = m.MostStarred(time.Now().Add(-time.Hour), 5)
+	assert.NilError(t, err)
+	assert.Equal(t, len(most), 0)
+}
@@ -0,0 +1,163 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 标签的数量与长度限制
+const (
+	MaxTags      = 10
+	MaxTagLength = 32
+)
+
+var (
+	// 标签中包含不允许的字符或者过长
+	ErrInvalidTag = errors.New("models:invalid tag")
+	// 一条snippet的标签过多
+	ErrTooManyTags = errors.New("models:too many tags")
+)
+
+// TagCount 标签与使用它的未过期公开snippet数量 用于生成标签云
+type TagCount struct {
+	Name  string
+	Count int
+}
+
+// ParseTags 解析用户输入的标签 以逗号或空白分隔
+// 统一转换为小写并去掉开头的# 重复的标签只保留一个
+func ParseTags(input string) ([]string, error) {
+	fields := strings.FieldsFunc(input, func(r rune) bool {
+		return r == ',' || r == '，' || unicode.IsSpace(r)
+	})
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, f := range fields {
+		tag := strings.ToLower(strings.TrimLeft(f, "#"))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if !validTag(tag) {
+			return nil, ErrInvalidTag
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	if len(tags) > MaxTags {
+		return nil, ErrTooManyTags
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// 标签只能由字母(包括中文) 数字与-_.+组成
+func validTag(tag string) bool {
+	if utf8.RuneCountInString(tag) > MaxTagLength {
+		return false
+	}
+	for _, r := range tag {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("-_.+", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// 在事务中为snippet添加标签 标签不存在时先创建
+//
+//goland:noinspection SqlNoDataSourceInspection
+func insertTags(tx *sql.Tx, snippetID int, tags []string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	values := strings.TrimSuffix(strings.Repeat("(?),", len(tags)), ",")
+	args := make([]any, len(tags))
+	for i, t := range tags {
+		args[i] = t
+	}
+	_, err := tx.Exec(`INSERT IGNORE INTO tags(name) VALUES `+values, args...)
+	if err != nil {
+		return err
+	}
+	args = append([]any{snippetID}, args...)
+	stmt := `INSERT INTO snippet_tags(snippet_id,tag_id)
+	SELECT ?, id FROM tags WHERE name IN (` + placeholders(len(tags)) + `)`
+	_, err = tx.Exec(stmt, args...)
+	return err
+}
+
+// 一次查询取出所有snippet的标签 避免列表页面对每一条snippet单独查询(N+1)
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *SnippetModel) attachTags(snippets []*Snippet) error {
+	if len(snippets) == 0 {
+		return nil
+	}
+	byID := make(map[int]*Snippet, len(snippets))
+	args := make([]any, 0, len(snippets))
+	for _, s := range snippets {
+		s.Tags = []string{}
+		if _, ok := byID[s.ID]; !ok {
+			byID[s.ID] = s
+			args = append(args, s.ID)
+		}
+	}
+	stmt := `SELECT st.snippet_id, t.name FROM snippet_tags st
+	JOIN tags t ON t.id = st.tag_id
+	WHERE st.snippet_id IN (` + placeholders(len(args)) + `)
+	ORDER BY t.name`
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var name string
+		if err = rows.Scan(&id, &name); err != nil {
+			return err
+		}
+		byID[id].Tags = append(byID[id].Tags, name)
+	}
+	return rows.Err()
+}
+
+// TagCounts 返回使用最多的标签 按名称排序便于展示成标签云
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *SnippetModel) TagCounts(limit int) ([]TagCount, error) {
+	stmt := `SELECT t.name, COUNT(*) AS n FROM tags t
+	JOIN snippet_tags st ON st.tag_id = t.id
+	JOIN snippets s ON s.id = st.snippet_id
+	WHERE s.expires > UTC_TIMESTAMP() AND NOT s.private AND s.hidden_reason = ''
+	GROUP BY t.id, t.name
+	ORDER BY n DESC, t.name
+	LIMIT ?`
+	rows, err := m.DB.Query(stmt, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := []TagCount{}
+	for rows.Next() {
+		var c TagCount
+		if err = rows.Scan(&c.Name, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i].Name < counts[j].Name })
+	return counts, nil
+}
+
+// 生成n个以逗号分隔的占位符
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"SnippetBox.mikudayo.net/internal/assert"
+	"strings"
+	"testing"
+)
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr error
+	}{
+		{name: "Empty", input: "  ", want: []string{}},
+		{name: "Normalized", input: "#K8s, sql  oncall，SQL", want: []string{"k8s", "oncall", "sql"}},
+		{name: "Chinese", input: "值班 c++", want: []string{"c++", "值班"}},
+		{name: "Invalid character", input: "k8s a/b", wantErr: ErrInvalidTag},
+		{name: "Too long", input: strings.Repeat("a", MaxTagLength+1), wantErr: ErrInvalidTag},
+		{name: "Too many", input: "a b c d e f g h i j k", wantErr: ErrTooManyTags},
+		{name: "Duplicates count once", input: "a a a a a a a a a a a", want: []string{"a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tags, err := ParseTags(tt.input)
+			assert.Equal(t, err, tt.wantErr)
+			assert.Equal(t, strings.Join(tags, ","), strings.Join(tt.want, ","))
+		})
+	}
+}
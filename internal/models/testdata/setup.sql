@@ -3,19 +3,143 @@ CREATE TABLE snippets(
     title varchar(100) NOT NULL ,
     content TEXT NOT NULL ,
     created DATETIME NOT NULL ,
-    expires DATETIME NOT NULL
+    expires DATETIME NOT NULL ,
+    user_id INTEGER ,
+    language VARCHAR(32) NOT NULL DEFAULT 'text',
+    private BOOLEAN NOT NULL DEFAULT FALSE,
+    parent_id INTEGER,
+    encrypted BOOLEAN NOT NULL DEFAULT FALSE,
+    data_key VARBINARY(64),
+    key_id CHAR(16),
+    hidden_reason VARCHAR(32) NOT NULL DEFAULT ''
 );
-CREATE INDEX idx_snippets_created ON snippets(id);
+CREATE INDEX idx_snippets_created ON snippets(created, id);
+CREATE INDEX idx_snippets_user_id ON snippets(user_id);
+CREATE INDEX idx_snippets_parent_id ON snippets(parent_id);
+CREATE INDEX idx_snippets_key_id ON snippets(key_id);
+CREATE FULLTEXT INDEX idx_snippets_fulltext ON snippets(title, content) WITH PARSER ngram;
+
+CREATE TABLE comments(
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    snippet_id INTEGER NOT NULL ,
+    user_id INTEGER ,
+    file_name VARCHAR(255) NOT NULL DEFAULT '' ,
+    line INTEGER NOT NULL DEFAULT 0 ,
+    body TEXT NOT NULL ,
+    created DATETIME NOT NULL ,
+    updated DATETIME
+);
+CREATE INDEX idx_comments_snippet_id ON comments(snippet_id);
+CREATE INDEX idx_comments_user_id ON comments(user_id);
+
+CREATE TABLE stars(
+    user_id INTEGER NOT NULL ,
+    snippet_id INTEGER NOT NULL ,
+    created DATETIME NOT NULL ,
+    PRIMARY KEY (user_id, snippet_id)
+);
+CREATE INDEX idx_stars_snippet_id ON stars(snippet_id, created);
+
+CREATE TABLE snippet_views(
+    snippet_id INTEGER NOT NULL ,
+    day DATE NOT NULL ,
+    views INTEGER NOT NULL ,
+    PRIMARY KEY (snippet_id, day)
+);
+
+CREATE TABLE snippet_referrers(
+    snippet_id INTEGER NOT NULL ,
+    referrer VARCHAR(255) NOT NULL ,
+    views INTEGER NOT NULL ,
+    PRIMARY KEY (snippet_id, referrer)
+);
+
+CREATE TABLE snippet_files(
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    snippet_id INTEGER NOT NULL ,
+    position INTEGER NOT NULL ,
+    name VARCHAR(255) NOT NULL ,
+    language VARCHAR(32) NOT NULL ,
+    content MEDIUMTEXT NOT NULL
+);
+CREATE INDEX idx_snippet_files_snippet_id ON snippet_files(snippet_id, position);
+
+CREATE TABLE tags(
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    name VARCHAR(32) NOT NULL
+);
+ALTER TABLE tags ADD CONSTRAINT tags_uc_name UNIQUE (name);
+
+CREATE TABLE snippet_tags(
+    snippet_id INTEGER NOT NULL ,
+    tag_id INTEGER NOT NULL ,
+    PRIMARY KEY (snippet_id, tag_id)
+);
+CREATE INDEX idx_snippet_tags_tag_id ON snippet_tags(tag_id);
 
 CREATE TABLE users(
     id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
     name VARCHAR(255) NOT NULL ,
     email VARCHAR(255) NOT NULL ,
-    hashed_password CHAR(60) NOT NULL ,
-    created DATETIME NOT NULL
+    hashed_password VARCHAR(255) NOT NULL ,
+    created DATETIME NOT NULL ,
+    role VARCHAR(16) NOT NULL DEFAULT 'user' ,
+    disabled BOOLEAN NOT NULL DEFAULT FALSE ,
+    password_reset_required BOOLEAN NOT NULL DEFAULT FALSE ,
+    has_password BOOLEAN NOT NULL DEFAULT TRUE
 );
 ALTER TABLE  users ADD CONSTRAINT users_uc_email UNIQUE (email);
 
+CREATE TABLE user_identities(
+    issuer VARCHAR(255) NOT NULL ,
+    subject VARCHAR(255) NOT NULL ,
+    user_id INTEGER NOT NULL ,
+    created DATETIME NOT NULL ,
+    PRIMARY KEY (issuer, subject)
+);
+CREATE INDEX idx_user_identities_user_id ON user_identities(user_id);
+
+CREATE TABLE email_changes(
+    token_hash CHAR(64) NOT NULL PRIMARY KEY ,
+    user_id INTEGER NOT NULL ,
+    new_email VARCHAR(255) NOT NULL ,
+    expiry DATETIME NOT NULL
+);
+CREATE INDEX idx_email_changes_user_id ON email_changes(user_id);
+
+CREATE TABLE rate_limits(
+    bucket VARCHAR(255) NOT NULL PRIMARY KEY ,
+    tat DATETIME(6) NOT NULL
+);
+CREATE INDEX idx_rate_limits_tat ON rate_limits(tat);
+
+CREATE TABLE reports(
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    snippet_id INTEGER NOT NULL ,
+    user_id INTEGER ,
+    reason VARCHAR(32) NOT NULL ,
+    details TEXT NOT NULL ,
+    status VARCHAR(16) NOT NULL DEFAULT 'open' ,
+    created DATETIME NOT NULL ,
+    resolved DATETIME
+);
+ALTER TABLE reports ADD CONSTRAINT reports_uc_snippet_user UNIQUE (snippet_id, user_id);
+CREATE INDEX idx_reports_status ON reports(status);
+
+CREATE TABLE audit_events(
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user_id INTEGER NOT NULL ,
+    action VARCHAR(64) NOT NULL ,
+    ip VARCHAR(45) NOT NULL ,
+    user_agent VARCHAR(255) NOT NULL ,
+    request_id VARCHAR(64) NOT NULL DEFAULT '' ,
+    details TEXT NOT NULL ,
+    created DATETIME NOT NULL
+);
+CREATE INDEX idx_audit_events_user_id ON audit_events(user_id);
+CREATE INDEX idx_audit_events_action ON audit_events(action);
+CREATE INDEX idx_audit_events_created ON audit_events(created);
+
 INSERT INTO  users(id, name, email, hashed_password, created)
 VALUES (
         -- 指定首个插入的id(适配测试的逻辑)
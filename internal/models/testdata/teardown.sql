@@ -1,2 +1,14 @@
+DROP TABLE snippet_referrers;
+DROP TABLE snippet_views;
+DROP TABLE stars;
+DROP TABLE comments;
+DROP TABLE snippet_files;
+DROP TABLE snippet_tags;
+DROP TABLE tags;
+DROP TABLE user_identities;
+DROP TABLE email_changes;
+DROP TABLE reports;
+DROP TABLE audit_events;
+DROP TABLE rate_limits;
 DROP TABLE users;
 DROP TABLE snippets;
\ No newline at end of file
@@ -1,13 +1,16 @@
 package models
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"strings"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // 存储用户信息的结构体(与数据库中表的结构一致)
@@ -17,61 +20,106 @@ type User struct {
 	Email          string
 	HashedPassword []byte
 	Created        time.Time
+	Role           string
+	// 被停用的账号无法登入
+	Disabled bool
+	// 管理员要求该用户在下次访问时修改密码
+	PasswordResetRequired bool
+	// 通过外部身份即时创建的账号没有用户知道的密码 设置第一个密码时不需要验证原密码
+	HasPassword bool
 }
 
 // UserModelInterface 定义接口用于解决模拟依赖注入时编译报错的问题
 type UserModelInterface interface {
-	Insert(name, email, password string) error
+	Insert(name, email, password string) (int, error)
 	Authenticate(email, password string) (int, error)
 	Exists(id int) (bool, error)
-	GetName(id int) (string, error)
-	GetEmail(id int) (string, error)
-	GetJoinedTime(id int) (time.Time, error)
+	GetUser(id int) (*User, error)
+	UpdateName(id int, name string) error
+	RequestEmailChange(id int, newEmail string) (string, error)
+	ConfirmEmailChange(token string) (int, error)
 	UpdatePassword(currentPD, newPD string, id int) error
+	Delete(id int, password string, deleteSnippets bool) error
+	List(query string) ([]*User, error)
+	SetRole(id int, role string) error
+	SetDisabled(id int, disabled bool) error
+	RequirePasswordReset(id int) error
+	AuthenticateExternal(issuer, subject, email, name string, emailVerified bool) (int, error)
 }
 
 // 注入数据库依赖
 type UserModel struct {
 	DB *sql.DB
+	// 为nil时使用DefaultPasswordHasher
+	Hasher *PasswordHasher
 }
 
-// 在数据库中新建用户
-func (m *UserModel) Insert(name, email, password string) error {
-	// 从用户输入的密码生成哈希 使用2^12(4096)次迭代
-	// 这里哈希值的返回形式是字节 后续向数据库中进行插入要进行字符串的转换
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+func (m *UserModel) hasher() *PasswordHasher {
+	if m.Hasher == nil {
+		return DefaultPasswordHasher
+	}
+	return m.Hasher
+}
+
+// 检查密码与存储的哈希是否匹配 不匹配时返回ErrInvalidCredentials
+func (m *UserModel) checkPassword(hashedPassword []byte, password string) (needsRehash bool, err error) {
+	match, needsRehash, err := m.hasher().Verify(password, string(hashedPassword))
 	if err != nil {
-		return err
+		return false, err
+	}
+	if !match {
+		return false, ErrInvalidCredentials
+	}
+	return needsRehash, nil
+}
+
+// 在数据库中新建用户 返回新用户的id
+func (m *UserModel) Insert(name, email, password string) (int, error) {
+	// 从用户输入的密码生成哈希 算法与参数由Hasher决定
+	hashedPassword, err := m.hasher().Hash(password)
+	if err != nil {
+		return 0, err
 	}
 	// 尝试向数据库中插入新用户
 	stmt := `INSERT INTO users(name,email,hashed_password,created)
 	VALUES(?,?,?,UTC_TIMESTAMP())`
-	_, err = m.DB.Exec(stmt, name, email, string(hashedPassword))
+	res, err := m.DB.Exec(stmt, name, email, hashedPassword)
 	if err != nil {
 		// 对sql的报错进行特判
-		// 像先前特判从网页解码数据一样使用errors.AS()进行判断
-		var mySQLError *mysql.MySQLError
-		if errors.As(err, &mySQLError) {
-			// 如果是sql报的错
-			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_email") {
-				// 并且错误代码与索引匹配
-				// 返回自定义错误
-				return ErrDuplicateEmail
-			}
+		if isDuplicateEmail(err) {
+			// 返回自定义错误
+			return 0, ErrDuplicateEmail
 		}
-		return err
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+// 判断是否是违反了邮箱唯一约束的sql错误
+func isDuplicateEmail(err error) bool {
+	// 像先前特判从网页解码数据一样使用errors.AS()进行判断
+	var mySQLError *mysql.MySQLError
+	if errors.As(err, &mySQLError) {
+		// 错误代码与索引都匹配
+		return mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_email")
 	}
-	return nil
+	return false
 }
 
 // 检查是否存在该用户 如果存在就返回id
+// 邮箱存在但是密码错误或账号已被停用时同样返回id 用于在审计日志中记录失败的登入
 func (m *UserModel) Authenticate(email, password string) (int, error) {
 	// 定义变量用于从数据库中提取数据
 	var id int
 	var hashedPassword []byte
+	var disabled bool
 
-	stmt := `SELECT id,hashed_password FROM users WHERE email = ?`
-	err := m.DB.QueryRow(stmt, email).Scan(&id, &hashedPassword)
+	stmt := `SELECT id,hashed_password,disabled FROM users WHERE email = ?`
+	err := m.DB.QueryRow(stmt, email).Scan(&id, &hashedPassword, &disabled)
 	if err != nil {
 		// 判断是否为sql查询为空的错误
 		if errors.Is(err, sql.ErrNoRows) {
@@ -83,13 +131,20 @@ func (m *UserModel) Authenticate(email, password string) (int, error) {
 		}
 	}
 	// 确实存在这个邮箱 检查用户填写的密码哈希值与数据库中存储的是否一致
-	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+	needsRehash, err := m.checkPassword(hashedPassword, password)
 	if err != nil {
-		// 判断是否是哈希值不匹配的错误
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-			// 如果哈希值不匹配直接返回无效数据错误
-			return 0, ErrInvalidCredentials
-		} else {
+		if errors.Is(err, ErrInvalidCredentials) {
+			return id, err
+		}
+		return 0, err
+	}
+	// 密码正确但是账号已经被停用
+	if disabled {
+		return id, ErrAccountDisabled
+	}
+	// 存储的哈希使用了过时的算法或参数 趁着拿到明文密码时重新生成
+	if needsRehash {
+		if err = m.rehash(id, hashedPassword, password); err != nil {
 			return 0, err
 		}
 	}
@@ -97,6 +152,18 @@ func (m *UserModel) Authenticate(email, password string) (int, error) {
 	return id, nil
 }
 
+// 使用当前的算法与参数重新生成哈希
+// 条件中带上旧的哈希 避免覆盖同一时间通过修改密码写入的新哈希
+func (m *UserModel) rehash(id int, oldHash []byte, password string) error {
+	hashedPassword, err := m.hasher().Hash(password)
+	if err != nil {
+		return err
+	}
+	stmt := `UPDATE users SET hashed_password = ? WHERE id = ? AND hashed_password = ?`
+	_, err = m.DB.Exec(stmt, hashedPassword, id, string(oldHash))
+	return err
+}
+
 // 通过提供的id检查用户是否存在
 func (m *UserModel) Exists(id int) (bool, error) {
 	var exists bool
@@ -116,57 +183,36 @@ func (m *UserModel) Exists(id int) (bool, error) {
 
 //类型断言：如果你确定 users 实际上是 *UserModel 类型，可以使用类型断言来调用额外方法
 
-// 返回用户的账号名
-func (m *UserModel) GetName(id int) (string, error) {
-	var name string
-	stmt := `SELECT name FROM users WHERE id = ?`
-	err := m.DB.QueryRow(stmt, id).Scan(&name)
-	if err != nil {
-		// 查看是不是定制的错误 -> 人性化输出
-		if errors.Is(err, ErrNoRecord) {
-			return "", ErrNoRecord
-		}
-		return "", err
-	}
-	return name, nil
-}
-
-// 返回用户账号的创建时间
-func (m *UserModel) GetJoinedTime(id int) (time.Time, error) {
-	var joined time.Time
-	stmt := `SELECT created FROM users WHERE id = ?`
-	err := m.DB.QueryRow(stmt, id).Scan(&joined)
+// 一次查询返回用户的账号信息(昵称 邮箱 创建时间)
+func (m *UserModel) GetUser(id int) (*User, error) {
+	u := &User{}
+	stmt := `SELECT id,name,email,created,role,disabled,password_reset_required,has_password FROM users WHERE id = ?`
+	err := m.DB.QueryRow(stmt, id).Scan(&u.ID, &u.Name, &u.Email, &u.Created, &u.Role, &u.Disabled, &u.PasswordResetRequired, &u.HasPassword)
 	if err != nil {
-		// 查看是不是定制的错误 -> 人性化输出
-		if errors.Is(err, ErrNoRecord) {
-			return time.Time{}, ErrNoRecord
+		// 查询结果为空 -> 人性化输出
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
 		}
-		return time.Time{}, err
+		return nil, err
 	}
-	return joined, nil
+	return u, nil
 }
 
-// 返回用户的邮箱
-func (m *UserModel) GetEmail(id int) (string, error) {
-	var email string
-	stmt := `SELECT email FROM users WHERE id = ?`
-	err := m.DB.QueryRow(stmt, id).Scan(&email)
-	if err != nil {
-		// 查看是不是定制的错误 -> 人性化输出
-		if errors.Is(err, ErrNoRecord) {
-			return "", ErrNoRecord
-		}
-		return "", err
-	}
-	return email, nil
+// 修改用户的昵称
+func (m *UserModel) UpdateName(id int, name string) error {
+	stmt := `UPDATE users SET name = ? WHERE id = ?`
+	_, err := m.DB.Exec(stmt, name, id)
+	return err
 }
 
+// 修改密码 还没有设置过密码的账号(通过外部身份创建)忽略currentPD
 func (m *UserModel) UpdatePassword(currentPD, newPD string, id int) error {
 	// 直接用字节切片从数据库读取password
 	var password []byte
+	var hasPassword bool
 	// 查询当前用户的密码
-	stmt := `SELECT hashed_password FROM users WHERE id = ?`
-	err := m.DB.QueryRow(stmt, id).Scan(&password)
+	stmt := `SELECT hashed_password,has_password FROM users WHERE id = ?`
+	err := m.DB.QueryRow(stmt, id).Scan(&password, &hasPassword)
 	if err != nil {
 		if errors.Is(err, ErrNoRecord) {
 			return ErrNoRecord
@@ -174,25 +220,312 @@ func (m *UserModel) UpdatePassword(currentPD, newPD string, id int) error {
 		return err
 	}
 	// 将输入的原密码哈希并判断是否与查询到的一致
-	err = bcrypt.CompareHashAndPassword(password, []byte(currentPD))
-	if err != nil {
-		// 是否是哈希值不匹配
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-			return ErrInvalidCredentials
+	if hasPassword {
+		if _, err = m.checkPassword(password, currentPD); err != nil {
+			return err
 		}
-		return err
 	}
 	// 匹配成功
 	// 将输入的新密码进行哈希
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPD), 12)
+	hashedPassword, err := m.hasher().Hash(newPD)
 	if err != nil {
 		return err
 	}
 	// 更新数据库中的信息
-	stmt = `UPDATE users SET hashed_password = ? WHERE id = ?`
-	// 将哈希过的密码转换成字符串的形式存入
-	_, err = m.DB.Exec(stmt, string(hashedPassword), id)
+	// 修改密码后清除管理员设置的强制修改标记
+	stmt = `UPDATE users SET hashed_password = ?, password_reset_required = FALSE, has_password = TRUE WHERE id = ?`
+	_, err = m.DB.Exec(stmt, hashedPassword, id)
 
 	// 最后可以不用判断直接返回err
 	return err
 }
+
+// 注销用户账号 需要再次验证密码 deleteSnippets决定删除还是匿名化该用户创建的snippet
+func (m *UserModel) Delete(id int, password string, deleteSnippets bool) error {
+	var hashedPassword []byte
+	stmt := `SELECT hashed_password FROM users WHERE id = ?`
+	err := m.DB.QueryRow(stmt, id).Scan(&hashedPassword)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+	// 再次确认是用户本人在操作
+	if _, err = m.checkPassword(hashedPassword, password); err != nil {
+		return err
+	}
+	// 使用事务保证snippet的处理与用户的删除同时成功或同时失败
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	// 提交成功后再调用Rollback不会产生任何影响
+	defer tx.Rollback()
+
+	if deleteSnippets {
+		// 与审核队列中的删除一致 被删除的snippet的未处理举报标记为已删除
+		stmt = `UPDATE reports r JOIN snippets s ON s.id = r.snippet_id
+		SET r.status = ?, r.resolved = UTC_TIMESTAMP()
+		WHERE s.user_id = ? AND r.status = ?`
+		if _, err = tx.Exec(stmt, ReportDeleted, id, ReportOpen); err != nil {
+			return err
+		}
+		stmt = `DELETE s, st, f, c, sr, v, rf FROM snippets s
+		LEFT JOIN snippet_tags st ON st.snippet_id = s.id
+		LEFT JOIN snippet_files f ON f.snippet_id = s.id
+		LEFT JOIN comments c ON c.snippet_id = s.id
+		LEFT JOIN stars sr ON sr.snippet_id = s.id
+		LEFT JOIN snippet_views v ON v.snippet_id = s.id
+		LEFT JOIN snippet_referrers rf ON rf.snippet_id = s.id
+		WHERE s.user_id = ?`
+	} else {
+		// 匿名化:保留内容但是断开与用户的关联
+		stmt = `UPDATE snippets SET user_id = NULL WHERE user_id = ?`
+	}
+	if _, err = tx.Exec(stmt, id); err != nil {
+		return err
+	}
+	// 在其他snippet下的评论与snippet的处理方式一致
+	if deleteSnippets {
+		stmt = `DELETE FROM comments WHERE user_id = ?`
+	} else {
+		stmt = `UPDATE comments SET user_id = NULL WHERE user_id = ?`
+	}
+	if _, err = tx.Exec(stmt, id); err != nil {
+		return err
+	}
+	// 收藏只对用户本人有意义 直接删除
+	if _, err = tx.Exec(`DELETE FROM stars WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+	// 举报保留用于审核 但是不再关联举报者
+	if _, err = tx.Exec(`UPDATE reports SET user_id = NULL WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+	// 尚未确认的邮箱修改请求与关联的外部身份也一并删除
+	if _, err = tx.Exec(`DELETE FROM email_changes WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM user_identities WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM users WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// 邮箱修改确认链接的有效时长
+const emailChangeLifetime = 24 * time.Hour
+
+// 生成一个随机的token 返回明文(发送给用户)与哈希值(存入数据库)
+func newToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+// 数据库中只存储token的哈希值 即使数据泄露也无法直接使用
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// 发起修改邮箱的请求 返回需要发送到新邮箱的确认token
+func (m *UserModel) RequestEmailChange(id int, newEmail string) (string, error) {
+	// 新邮箱已经被其他账号使用时直接返回 不需要等到确认时才发现
+	var exists bool
+	stmt := `SELECT EXISTS(SELECT true FROM users WHERE email = ?)`
+	err := m.DB.QueryRow(stmt, newEmail).Scan(&exists)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		return "", ErrDuplicateEmail
+	}
+	token, hash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	// 每个用户同时只保留最新的一次请求
+	stmt = `DELETE FROM email_changes WHERE user_id = ?`
+	if _, err = m.DB.Exec(stmt, id); err != nil {
+		return "", err
+	}
+	stmt = `INSERT INTO email_changes(token_hash,user_id,new_email,expiry)
+	VALUES(?,?,?,DATE_ADD(UTC_TIMESTAMP(),INTERVAL ? SECOND))`
+	_, err = m.DB.Exec(stmt, hash, id, newEmail, int(emailChangeLifetime.Seconds()))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// 使用确认链接中的token完成邮箱的修改 返回被修改的用户id
+func (m *UserModel) ConfirmEmailChange(token string) (int, error) {
+	var id int
+	var newEmail string
+	stmt := `SELECT user_id,new_email FROM email_changes
+	WHERE token_hash = ? AND expiry > UTC_TIMESTAMP()`
+	err := m.DB.QueryRow(stmt, hashToken(token)).Scan(&id, &newEmail)
+	if err != nil {
+		// token不存在或者已经过期
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		}
+		return 0, err
+	}
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`UPDATE users SET email = ? WHERE id = ?`, newEmail, id)
+	if err != nil {
+		// 在等待确认期间新邮箱被其他账号注册了
+		if isDuplicateEmail(err) {
+			return 0, ErrDuplicateEmail
+		}
+		return 0, err
+	}
+	// token只能使用一次
+	_, err = tx.Exec(`DELETE FROM email_changes WHERE user_id = ?`, id)
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// 按昵称或邮箱搜索用户 query为空时返回最新注册的用户 最多返回50条
+func (m *UserModel) List(query string) ([]*User, error) {
+	stmt := `SELECT id,name,email,created,role,disabled,password_reset_required,has_password FROM users
+	WHERE ? = '' OR name LIKE ? OR email LIKE ?
+	ORDER BY id DESC
+	LIMIT 50`
+	// 转义LIKE中的通配符 防止用户输入的%与_被当作通配符
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query) + "%"
+	rows, err := m.DB.Query(stmt, query, pattern, pattern)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := []*User{}
+	for rows.Next() {
+		u := &User{}
+		err = rows.Scan(&u.ID, &u.Name, &u.Email, &u.Created, &u.Role, &u.Disabled, &u.PasswordResetRequired, &u.HasPassword)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// 修改用户的角色
+func (m *UserModel) SetRole(id int, role string) error {
+	stmt := `UPDATE users SET role = ? WHERE id = ?`
+	_, err := m.DB.Exec(stmt, role, id)
+	return err
+}
+
+// 停用或重新启用用户的账号
+func (m *UserModel) SetDisabled(id int, disabled bool) error {
+	stmt := `UPDATE users SET disabled = ? WHERE id = ?`
+	_, err := m.DB.Exec(stmt, disabled, id)
+	return err
+}
+
+// 要求用户在下次访问时修改密码
+func (m *UserModel) RequirePasswordReset(id int) error {
+	stmt := `UPDATE users SET password_reset_required = TRUE WHERE id = ?`
+	_, err := m.DB.Exec(stmt, id)
+	return err
+}
+
+// 通过邮箱修改用户的角色 只在创建首个管理员的命令中使用 所以没有加入接口
+func (m *UserModel) SetRoleByEmail(email, role string) error {
+	stmt := `UPDATE users SET role = ? WHERE email = ?`
+	_, err := m.DB.Exec(stmt, role, email)
+	return err
+}
+
+// 通过外部身份提供方(OIDC)登入 返回对应的用户id
+// 已关联的外部身份直接登入 否则通过已验证的邮箱关联已有账号 或者即时创建新账号
+func (m *UserModel) AuthenticateExternal(issuer, subject, email, name string, emailVerified bool) (int, error) {
+	var id int
+	var disabled bool
+	stmt := `SELECT u.id,u.disabled FROM user_identities i
+	JOIN users u ON u.id = i.user_id
+	WHERE i.issuer = ? AND i.subject = ?`
+	err := m.DB.QueryRow(stmt, issuer, subject).Scan(&id, &disabled)
+	if err == nil {
+		if disabled {
+			return 0, ErrAccountDisabled
+		}
+		return id, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	// 首次登入 只有经过身份提供方验证的邮箱才能用于关联或创建账号
+	if !emailVerified || email == "" {
+		return 0, ErrInvalidCredentials
+	}
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt = `SELECT id,disabled FROM users WHERE email = ?`
+	err = tx.QueryRow(stmt, email).Scan(&id, &disabled)
+	switch {
+	case err == nil:
+		if disabled {
+			return 0, ErrAccountDisabled
+		}
+	case errors.Is(err, sql.ErrNoRows):
+		// 即时创建账号 设置一个随机的密码 用户在设置自己的密码之前只能通过外部身份登入
+		password, _, err := newToken()
+		if err != nil {
+			return 0, err
+		}
+		hashedPassword, err := m.hasher().Hash(password)
+		if err != nil {
+			return 0, err
+		}
+		if name == "" {
+			name = email
+		}
+		stmt = `INSERT INTO users(name,email,hashed_password,created,has_password)
+		VALUES(?,?,?,UTC_TIMESTAMP(),FALSE)`
+		res, err := tx.Exec(stmt, name, email, hashedPassword)
+		if err != nil {
+			if isDuplicateEmail(err) {
+				return 0, ErrDuplicateEmail
+			}
+			return 0, err
+		}
+		newID, err := res.LastInsertId()
+		if err != nil {
+			return 0, err
+		}
+		id = int(newID)
+	default:
+		return 0, err
+	}
+
+	stmt = `INSERT INTO user_identities(issuer,subject,user_id,created)
+	VALUES(?,?,?,UTC_TIMESTAMP())`
+	if _, err = tx.Exec(stmt, issuer, subject, id); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
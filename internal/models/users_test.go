@@ -2,7 +2,10 @@ package models
 
 import (
 	"SnippetBox.mikudayo.net/internal/assert"
+	"database/sql"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestUserModelExists(t *testing.T) {
@@ -46,3 +49,125 @@ func TestUserModelExists(t *testing.T) {
 		})
 	}
 }
+
+func TestUserModelGetUser(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models:skipping integration test...")
+	}
+	db := newTestDB(t)
+	m := UserModel{DB: db}
+
+	user, err := m.GetUser(39)
+	assert.NilError(t, err)
+	assert.Equal(t, user.Name, "Miku")
+	assert.Equal(t, user.Email, "miku@vocaloid.com")
+
+	// 不存在的用户返回自定义错误
+	_, err = m.GetUser(93)
+	assert.Equal(t, err, ErrNoRecord)
+}
+
+func TestUserModelAuthenticateRehash(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models:skipping integration test...")
+	}
+	db := newTestDB(t)
+	// 先使用旧的bcrypt配置创建用户
+	old := &PasswordHasher{Algorithm: HashBcrypt, BcryptCost: 10}
+	m := UserModel{DB: db, Hasher: old}
+	_, err := m.Insert("Rin", "rin@vocaloid.com", "rindayo1227")
+	assert.NilError(t, err)
+
+	// 换成Argon2id后登入 存储的哈希应当被替换
+	m.Hasher = testHasher
+	id, err := m.Authenticate("rin@vocaloid.com", "rindayo1227")
+	assert.NilError(t, err)
+	var hashed string
+	err = db.QueryRow(`SELECT hashed_password FROM users WHERE id = ?`, id).Scan(&hashed)
+	assert.NilError(t, err)
+	assert.Equal(t, strings.HasPrefix(hashed, "$argon2id$"), true)
+
+	// 新的哈希仍然可以登入 并且不会再次重新生成
+	_, err = m.Authenticate("rin@vocaloid.com", "rindayo1227")
+	assert.NilError(t, err)
+	var again string
+	err = db.QueryRow(`SELECT hashed_password FROM users WHERE id = ?`, id).Scan(&again)
+	assert.NilError(t, err)
+	assert.Equal(t, again, hashed)
+
+	// 错误的密码不会触发重新生成
+	_, err = m.Authenticate("rin@vocaloid.com", "wrong")
+	assert.Equal(t, err, ErrInvalidCredentials)
+}
+
+func TestUserModelExternalPassword(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models:skipping integration test...")
+	}
+	db := newTestDB(t)
+	m := UserModel{DB: db}
+
+	// 通过外部身份即时创建的账号没有密码
+	id, err := m.AuthenticateExternal("https://idp.example.com", "gumi", "gumi@vocaloid.com", "Gumi", true)
+	assert.NilError(t, err)
+	user, err := m.GetUser(id)
+	assert.NilError(t, err)
+	assert.Equal(t, user.HasPassword, false)
+
+	// 设置第一个密码时不需要当前的密码
+	err = m.UpdatePassword("", "Negi-Ramen-0626", id)
+	assert.NilError(t, err)
+	user, err = m.GetUser(id)
+	assert.NilError(t, err)
+	assert.Equal(t, user.HasPassword, true)
+	_, err = m.Authenticate("gumi@vocaloid.com", "Negi-Ramen-0626")
+	assert.NilError(t, err)
+
+	// 之后修改密码需要验证当前的密码
+	err = m.UpdatePassword("", "Negi-Ramen-0627", id)
+	assert.Equal(t, err, ErrInvalidCredentials)
+
+	err = m.Delete(id, "Negi-Ramen-0626", true)
+	assert.NilError(t, err)
+	_, err = m.GetUser(id)
+	assert.Equal(t, err, ErrNoRecord)
+}
+
+func TestUserModelDeleteReports(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models:skipping integration test...")
+	}
+	db := newTestDB(t)
+	users := UserModel{DB: db}
+	snippets := SnippetModel{DB: db}
+	reports := ReportModel{DB: db}
+	week := time.Now().AddDate(0, 0, 7)
+
+	rinID, err := users.Insert("Rin", "rin@vocaloid.com", "rindayo1227")
+	assert.NilError(t, err)
+	lenID, err := users.Insert("Len", "len@vocaloid.com", "lendayo1227")
+	assert.NilError(t, err)
+	// Rin举报Len的snippet Len举报Rin的snippet
+	rinSnippet, err := snippets.Insert("rin", oneFile("text", "orange"), week, rinID, nil, false, false)
+	assert.NilError(t, err)
+	lenSnippet, err := snippets.Insert("len", oneFile("text", "banana"), week, lenID, nil, false, false)
+	assert.NilError(t, err)
+	_, err = reports.Insert(lenSnippet, rinID, ReportSpam, "")
+	assert.NilError(t, err)
+	_, err = reports.Insert(rinSnippet, lenID, ReportSpam, "")
+	assert.NilError(t, err)
+
+	// 注销Rin并删除Rin创建的snippet
+	err = users.Delete(rinID, "rindayo1227", true)
+	assert.NilError(t, err)
+	// Rin提交的举报不再关联到已经注销的账号
+	var reporter sql.NullInt64
+	err = db.QueryRow(`SELECT user_id FROM reports WHERE snippet_id = ?`, lenSnippet).Scan(&reporter)
+	assert.NilError(t, err)
+	assert.Equal(t, reporter.Valid, false)
+	// 被删除的snippet的举报不会留在审核队列中
+	var status string
+	err = db.QueryRow(`SELECT status FROM reports WHERE snippet_id = ?`, rinSnippet).Scan(&status)
+	assert.NilError(t, err)
+	assert.Equal(t, status, ReportDeleted)
+}
@@ -22,6 +22,11 @@ func (v *Validator) Valid() bool {
 	return len(v.FieldErrors) == 0 && len(v.NonFieldErrors) == 0
 }
 
+// Errors 返回字段错误与非字段错误 使用值接收者使以值的形式存储的表单同样可以取出错误
+func (v Validator) Errors() (map[string]string, []string) {
+	return v.FieldErrors, v.NonFieldErrors
+}
+
 // AddFieldError 向FieldErrors添加错误信息
 func (v *Validator) AddFieldError(key, message string) {
 	// 如果当前的map还没有初始化就初始化再加入进行操作防止panic
@@ -80,6 +85,7 @@ func (v *Validator) Matches(value string, rx *regexp.Regexp) bool {
 	return rx.MatchString(value)
 }
 
+// Confirms 检查两次输入的值是否一致
 func Confirms[T comparable](s1, s2 T) bool {
-	return s1 != s2
+	return s1 == s2
 }
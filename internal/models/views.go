@@ -0,0 +1,342 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// 访问统计的相关限制
+const (
+	// 同一个访客在这段时间内重复访问同一条snippet只计一次
+	ViewDedupWindow = 12 * time.Hour
+	// 缓冲中的记录超过这个数量时应该提前写入数据库
+	MaxPendingViews = 1000
+	// 缓冲中最多保存的记录数 数据库无法写入时超出的新记录被丢弃
+	maxPendingKeys = 10 * MaxPendingViews
+	// 连续写入失败这么多次后丢弃缓冲中的记录 防止数据库长时间不可用时一直重试
+	maxFlushFailures = 5
+	// 内存中最多记录的访客数 超过后清空重新计算
+	maxSeenVisitors = 100000
+	// 统计面板中展示的来源数
+	MaxReferrers = 10
+	// 来源只保存域名 超出列宽的部分截断
+	maxReferrerLength = 255
+)
+
+// View 一段时间内某条snippet在某一天(UTC)来自某个来源的访问次数 Referrer为空表示直接访问
+type View struct {
+	SnippetID int
+	Day       time.Time
+	Referrer  string
+	Count     int
+}
+
+// DailyViews 一天的访问次数
+type DailyViews struct {
+	Day   time.Time
+	Views int
+}
+
+// ReferrerCount 一个来源的访问次数
+type ReferrerCount struct {
+	Referrer string
+	Views    int
+}
+
+// ViewStats 展示给创建者的访问统计
+type ViewStats struct {
+	Total int
+	// 从旧到新 没有访问的日期同样包含在内
+	Daily     []DailyViews
+	Referrers []ReferrerCount
+}
+
+// MaxDaily 返回访问最多的一天的访问次数 用于按比例展示
+func (s *ViewStats) MaxDaily() int {
+	most := 0
+	for _, d := range s.Daily {
+		most = max(most, d.Views)
+	}
+	return most
+}
+
+// ViewModelInterface 定义接口用于解决模拟依赖注入时编译报错的问题
+type ViewModelInterface interface {
+	Record(views []View) error
+	Stats(snippetID int, days int, now time.Time) (*ViewStats, error)
+}
+
+// 注入数据库依赖
+type ViewModel struct {
+	DB *sql.DB
+}
+
+// Record 将一批访问记录累加到数据库中
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *ViewModel) Record(views []View) error {
+	if len(views) == 0 {
+		return nil
+	}
+	// 同一天与同一个来源的记录先在内存中合并 每张表只需要执行一条语句
+	daily := map[View]int{}
+	referrers := map[View]int{}
+	for _, v := range views {
+		daily[View{SnippetID: v.SnippetID, Day: v.Day}] += v.Count
+		if v.Referrer != "" {
+			referrers[View{SnippetID: v.SnippetID, Referrer: v.Referrer}] += v.Count
+		}
+	}
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	args := make([]any, 0, 3*len(daily))
+	for k, n := range daily {
+		args = append(args, k.SnippetID, k.Day, n)
+	}
+	stmt := `INSERT INTO snippet_views(snippet_id,day,views) VALUES ` + valueGroups(len(daily), 3) + `
+	ON DUPLICATE KEY UPDATE views = views + VALUES(views)`
+	if _, err = tx.Exec(stmt, args...); err != nil {
+		return err
+	}
+	if len(referrers) > 0 {
+		args = args[:0]
+		for k, n := range referrers {
+			args = append(args, k.SnippetID, k.Referrer, n)
+		}
+		stmt = `INSERT INTO snippet_referrers(snippet_id,referrer,views) VALUES ` + valueGroups(len(referrers), 3) + `
+		ON DUPLICATE KEY UPDATE views = views + VALUES(views)`
+		if _, err = tx.Exec(stmt, args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Stats 返回snippet的总访问次数 截止到now的最近days天每天的访问次数与访问最多的来源
+//
+//goland:noinspection SqlNoDataSourceInspection
+func (m *ViewModel) Stats(snippetID int, days int, now time.Time) (*ViewStats, error) {
+	stats := &ViewStats{Referrers: []ReferrerCount{}}
+	err := m.DB.QueryRow(`SELECT COALESCE(SUM(views),0) FROM snippet_views WHERE snippet_id = ?`, snippetID).Scan(&stats.Total)
+	if err != nil {
+		return nil, err
+	}
+	from := ViewDay(now).AddDate(0, 0, 1-days)
+	rows, err := m.DB.Query(`SELECT day, views FROM snippet_views WHERE snippet_id = ? AND day >= ?`, snippetID, from)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	byDay := map[time.Time]int{}
+	for rows.Next() {
+		var day time.Time
+		var n int
+		if err = rows.Scan(&day, &n); err != nil {
+			return nil, err
+		}
+		byDay[ViewDay(day)] = n
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	for i := 0; i < days; i++ {
+		day := from.AddDate(0, 0, i)
+		stats.Daily = append(stats.Daily, DailyViews{Day: day, Views: byDay[day]})
+	}
+
+	stmt := `SELECT referrer, views FROM snippet_referrers WHERE snippet_id = ?
+	ORDER BY views DESC, referrer
+	LIMIT ?`
+	rows, err = m.DB.Query(stmt, snippetID, MaxReferrers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c ReferrerCount
+		if err = rows.Scan(&c.Referrer, &c.Views); err != nil {
+			return nil, err
+		}
+		stats.Referrers = append(stats.Referrers, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// ViewDay 返回t所在的那一天(UTC)的零点
+func ViewDay(t time.Time) time.Time {
+	y, mo, d := t.UTC().Date()
+	return time.Date(y, mo, d, 0, 0, 0, 0, time.UTC)
+}
+
+// 生成n组每组size个占位符 例如(?,?),(?,?)
+func valueGroups(n, size int) string {
+	group := "(" + placeholders(size) + ")"
+	return strings.TrimSuffix(strings.Repeat(group+",", n), ",")
+}
+
+// 常见的爬虫 链接预览与命令行工具的User-Agent中包含的关键字
+var botAgents = []string{
+	"bot", "crawl", "spider", "slurp", "curl", "wget", "python-requests", "go-http-client",
+	"headless", "preview", "facebookexternalhit", "monitor",
+}
+
+// IsBot 根据User-Agent判断请求是否来自程序 没有User-Agent的请求同样视为程序
+func IsBot(userAgent string) bool {
+	ua := strings.ToLower(userAgent)
+	if strings.TrimSpace(ua) == "" {
+		return true
+	}
+	for _, b := range botAgents {
+		if strings.Contains(ua, b) {
+			return true
+		}
+	}
+	return false
+}
+
+// 缓冲中合并访问次数使用的键
+type viewKey struct {
+	snippetID int
+	day       time.Time
+	referrer  string
+}
+
+// 记录访客最近一次被计数的时间
+type seenKey struct {
+	visitor   string
+	snippetID int
+}
+
+// ViewBuffer 在内存中累计访问次数 由Flush批量写入数据库 处理请求时不需要写数据库
+// 进程退出时尚未写入的记录会丢失 最多损失一个写入周期的统计
+type ViewBuffer struct {
+	store   ViewModelInterface
+	mu      sync.Mutex
+	pending map[viewKey]int
+	seen    map[seenKey]time.Time
+	// 正在写入数据库的记录数 写入失败时会放回pending
+	flushing int
+	// 已经通知过提前写入 写入成功之前不再通知
+	flushRequested bool
+	// 连续写入失败的次数
+	failures int
+}
+
+// NewViewBuffer 创建写入store的缓冲
+func NewViewBuffer(store ViewModelInterface) *ViewBuffer {
+	return &ViewBuffer{
+		store:   store,
+		pending: map[viewKey]int{},
+		seen:    map[seenKey]time.Time{},
+	}
+}
+
+// Add 记录visitor对snippet的一次访问 同一个访客在ViewDedupWindow内的重复访问不计数
+// 返回是否计数 以及是否应该提前写入 缓冲达到MaxPendingViews时只通知一次 写入成功后才会再次通知
+// 缓冲已满(数据库一直无法写入)时新的记录被丢弃 不计数
+func (b *ViewBuffer) Add(snippetID int, visitor, referrer string, now time.Time) (counted, full bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sk := seenKey{visitor: visitor, snippetID: snippetID}
+	if last, ok := b.seen[sk]; ok && now.Sub(last) < ViewDedupWindow {
+		return false, false
+	}
+	key := viewKey{snippetID: snippetID, day: ViewDay(now), referrer: truncateBytes(referrer, maxReferrerLength)}
+	if _, ok := b.pending[key]; !ok && len(b.pending)+b.flushing >= maxPendingKeys {
+		return false, false
+	}
+	if len(b.seen) >= maxSeenVisitors {
+		b.pruneSeen(now)
+	}
+	b.seen[sk] = now
+	b.pending[key]++
+	if len(b.pending) >= MaxPendingViews && !b.flushRequested {
+		b.flushRequested = true
+		return true, true
+	}
+	return true, false
+}
+
+// 截断到最多n个字节 不会拆开多字节的字符
+func truncateBytes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// 删除已经超出去重时间的访客 仍然过多时全部清空
+// 需要持有锁
+func (b *ViewBuffer) pruneSeen(now time.Time) {
+	for k, t := range b.seen {
+		if now.Sub(t) >= ViewDedupWindow {
+			delete(b.seen, k)
+		}
+	}
+	if len(b.seen) >= maxSeenVisitors {
+		b.seen = map[seenKey]time.Time{}
+	}
+}
+
+// Flush 将缓冲中的访问次数写入数据库 写入失败时放回缓冲等待下一次写入
+// 连续失败maxFlushFailures次后丢弃这些记录
+func (b *ViewBuffer) Flush(now time.Time) error {
+	b.mu.Lock()
+	pending := b.pending
+	b.pending = map[viewKey]int{}
+	b.flushing += len(pending)
+	b.pruneSeen(now)
+	b.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	views := make([]View, 0, len(pending))
+	for k, n := range pending {
+		views = append(views, View{SnippetID: k.snippetID, Day: k.day, Referrer: k.referrer, Count: n})
+	}
+	// 写入数据库时不持有锁 不阻塞正在处理的请求
+	err := b.store.Record(views)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.flushing -= len(pending)
+	if err == nil {
+		b.failures = 0
+		b.flushRequested = false
+		return nil
+	}
+	b.failures++
+	if b.failures >= maxFlushFailures {
+		b.failures = 0
+		b.flushRequested = false
+		return fmt.Errorf("models: dropped %d buffered view records after %d failed writes: %w", len(pending), maxFlushFailures, err)
+	}
+	for k, n := range pending {
+		b.pending[k] += n
+	}
+	return err
+}
+
+// Pending 返回尚未写入数据库的访问次数
+func (b *ViewBuffer) Pending() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	total := 0
+	for _, n := range b.pending {
+		total += n
+	}
+	return total
+}
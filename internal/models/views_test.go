@@ -0,0 +1,185 @@
+package models
+
+import (
+	"SnippetBox.mikudayo.net/internal/assert"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIsBot(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		want      bool
+	}{
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:131.0) Gecko/20100101 Firefox/131.0", false},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148", false},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", true},
+		{"Mozilla/5.0 (compatible; bingbot/2.0)", true},
+		{"curl/8.5.0", true},
+		{"Go-http-client/1.1", true},
+		{"Mozilla/5.0 HeadlessChrome/130.0", true},
+		{"", true},
+		{"   ", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.userAgent, func(t *testing.T) {
+			assert.Equal(t, IsBot(tt.userAgent), tt.want)
+		})
+	}
+}
+
+// 记录写入的访问 fail不为nil时写入失败
+type fakeViewStore struct {
+	views []View
+	fail  error
+}
+
+func (s *fakeViewStore) Record(views []View) error {
+	if s.fail != nil {
+		return s.fail
+	}
+	s.views = append(s.views, views...)
+	return nil
+}
+
+func (s *fakeViewStore) Stats(snippetID int, days int, now time.Time) (*ViewStats, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestViewBuffer(t *testing.T) {
+	store := &fakeViewStore{}
+	b := NewViewBuffer(store)
+	now := time.Date(2024, 8, 31, 20, 0, 0, 0, time.UTC)
+
+	counted, _ := b.Add(39, "miku", "github.com", now)
+	assert.Equal(t, counted, true)
+	// 去重时间内重复访问不计数 其他访客与其他snippet照常计数
+	counted, _ = b.Add(39, "miku", "", now.Add(time.Hour))
+	assert.Equal(t, counted, false)
+	counted, _ = b.Add(39, "rin", "github.com", now.Add(time.Hour))
+	assert.Equal(t, counted, true)
+	counted, _ = b.Add(40, "miku", "", now.Add(time.Hour))
+	assert.Equal(t, counted, true)
+	assert.Equal(t, b.Pending(), 3)
+
+	// 写入失败时保留在缓冲中
+	store.fail = errors.New("mysql: connection refused")
+	assert.Equal(t, b.Flush(now.Add(time.Hour)) != nil, true)
+	assert.Equal(t, b.Pending(), 3)
+
+	store.fail = nil
+	assert.NilError(t, b.Flush(now.Add(time.Hour)))
+	assert.Equal(t, b.Pending(), 0)
+	// 同一天同一个来源的访问合并为一条记录
+	assert.Equal(t, len(store.views), 2)
+	for _, v := range store.views {
+		assert.Equal(t, v.Day, time.Date(2024, 8, 31, 0, 0, 0, 0, time.UTC))
+		switch v.SnippetID {
+		case 39:
+			assert.Equal(t, v.Referrer, "github.com")
+			assert.Equal(t, v.Count, 2)
+		case 40:
+			assert.Equal(t, v.Count, 1)
+		}
+	}
+	// 没有新的访问时不写入
+	assert.NilError(t, b.Flush(now.Add(time.Hour)))
+	assert.Equal(t, len(store.views), 2)
+
+	// 超出去重时间之后重新计数
+	counted, _ = b.Add(39, "miku", "", now.Add(ViewDedupWindow))
+	assert.Equal(t, counted, true)
+}
+
+func TestViewBufferFull(t *testing.T) {
+	b := NewViewBuffer(&fakeViewStore{})
+	now := time.Now()
+	full := false
+	for i := 1; i <= MaxPendingViews; i++ {
+		_, full = b.Add(i, "miku", "", now)
+		if i < MaxPendingViews {
+			assert.Equal(t, full, false)
+		}
+	}
+	assert.Equal(t, full, true)
+	// 写入之前只通知一次
+	_, full = b.Add(MaxPendingViews+1, "miku", "", now)
+	assert.Equal(t, full, false)
+	// 写入成功后再次达到上限时重新通知
+	assert.NilError(t, b.Flush(now))
+	for i := 1; i <= MaxPendingViews; i++ {
+		_, full = b.Add(i, "rin", "", now)
+	}
+	assert.Equal(t, full, true)
+}
+
+func TestViewBufferFailures(t *testing.T) {
+	store := &fakeViewStore{fail: errors.New("mysql: connection refused")}
+	b := NewViewBuffer(store)
+	now := time.Now()
+
+	// 缓冲已满时丢弃新的记录
+	for i := 1; i <= maxPendingKeys; i++ {
+		b.Add(i, "miku", "", now)
+	}
+	counted, _ := b.Add(maxPendingKeys+1, "miku", "", now)
+	assert.Equal(t, counted, false)
+	assert.Equal(t, b.Pending(), maxPendingKeys)
+	// 已经存在的记录仍然可以累加
+	counted, _ = b.Add(1, "rin", "", now)
+	assert.Equal(t, counted, true)
+
+	// 连续写入失败后丢弃缓冲中的记录
+	for i := 1; i < maxFlushFailures; i++ {
+		assert.Equal(t, b.Flush(now) != nil, true)
+		assert.Equal(t, b.Pending(), maxPendingKeys+1)
+	}
+	assert.Equal(t, b.Flush(now) != nil, true)
+	assert.Equal(t, b.Pending(), 0)
+}
+
+func TestTruncateBytes(t *testing.T) {
+	assert.Equal(t, truncateBytes("vocaloid.com", 8), "vocaloid")
+	assert.Equal(t, truncateBytes("初音.com", 7), "初音.")
+	// 不会拆开多字节的字符
+	assert.Equal(t, truncateBytes("初音.com", 5), "初")
+	assert.Equal(t, truncateBytes("初音", 2), "")
+}
+
+func TestViewModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models:skipping integration test...")
+	}
+	db := newTestDB(t)
+	m := ViewModel{DB: db}
+	now := time.Now()
+	today := ViewDay(now)
+	yesterday := today.AddDate(0, 0, -1)
+
+	assert.NilError(t, m.Record([]View{
+		{SnippetID: 39, Day: today, Referrer: "github.com", Count: 2},
+		{SnippetID: 39, Day: today, Count: 1},
+		{SnippetID: 39, Day: yesterday, Referrer: "example.com", Count: 4},
+		{SnippetID: 40, Day: today, Count: 9},
+	}))
+	// 再次写入时累加
+	assert.NilError(t, m.Record([]View{{SnippetID: 39, Day: today, Referrer: "github.com", Count: 3}}))
+
+	stats, err := m.Stats(39, 14, now)
+	assert.NilError(t, err)
+	assert.Equal(t, stats.Total, 10)
+	assert.Equal(t, len(stats.Daily), 14)
+	assert.Equal(t, stats.Daily[13].Day, today)
+	assert.Equal(t, stats.Daily[13].Views, 6)
+	assert.Equal(t, stats.Daily[12].Views, 4)
+	assert.Equal(t, stats.Daily[0].Views, 0)
+	assert.Equal(t, stats.MaxDaily(), 6)
+	assert.Equal(t, len(stats.Referrers), 2)
+	assert.Equal(t, stats.Referrers[0], ReferrerCount{Referrer: "github.com", Views: 5})
+
+	stats, err = m.Stats(2, 14, now)
+	assert.NilError(t, err)
+	assert.Equal(t, stats.Total, 0)
+	assert.Equal(t, len(stats.Referrers), 0)
+}
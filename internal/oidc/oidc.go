@@ -0,0 +1,329 @@
+package oidc
+
+// OpenID Connect 依赖方(relying party)的最小实现
+// 支持服务发现 授权码模式+PKCE 以及RS256签名的ID token验证
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ID token的签名 签发者 受众或有效期不正确
+	ErrInvalidToken = errors.New("oidc:invalid id token")
+	// 身份提供方返回了错误
+	ErrExchange = errors.New("oidc:token exchange failed")
+)
+
+// 允许身份提供方与本地时钟之间存在的误差
+const clockSkew = time.Minute
+
+// 从服务发现文档中读取的端点信息
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider 存储一个身份提供方的配置与公钥
+type Provider struct {
+	ClientID     string
+	ClientSecret string
+	// 用于请求身份提供方的客户端
+	HTTPClient *http.Client
+
+	endpoints discovery
+
+	mu   sync.Mutex
+	keys map[string]*rsa.PublicKey
+}
+
+// Claims ID token中与登入相关的字段
+type Claims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	Expiry        int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Name          string   `json:"name"`
+}
+
+// aud字段既可能是字符串也可能是字符串数组
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return err
+	}
+	*a = ss
+	return nil
+}
+
+// Discover 读取issuer的服务发现文档并创建Provider
+func Discover(ctx context.Context, client *http.Client, issuer, clientID, clientSecret string) (*Provider, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	wellKnown := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnown, nil)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Body.Close()
+	if rs.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc:discovery returned %s", rs.Status)
+	}
+	var d discovery
+	if err = json.NewDecoder(rs.Body).Decode(&d); err != nil {
+		return nil, err
+	}
+	// 规范要求发现文档中的issuer与请求使用的issuer完全一致
+	if d.Issuer != strings.TrimSuffix(issuer, "/") && d.Issuer != issuer {
+		return nil, fmt.Errorf("oidc:issuer mismatch, got %q", d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("oidc:discovery document is missing endpoints")
+	}
+	return &Provider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		HTTPClient:   client,
+		endpoints:    d,
+	}, nil
+}
+
+// Issuer 返回身份提供方的标识
+func (p *Provider) Issuer() string {
+	return p.endpoints.Issuer
+}
+
+// RandomString 生成用于state nonce与PKCE verifier的随机字符串
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CodeChallenge 根据verifier计算PKCE的S256 challenge
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL 返回将用户重定向到身份提供方的地址
+func (p *Provider) AuthCodeURL(redirectURL, state, nonce, verifier string) string {
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.ClientID)
+	v.Set("redirect_uri", redirectURL)
+	v.Set("scope", "openid email profile")
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", CodeChallenge(verifier))
+	v.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(p.endpoints.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.endpoints.AuthorizationEndpoint + sep + v.Encode()
+}
+
+// Exchange 使用授权码换取ID token 并验证签名与nonce后返回其中的声明
+func (p *Provider) Exchange(ctx context.Context, redirectURL, code, verifier, nonce string) (*Claims, error) {
+	v := url.Values{}
+	v.Set("grant_type", "authorization_code")
+	v.Set("code", code)
+	v.Set("redirect_uri", redirectURL)
+	v.Set("code_verifier", verifier)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoints.TokenEndpoint, strings.NewReader(v.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	rs, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(rs.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if rs.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s %s", ErrExchange, rs.Status, body)
+	}
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err = json.Unmarshal(body, &token); err != nil {
+		return nil, err
+	}
+	if token.IDToken == "" {
+		return nil, fmt.Errorf("%w: no id_token in response", ErrExchange)
+	}
+	return p.Verify(ctx, token.IDToken, nonce)
+}
+
+// Verify 验证ID token的签名 签发者 受众 有效期与nonce
+func (p *Provider) Verify(ctx context.Context, idToken, nonce string) (*Claims, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrInvalidToken
+	}
+	// 只接受RS256 拒绝none等算法
+	if header.Alg != "RS256" {
+		return nil, ErrInvalidToken
+	}
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err = rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig); err != nil {
+		return nil, ErrInvalidToken
+	}
+	var c Claims
+	if err = decodeSegment(parts[1], &c); err != nil {
+		return nil, ErrInvalidToken
+	}
+	now := time.Now()
+	switch {
+	case c.Issuer != p.endpoints.Issuer:
+		return nil, ErrInvalidToken
+	case !contains(c.Audience, p.ClientID):
+		return nil, ErrInvalidToken
+	case now.After(time.Unix(c.Expiry, 0).Add(clockSkew)):
+		return nil, ErrInvalidToken
+	case c.IssuedAt != 0 && now.Add(clockSkew).Before(time.Unix(c.IssuedAt, 0)):
+		return nil, ErrInvalidToken
+	case c.Nonce != nonce:
+		return nil, ErrInvalidToken
+	case c.Subject == "":
+		return nil, ErrInvalidToken
+	}
+	return &c, nil
+}
+
+// 返回kid对应的公钥 找不到时重新获取一次JWKS(身份提供方可能轮换了密钥)
+func (p *Provider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if k, ok := p.keys[kid]; ok {
+		return k, nil
+	}
+	keys, err := p.fetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.keys = keys
+	if k, ok := p.keys[kid]; ok {
+		return k, nil
+	}
+	return nil, ErrInvalidToken
+}
+
+// 获取身份提供方的JWKS 只保留RSA公钥
+func (p *Provider) fetchKeys(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.endpoints.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Body.Close()
+	if rs.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc:jwks returned %s", rs.Status)
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err = json.NewDecoder(rs.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
+
+// 解码JWT中base64url编码的JSON片段
+func decodeSegment(seg string, dst any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst)
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package oidc
+
+import (
+	"SnippetBox.mikudayo.net/internal/assert"
+	"SnippetBox.mikudayo.net/internal/oidc/oidctest"
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const redirectURL = "https://snippetbox.test/user/login/oidc/callback"
+
+// 在模拟身份提供方完成授权 返回回调地址中的授权码
+func authorize(t *testing.T, p *Provider, state, nonce, verifier string) string {
+	client := &http.Client{
+		// 不跟随重定向 直接读取回调地址
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	rs, err := client.Get(p.AuthCodeURL(redirectURL, state, nonce, verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.Body.Close()
+	assert.Equal(t, rs.StatusCode, http.StatusFound)
+	location, err := url.Parse(rs.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, location.Query().Get("state"), state)
+	return location.Query().Get("code")
+}
+
+func newTestProvider(t *testing.T) (*oidctest.IdP, *Provider) {
+	idp, err := oidctest.NewIdP("snippetbox", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(idp.Close)
+	p, err := Discover(context.Background(), nil, idp.URL, "snippetbox", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return idp, p
+}
+
+func TestExchange(t *testing.T) {
+	idp, p := newTestProvider(t)
+	idp.SetUser(oidctest.User{Subject: "01", Email: "miku@vocaloid.com", EmailVerified: true, Name: "Miku"})
+
+	t.Run("Valid code", func(t *testing.T) {
+		code := authorize(t, p, "state", "nonce", "verifier")
+		claims, err := p.Exchange(context.Background(), redirectURL, code, "verifier", "nonce")
+		assert.NilError(t, err)
+		assert.Equal(t, claims.Subject, "01")
+		assert.Equal(t, claims.Email, "miku@vocaloid.com")
+		assert.Equal(t, claims.EmailVerified, true)
+	})
+	t.Run("Wrong PKCE verifier", func(t *testing.T) {
+		code := authorize(t, p, "state", "nonce", "verifier")
+		_, err := p.Exchange(context.Background(), redirectURL, code, "another", "nonce")
+		assert.Equal(t, err != nil, true)
+	})
+	t.Run("Wrong nonce", func(t *testing.T) {
+		code := authorize(t, p, "state", "nonce", "verifier")
+		_, err := p.Exchange(context.Background(), redirectURL, code, "verifier", "another")
+		assert.Equal(t, err, ErrInvalidToken)
+	})
+	t.Run("Reused code", func(t *testing.T) {
+		code := authorize(t, p, "state", "nonce", "verifier")
+		_, err := p.Exchange(context.Background(), redirectURL, code, "verifier", "nonce")
+		assert.NilError(t, err)
+		_, err = p.Exchange(context.Background(), redirectURL, code, "verifier", "nonce")
+		assert.Equal(t, err != nil, true)
+	})
+}
+
+func TestVerify(t *testing.T) {
+	idp, p := newTestProvider(t)
+	now := time.Now()
+	valid := func() map[string]any {
+		return map[string]any{
+			"iss":   idp.URL,
+			"sub":   "01",
+			"aud":   "snippetbox",
+			"exp":   now.Add(time.Minute).Unix(),
+			"iat":   now.Unix(),
+			"nonce": "nonce",
+		}
+	}
+	tests := []struct {
+		name   string
+		modify func(c map[string]any)
+		valid  bool
+	}{
+		{name: "Valid", modify: func(c map[string]any) {}, valid: true},
+		{name: "Audience array", modify: func(c map[string]any) { c["aud"] = []string{"other", "snippetbox"} }, valid: true},
+		{name: "Wrong issuer", modify: func(c map[string]any) { c["iss"] = "https://evil.test" }},
+		{name: "Wrong audience", modify: func(c map[string]any) { c["aud"] = "other" }},
+		{name: "Expired", modify: func(c map[string]any) { c["exp"] = now.Add(-time.Hour).Unix() }},
+		{name: "Issued in future", modify: func(c map[string]any) { c["iat"] = now.Add(time.Hour).Unix() }},
+		{name: "Empty subject", modify: func(c map[string]any) { c["sub"] = "" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid()
+			tt.modify(c)
+			token, err := idp.Sign(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = p.Verify(context.Background(), token, "nonce")
+			assert.Equal(t, err == nil, tt.valid)
+		})
+	}
+	t.Run("Tampered payload", func(t *testing.T) {
+		token, err := idp.Sign(valid())
+		if err != nil {
+			t.Fatal(err)
+		}
+		other, err := idp.Sign(map[string]any{"iss": idp.URL, "sub": "02", "aud": "snippetbox", "exp": now.Add(time.Minute).Unix(), "nonce": "nonce"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 使用另一个token的payload搭配原来的签名
+		parts := strings.Split(token, ".")
+		otherParts := strings.Split(other, ".")
+		_, err = p.Verify(context.Background(), parts[0]+"."+otherParts[1]+"."+parts[2], "nonce")
+		assert.Equal(t, err, ErrInvalidToken)
+	})
+	t.Run("Algorithm none", func(t *testing.T) {
+		// {"alg":"none"}
+		_, err := p.Verify(context.Background(), "eyJhbGciOiJub25lIn0.e30.", "nonce")
+		assert.Equal(t, err, ErrInvalidToken)
+	})
+}
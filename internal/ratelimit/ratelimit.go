@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// 令牌桶限流 使用GCRA算法实现 每个桶只需要保存一个时间(理论到达时间 TAT)
+// 取出令牌时把TAT向后推一个间隔 TAT超出当前时间太多说明令牌已经用完
+// 只需要一个值就可以原子地更新 方便在数据库或Redis中实现共享的后端
+
+// Policy 一种限流策略 每隔Interval补充一个令牌 最多积累Burst个
+type Policy struct {
+	// 同一个用户或ip在不同策略下使用不同的桶
+	Name     string
+	Interval time.Duration
+	Burst    int
+}
+
+// PerMinute 每分钟n个请求 最多连续n个
+func PerMinute(name string, n int) Policy {
+	return Policy{Name: name, Interval: time.Minute / time.Duration(n), Burst: n}
+}
+
+// Take 按照上一次的TAT尝试取出一个令牌 tat为零值表示桶是满的
+// 成功时返回新的TAT 失败时返回需要等待的时间 此时TAT不变
+func (p Policy) Take(tat, now time.Time) (next time.Time, ok bool, retryAfter time.Duration) {
+	if tat.Before(now) {
+		tat = now
+	}
+	next = tat.Add(p.Interval)
+	// 最多允许TAT领先当前时间Burst个间隔
+	allowAt := next.Add(-p.Interval * time.Duration(p.Burst))
+	if now.Before(allowAt) {
+		return tat, false, allowAt.Sub(now)
+	}
+	return next, true, 0
+}
+
+// Store 保存每个桶的TAT 多个实例部署时使用共享的实现(例如数据库或Redis)
+type Store interface {
+	// Take 从key对应的桶中按照policy取出一个令牌 返回是否成功以及失败时需要等待的时间
+	Take(key string, policy Policy, now time.Time) (ok bool, retryAfter time.Duration, err error)
+	// Evict 删除已经回满的桶 回满的桶与不存在的桶等价
+	Evict(now time.Time) error
+}
+
+// DefaultMaxKeys MemoryStore默认最多保存的桶数
+const DefaultMaxKeys = 100000
+
+// MemoryStore 在内存中保存桶 只适用于单个实例
+type MemoryStore struct {
+	mu      sync.Mutex
+	tats    map[string]time.Time
+	maxKeys int
+}
+
+// NewMemoryStore 创建最多保存maxKeys个桶的MemoryStore
+func NewMemoryStore(maxKeys int) *MemoryStore {
+	return &MemoryStore{tats: map[string]time.Time{}, maxKeys: maxKeys}
+}
+
+func (s *MemoryStore) Take(key string, policy Policy, now time.Time) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	next, ok, retryAfter := policy.Take(s.tats[key], now)
+	if !ok {
+		return false, retryAfter, nil
+	}
+	if _, exists := s.tats[key]; !exists && len(s.tats) >= s.maxKeys {
+		s.evict(now)
+	}
+	s.tats[key] = next
+	return true, 0, nil
+}
+
+func (s *MemoryStore) Evict(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evict(now)
+	return nil
+}
+
+// 删除已经回满的桶 仍然过多时删除最接近回满(TAT最早)的十分之一 需要持有锁
+// 不会清空所有的桶 使用大量不同的key请求无法重置其他桶中已经消耗的令牌
+func (s *MemoryStore) evict(now time.Time) {
+	for k, tat := range s.tats {
+		if !tat.After(now) {
+			delete(s.tats, k)
+		}
+	}
+	if len(s.tats) < s.maxKeys {
+		return
+	}
+	type bucket struct {
+		key string
+		tat time.Time
+	}
+	buckets := make([]bucket, 0, len(s.tats))
+	for k, tat := range s.tats {
+		buckets = append(buckets, bucket{k, tat})
+	}
+	slices.SortFunc(buckets, func(a, b bucket) int { return a.tat.Compare(b.tat) })
+	n := len(buckets) - s.maxKeys*9/10
+	for _, b := range buckets[:n] {
+		delete(s.tats, b.key)
+	}
+}
+
+// Len 返回当前保存的桶数
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.tats)
+}
@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"SnippetBox.mikudayo.net/internal/assert"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPolicyTake(t *testing.T) {
+	p := Policy{Name: "test", Interval: time.Second, Burst: 3}
+	now := time.Date(2007, 8, 31, 0, 0, 0, 0, time.UTC)
+
+	// 桶是满的时候可以连续取出Burst个令牌
+	var tat time.Time
+	for i := 0; i < 3; i++ {
+		var ok bool
+		tat, ok, _ = p.Take(tat, now)
+		assert.Equal(t, ok, true)
+	}
+	next, ok, retryAfter := p.Take(tat, now)
+	assert.Equal(t, ok, false)
+	assert.Equal(t, retryAfter, time.Second)
+	assert.Equal(t, next, tat)
+
+	// 等待一个间隔后补充一个令牌
+	tat, ok, _ = p.Take(tat, now.Add(time.Second))
+	assert.Equal(t, ok, true)
+	_, ok, retryAfter = p.Take(tat, now.Add(time.Second))
+	assert.Equal(t, ok, false)
+	assert.Equal(t, retryAfter, time.Second)
+
+	// 很久之后桶回满 不会超过Burst个
+	tat, ok, _ = p.Take(tat, now.Add(time.Hour))
+	assert.Equal(t, ok, true)
+	assert.Equal(t, tat, now.Add(time.Hour+time.Second))
+}
+
+func TestPerMinute(t *testing.T) {
+	p := PerMinute("login", 10)
+	assert.Equal(t, p.Interval, 6*time.Second)
+	assert.Equal(t, p.Burst, 10)
+}
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore(2)
+	p := Policy{Name: "test", Interval: time.Minute, Burst: 1}
+	now := time.Date(2007, 8, 31, 0, 0, 0, 0, time.UTC)
+
+	ok, _, err := s.Take("miku", p, now)
+	assert.NilError(t, err)
+	assert.Equal(t, ok, true)
+	ok, retryAfter, err := s.Take("miku", p, now.Add(20*time.Second))
+	assert.NilError(t, err)
+	assert.Equal(t, ok, false)
+	assert.Equal(t, retryAfter, 40*time.Second)
+	// 不同的key使用不同的桶
+	ok, _, _ = s.Take("luka", p, now)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, s.Len(), 2)
+
+	// 超出数量时先删除已经回满的桶
+	ok, _, _ = s.Take("rin", p, now.Add(time.Minute))
+	assert.Equal(t, ok, true)
+	assert.Equal(t, s.Len(), 1)
+
+	assert.NilError(t, s.Evict(now.Add(2*time.Minute)))
+	assert.Equal(t, s.Len(), 0)
+}
+
+func TestMemoryStoreFull(t *testing.T) {
+	s := NewMemoryStore(10)
+	p := Policy{Name: "test", Interval: time.Minute, Burst: 1}
+	now := time.Date(2007, 8, 31, 0, 0, 0, 0, time.UTC)
+
+	// 攻击者的桶消耗得最多 TAT最晚
+	for i := 0; i < 5; i++ {
+		s.Take("attacker", Policy{Name: "test", Interval: time.Minute, Burst: 5}, now)
+	}
+	for i := 0; i < 9; i++ {
+		ok, _, _ := s.Take(fmt.Sprintf("ip-%d", i), p, now.Add(time.Duration(i)*time.Second))
+		assert.Equal(t, ok, true)
+	}
+	assert.Equal(t, s.Len(), 10)
+
+	// 使用新的key请求时只删除最接近回满的桶 其他桶不受影响
+	ok, _, _ := s.Take("ip-new", p, now.Add(10*time.Second))
+	assert.Equal(t, ok, true)
+	assert.Equal(t, s.Len(), 10)
+	ok, _, _ = s.Take("attacker", Policy{Name: "test", Interval: time.Minute, Burst: 5}, now.Add(10*time.Second))
+	assert.Equal(t, ok, false)
+	ok, _, _ = s.Take("ip-8", p, now.Add(10*time.Second))
+	assert.Equal(t, ok, false)
+	// 被删除的是TAT最早的ip-0
+	ok, _, _ = s.Take("ip-0", p, now.Add(10*time.Second))
+	assert.Equal(t, ok, true)
+}
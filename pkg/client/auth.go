@@ -0,0 +1,22 @@
+package client
+
+import (
+	"context"
+	"net/url"
+)
+
+// Login 使用邮箱与密码登入 会话保存在Cookie中
+// 邮箱或密码错误以及账号被停用时返回ValidationError
+func (c *Client) Login(ctx context.Context, email, password string) error {
+	form := url.Values{}
+	form.Set("email", email)
+	form.Set("password", password)
+	_, err := c.submit(ctx, "/user/login", form)
+	return err
+}
+
+// Logout 退出当前的会话
+func (c *Client) Logout(ctx context.Context) error {
+	_, err := c.submit(ctx, "/user/logout", nil)
+	return err
+}
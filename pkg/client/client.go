@@ -0,0 +1,255 @@
+// Package client 是SnippetBox的Go客户端 通过请求JSON的方式访问与网页相同的路由
+//
+// 路由的说明见服务器的/api/openapi.json 登入后的会话保存在http.Client的Cookie中
+// 提交表单需要的csrf_token由客户端自动从页面中获取
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Client 访问SnippetBox的客户端 可以在多个goroutine中使用
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+
+	mu sync.Mutex
+	// 最近一次从页面中获取的csrf_token 与Cookie中的token对应
+	csrfToken string
+}
+
+// New 创建访问baseURL的客户端 httpClient为nil时使用默认的设置
+// 会复制httpClient 没有Cookie Jar时创建一个 并且不自动跟随重定向
+func New(baseURL string, httpClient *http.Client) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("client: invalid base url %q", baseURL)
+	}
+	hc := &http.Client{}
+	if httpClient != nil {
+		copied := *httpClient
+		hc = &copied
+	}
+	if hc.Jar == nil {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return nil, err
+		}
+		hc.Jar = jar
+	}
+	// 提交表单成功时返回303 重定向的地址中包含结果(例如新的snippet的id)
+	hc.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &Client{baseURL: u, httpClient: hc}, nil
+}
+
+// Error 服务器返回的错误 对应RFC 9457中的问题详情
+type Error struct {
+	StatusCode int
+	Type       string
+	Title      string
+	Detail     string
+	Instance   string
+	// 与服务器日志中的请求id一致
+	RequestID string
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("client: %d %s", e.StatusCode, e.Title)
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	return msg
+}
+
+// ValidationError 提交的表单没有通过验证(422)
+type ValidationError struct {
+	// 字段名到错误信息的映射 字段名与表单中的name一致
+	Fields map[string]string
+	// 与字段无关的错误 例如邮箱或密码错误
+	NonField []string
+}
+
+func (e *ValidationError) Error() string {
+	var msgs []string
+	for field, msg := range e.Fields {
+		msgs = append(msgs, field+": "+msg)
+	}
+	msgs = append(msgs, e.NonField...)
+	return "client: validation failed: " + strings.Join(msgs, "; ")
+}
+
+// IsStatus 判断err是否是服务器返回的指定状态码的错误
+func IsStatus(err error, status int) bool {
+	var e *Error
+	return errors.As(err, &e) && e.StatusCode == status
+}
+
+// 页面的JSON 只包含客户端使用的字段
+type page struct {
+	Page          string       `json:"page"`
+	Flash         string       `json:"flash"`
+	Authenticated bool         `json:"authenticated"`
+	UserID        int          `json:"user_id"`
+	Role          string       `json:"role"`
+	CSRFToken     string       `json:"csrf_token"`
+	Snippet       *Snippet     `json:"snippet"`
+	Snippets      []Snippet    `json:"snippets"`
+	User          *User        `json:"user"`
+	Users         []User       `json:"users"`
+	NextPage      string       `json:"next_page"`
+	PrevPage      string       `json:"prev_page"`
+	AuditEvents   []AuditEvent `json:"audit_events"`
+	Form          *struct {
+		Errors         map[string]string `json:"errors"`
+		NonFieldErrors []string          `json:"non_field_errors"`
+	} `json:"form"`
+}
+
+// 以JSON请求页面 同时保存页面中的csrf_token
+func (c *Client) page(ctx context.Context, path string, query url.Values) (*page, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, path, query, nil)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Body.Close()
+	switch rs.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnprocessableEntity:
+		// 查询参数的错误与表单相同
+		return nil, c.readValidationError(rs)
+	default:
+		return nil, readError(rs)
+	}
+	p := &page{}
+	if err = json.NewDecoder(rs.Body).Decode(p); err != nil {
+		return nil, err
+	}
+	c.setCSRFToken(p.CSRFToken)
+	return p, nil
+}
+
+// 提交表单 成功时返回重定向的地址
+func (c *Client) submit(ctx context.Context, path string, form url.Values) (string, error) {
+	token, err := c.token(ctx)
+	if err != nil {
+		return "", err
+	}
+	if form == nil {
+		form = url.Values{}
+	}
+	form.Set("csrf_token", token)
+	req, err := c.newRequest(ctx, http.MethodPost, path, nil, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rs, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer rs.Body.Close()
+	switch rs.StatusCode {
+	case http.StatusSeeOther:
+		return rs.Header.Get("Location"), nil
+	case http.StatusUnprocessableEntity:
+		return "", c.readValidationError(rs)
+	}
+	return "", readError(rs)
+}
+
+// 读取表单验证失败时返回的页面
+func (c *Client) readValidationError(rs *http.Response) error {
+	p := &page{}
+	if err := json.NewDecoder(rs.Body).Decode(p); err != nil {
+		return err
+	}
+	c.setCSRFToken(p.CSRFToken)
+	verr := &ValidationError{Fields: map[string]string{}}
+	if p.Form != nil {
+		for k, v := range p.Form.Errors {
+			verr.Fields[k] = v
+		}
+		verr.NonField = p.Form.NonFieldErrors
+	}
+	return verr
+}
+
+// 返回提交表单使用的csrf_token 还没有时从关于页面中获取
+func (c *Client) token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	token := c.csrfToken
+	c.mu.Unlock()
+	if token != "" {
+		return token, nil
+	}
+	p, err := c.page(ctx, "/about", nil)
+	if err != nil {
+		return "", err
+	}
+	return p.CSRFToken, nil
+}
+
+func (c *Client) setCSRFToken(token string) {
+	if token == "" {
+		return
+	}
+	c.mu.Lock()
+	c.csrfToken = token
+	c.mu.Unlock()
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, query url.Values, body io.Reader) (*http.Request, error) {
+	u := c.baseURL.JoinPath(path)
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	return req, nil
+}
+
+// 读取错误的响应 不是问题详情时只使用状态码
+func readError(rs *http.Response) error {
+	e := &Error{StatusCode: rs.StatusCode, Type: "about:blank", Title: http.StatusText(rs.StatusCode)}
+	mediaType, _, _ := mime.ParseMediaType(rs.Header.Get("Content-Type"))
+	if mediaType == "application/problem+json" {
+		var problem struct {
+			Type      string `json:"type"`
+			Title     string `json:"title"`
+			Detail    string `json:"detail"`
+			Instance  string `json:"instance"`
+			RequestID string `json:"request_id"`
+		}
+		if err := json.NewDecoder(rs.Body).Decode(&problem); err == nil {
+			e.Type = problem.Type
+			e.Title = problem.Title
+			e.Detail = problem.Detail
+			e.Instance = problem.Instance
+			e.RequestID = problem.RequestID
+		}
+	}
+	if e.RequestID == "" {
+		e.RequestID = rs.Header.Get("X-Request-ID")
+	}
+	return e
+}
@@ -0,0 +1,174 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"SnippetBox.mikudayo.net/internal/assert"
+)
+
+func TestNew(t *testing.T) {
+	for _, baseURL := range []string{"", "localhost:3939", "/snippets", "http://%41"} {
+		_, err := New(baseURL, nil)
+		if err == nil {
+			t.Errorf("New(%q): want error", baseURL)
+		}
+	}
+	// 不会修改传入的http.Client
+	hc := &http.Client{}
+	c, err := New("https://localhost:3939/", hc)
+	assert.NilError(t, err)
+	assert.Equal(t, hc.Jar == nil, true)
+	assert.Equal(t, hc.CheckRedirect == nil, true)
+	assert.Equal(t, c.baseURL.String(), "https://localhost:3939")
+}
+
+func TestErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-ID", "39")
+		switch r.URL.Path {
+		case "/snippet/view/1":
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"type":"about:blank","title":"Not Found","status":404,"instance":"/snippet/view/1","request_id":"40"}`))
+		case "/search":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write([]byte(`{"page":"search","form":{"errors":{"to":"结束日期不能早于开始日期..."},"non_field_errors":[]}}`))
+		default:
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+	c, err := New(ts.URL, ts.Client())
+	assert.NilError(t, err)
+
+	t.Run("Problem", func(t *testing.T) {
+		_, err := c.GetSnippet(context.Background(), 1)
+		var e *Error
+		if !errors.As(err, &e) {
+			t.Fatalf("got %v; want *Error", err)
+		}
+		assert.Equal(t, e.StatusCode, http.StatusNotFound)
+		assert.Equal(t, e.Title, "Not Found")
+		assert.Equal(t, e.Instance, "/snippet/view/1")
+		assert.Equal(t, e.RequestID, "40")
+		assert.Equal(t, IsStatus(err, http.StatusNotFound), true)
+	})
+
+	t.Run("Plain text", func(t *testing.T) {
+		_, err := c.GetSnippet(context.Background(), 39)
+		assert.Equal(t, IsStatus(err, http.StatusInternalServerError), true)
+		assert.Equal(t, err.Error(), "client: 500 Internal Server Error")
+		var e *Error
+		errors.As(err, &e)
+		assert.Equal(t, e.RequestID, "39")
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		_, err := c.Search(context.Background(), SearchOptions{Query: "miku"})
+		var verr *ValidationError
+		if !errors.As(err, &verr) {
+			t.Fatalf("got %v; want *ValidationError", err)
+		}
+		assert.Equal(t, verr.Fields["to"], "结束日期不能早于开始日期...")
+		assert.Equal(t, len(verr.NonField), 0)
+	})
+}
+
+func TestSubmit(t *testing.T) {
+	var gotToken, gotAccept string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/about":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"page":"about","csrf_token":"mikudayo"}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/snippet/create":
+			gotToken = r.PostFormValue("csrf_token")
+			gotAccept = r.Header.Get("Accept")
+			if r.PostFormValue("title") == "" {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				w.Write([]byte(`{"page":"create","csrf_token":"rindayo","form":{"errors":{"title":"标题不能为空..."},"non_field_errors":[]}}`))
+				return
+			}
+			if r.PostFormValue("files[0].content") != "mikudayo" || r.PostFormValue("tags") != "k8s oncall" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			http.Redirect(w, r, "/snippet/view/39", http.StatusSeeOther)
+		case r.Method == http.MethodPost && r.URL.Path == "/user/logout":
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	c, err := New(ts.URL, ts.Client())
+	assert.NilError(t, err)
+	ctx := context.Background()
+
+	id, err := c.CreateSnippet(ctx, NewSnippet{
+		Title: "miku",
+		Files: []File{{Name: "main.go", Content: "mikudayo"}},
+		Tags:  []string{"k8s", "oncall"},
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, id, 39)
+	// 第一次提交前从页面中获取token
+	assert.Equal(t, gotToken, "mikudayo")
+	assert.Equal(t, gotAccept, "application/json")
+
+	_, err = c.CreateSnippet(ctx, NewSnippet{})
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("got %v; want *ValidationError", err)
+	}
+	assert.Equal(t, verr.Fields["title"], "标题不能为空...")
+	// 之后使用最近一次返回的token
+	err = c.Logout(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, c.csrfToken, "rindayo")
+
+	_, err = c.CreateSnippet(ctx, NewSnippet{Title: "miku", Files: []File{{Content: "rindayo"}}})
+	assert.Equal(t, IsStatus(err, http.StatusBadRequest), true)
+}
+
+func TestParsePageURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		want    *ListOptions
+		wantErr bool
+	}{
+		{"Empty", "", nil, false},
+		{"Next", "/snippets?after=abc", &ListOptions{After: "abc"}, false},
+		{"Prev with size", "/tag/k8s?before=abc&size=10", &ListOptions{Before: "abc", Size: 10}, false},
+		{"Invalid size", "/snippets?after=abc&size=miku", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePageURL(tt.url)
+			assert.Equal(t, err != nil, tt.wantErr)
+			if tt.want == nil {
+				assert.Equal(t, got == nil, true)
+				return
+			}
+			assert.Equal(t, *got, *tt.want)
+		})
+	}
+}
+
+func TestSnippetIDFromLocation(t *testing.T) {
+	id, err := snippetIDFromLocation("/snippet/view/39")
+	assert.NilError(t, err)
+	assert.Equal(t, id, 39)
+	for _, location := range []string{"", "/user/login", "/snippet/view/miku"} {
+		if _, err := snippetIDFromLocation(location); err == nil {
+			t.Errorf("snippetIDFromLocation(%q): want error", location)
+		}
+	}
+}
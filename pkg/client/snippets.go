@@ -0,0 +1,270 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Snippet 与服务器返回的JSON一致
+type Snippet struct {
+	ID      int       `json:"id"`
+	Title   string    `json:"title"`
+	Content string    `json:"content"`
+	Created time.Time `json:"created"`
+	// 永不过期时为nil
+	Expires  *time.Time `json:"expires"`
+	UserID   int        `json:"user_id"`
+	Language string     `json:"language"`
+	Tags     []string   `json:"tags"`
+	// 只有GetSnippet返回文件
+	Files     []File `json:"files"`
+	Private   bool   `json:"private"`
+	Encrypted bool   `json:"encrypted"`
+	ParentID  int    `json:"parent_id"`
+	ForkCount int    `json:"fork_count"`
+	Stars     int    `json:"stars"`
+	// 被审核隐藏的原因 只有版主可以看到被隐藏的snippet
+	HiddenReason string `json:"hidden_reason"`
+}
+
+// File snippet中的一个文件
+type File struct {
+	Name     string `json:"name"`
+	Language string `json:"language"`
+	Content  string `json:"content"`
+}
+
+// GetSnippet 获取一条snippet 被隐藏时返回状态码为410或451的错误
+func (c *Client) GetSnippet(ctx context.Context, id int) (*Snippet, error) {
+	p, err := c.page(ctx, fmt.Sprintf("/snippet/view/%d", id), nil)
+	if err != nil {
+		return nil, err
+	}
+	return p.Snippet, nil
+}
+
+// RawFile 以纯文本获取snippet中的一个文件
+func (c *Client) RawFile(ctx context.Context, id int, name string) (string, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, fmt.Sprintf("/snippet/raw/%d/%s", id, name), nil, nil)
+	if err != nil {
+		return "", err
+	}
+	rs, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer rs.Body.Close()
+	if rs.StatusCode != http.StatusOK {
+		return "", readError(rs)
+	}
+	body, err := io.ReadAll(rs.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// ListOptions 分页的游标与每页的数量 After与Before都为空时为第一页
+type ListOptions struct {
+	After  string
+	Before string
+	// 为0时使用服务器的默认值
+	Size int
+}
+
+func (o ListOptions) query() url.Values {
+	q := url.Values{}
+	if o.After != "" {
+		q.Set("after", o.After)
+	}
+	if o.Before != "" {
+		q.Set("before", o.Before)
+	}
+	if o.Size != 0 {
+		q.Set("size", strconv.Itoa(o.Size))
+	}
+	return q
+}
+
+// SnippetPage 一页snippet 没有下一页或上一页时Next或Prev为nil
+type SnippetPage struct {
+	Snippets []Snippet
+	Next     *ListOptions
+	Prev     *ListOptions
+}
+
+// ListSnippets 分页列出所有公开的snippet
+func (c *Client) ListSnippets(ctx context.Context, opts ListOptions) (*SnippetPage, error) {
+	return c.snippetPage(ctx, "/snippets", opts)
+}
+
+// ListByTag 分页列出带有标签的snippet
+func (c *Client) ListByTag(ctx context.Context, tag string, opts ListOptions) (*SnippetPage, error) {
+	return c.snippetPage(ctx, "/tag/"+tag, opts)
+}
+
+func (c *Client) snippetPage(ctx context.Context, path string, opts ListOptions) (*SnippetPage, error) {
+	p, err := c.page(ctx, path, opts.query())
+	if err != nil {
+		return nil, err
+	}
+	next, err := parsePageURL(p.NextPage)
+	if err != nil {
+		return nil, err
+	}
+	prev, err := parsePageURL(p.PrevPage)
+	if err != nil {
+		return nil, err
+	}
+	return &SnippetPage{Snippets: p.Snippets, Next: next, Prev: prev}, nil
+}
+
+// 从翻页地址中读取游标
+func parsePageURL(s string) (*ListOptions, error) {
+	if s == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	opts := &ListOptions{After: q.Get("after"), Before: q.Get("before")}
+	if size := q.Get("size"); size != "" {
+		opts.Size, err = strconv.Atoi(size)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return opts, nil
+}
+
+// SearchOptions 搜索的关键字与筛选条件 为零值的条件不进行筛选
+type SearchOptions struct {
+	Query    string
+	Language string
+	// 创建者的昵称
+	Author string
+	// 按照日期筛选 包含To当天
+	From time.Time
+	To   time.Time
+}
+
+// Search 搜索公开的snippet 条件不合法时返回ValidationError
+func (c *Client) Search(ctx context.Context, opts SearchOptions) ([]Snippet, error) {
+	q := url.Values{}
+	q.Set("q", opts.Query)
+	if opts.Language != "" {
+		q.Set("language", opts.Language)
+	}
+	if opts.Author != "" {
+		q.Set("author", opts.Author)
+	}
+	if !opts.From.IsZero() {
+		q.Set("from", opts.From.Format(time.DateOnly))
+	}
+	if !opts.To.IsZero() {
+		q.Set("to", opts.To.Format(time.DateOnly))
+	}
+	p, err := c.page(ctx, "/search", q)
+	if err != nil {
+		return nil, err
+	}
+	return p.Snippets, nil
+}
+
+// Favorites 当前用户收藏的snippet
+func (c *Client) Favorites(ctx context.Context) ([]Snippet, error) {
+	p, err := c.page(ctx, "/account/favorites", nil)
+	if err != nil {
+		return nil, err
+	}
+	return p.Snippets, nil
+}
+
+// NewSnippet 创建snippet的内容
+type NewSnippet struct {
+	Title string
+	// 至少需要一个文件 文件名与语言为空时由服务器推断
+	Files []File
+	Tags  []string
+	// 有效期 10m 1h 1d 1w 1mo 1y或never(需要服务器允许) 为空时为1y
+	Expires string
+	Private bool
+	// 创建分支时原snippet的id
+	Parent int
+	// 检测到密钥时仍然发布
+	AllowSecrets bool
+}
+
+// CreateSnippet 创建snippet 返回新的snippet的id
+func (c *Client) CreateSnippet(ctx context.Context, s NewSnippet) (int, error) {
+	form := url.Values{}
+	form.Set("title", s.Title)
+	for i, f := range s.Files {
+		form.Set(fmt.Sprintf("files[%d].name", i), f.Name)
+		form.Set(fmt.Sprintf("files[%d].language", i), f.Language)
+		form.Set(fmt.Sprintf("files[%d].content", i), f.Content)
+	}
+	form.Set("tags", strings.Join(s.Tags, " "))
+	expires := s.Expires
+	if expires == "" {
+		expires = "1y"
+	}
+	form.Set("expires", expires)
+	if s.Private {
+		form.Set("private", "true")
+	}
+	if s.Parent != 0 {
+		form.Set("parent", strconv.Itoa(s.Parent))
+	}
+	if s.AllowSecrets {
+		form.Set("allow_secrets", "true")
+	}
+	location, err := c.submit(ctx, "/snippet/create", form)
+	if err != nil {
+		return 0, err
+	}
+	return snippetIDFromLocation(location)
+}
+
+// 从重定向的地址中读取snippet的id /snippet/view/39
+func snippetIDFromLocation(location string) (int, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return 0, err
+	}
+	idStr, ok := strings.CutPrefix(u.Path, "/snippet/view/")
+	if !ok {
+		return 0, fmt.Errorf("client: unexpected redirect to %q", location)
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return 0, fmt.Errorf("client: unexpected redirect to %q", location)
+	}
+	return id, nil
+}
+
+// StarSnippet 收藏或取消收藏 重复提交的结果相同
+func (c *Client) StarSnippet(ctx context.Context, id int, star bool) error {
+	form := url.Values{}
+	form.Set("star", strconv.FormatBool(star))
+	_, err := c.submit(ctx, fmt.Sprintf("/snippet/star/%d", id), form)
+	return err
+}
+
+// ReportSnippet 举报snippet reason为spam abuse malware personal_data copyright或other
+// 重复举报与举报自己的snippet不会返回错误
+func (c *Client) ReportSnippet(ctx context.Context, id int, reason, details string) error {
+	form := url.Values{}
+	form.Set("reason", reason)
+	form.Set("details", details)
+	_, err := c.submit(ctx, fmt.Sprintf("/snippet/report/%d", id), form)
+	return err
+}
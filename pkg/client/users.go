@@ -0,0 +1,107 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// User 与服务器返回的JSON一致 不包含密码
+type User struct {
+	ID      int       `json:"id"`
+	Name    string    `json:"name"`
+	Email   string    `json:"email"`
+	Created time.Time `json:"created"`
+	// user moderator或admin
+	Role     string `json:"role"`
+	Disabled bool   `json:"disabled"`
+	// 管理员要求该用户修改密码 修改之前只能访问修改密码的页面
+	PasswordResetRequired bool `json:"password_reset_required"`
+	// 通过外部身份创建并且还没有设置过密码时为false
+	HasPassword bool `json:"password_set"`
+}
+
+// AuditEvent 一条安全记录
+type AuditEvent struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	Action    string    `json:"action"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	RequestID string    `json:"request_id"`
+	Details   string    `json:"details"`
+	Created   time.Time `json:"created"`
+}
+
+// CurrentUser 返回当前登入的用户 未登入时返回状态码为401的错误
+func (c *Client) CurrentUser(ctx context.Context) (*User, error) {
+	p, err := c.page(ctx, "/account/view", nil)
+	if err != nil {
+		return nil, err
+	}
+	return p.User, nil
+}
+
+// UpdateName 修改当前用户的昵称
+func (c *Client) UpdateName(ctx context.Context, name string) error {
+	form := url.Values{}
+	form.Set("name", name)
+	_, err := c.submit(ctx, "/account/name/update", form)
+	return err
+}
+
+// ChangePassword 修改当前用户的密码 还没有设置过密码(通过外部身份创建)的账号current为空
+func (c *Client) ChangePassword(ctx context.Context, current, password string) error {
+	form := url.Values{}
+	form.Set("currentPD", current)
+	form.Set("newPD", password)
+	form.Set("confirmPD", password)
+	_, err := c.submit(ctx, "/account/password/update", form)
+	return err
+}
+
+// SecurityEvents 当前用户最近的安全记录 新的在前
+func (c *Client) SecurityEvents(ctx context.Context) ([]AuditEvent, error) {
+	p, err := c.page(ctx, "/account/security", nil)
+	if err != nil {
+		return nil, err
+	}
+	return p.AuditEvents, nil
+}
+
+// ListUsers 按照昵称或邮箱搜索用户 需要管理员权限
+func (c *Client) ListUsers(ctx context.Context, query string) ([]User, error) {
+	q := url.Values{}
+	if query != "" {
+		q.Set("q", query)
+	}
+	p, err := c.page(ctx, "/admin/users", q)
+	if err != nil {
+		return nil, err
+	}
+	return p.Users, nil
+}
+
+// SetUserRole 修改用户的角色 需要管理员权限 不能修改自己的账号
+func (c *Client) SetUserRole(ctx context.Context, id int, role string) error {
+	form := url.Values{}
+	form.Set("role", role)
+	_, err := c.submit(ctx, fmt.Sprintf("/admin/users/%d/role", id), form)
+	return err
+}
+
+// SetUserDisabled 停用或重新启用用户的账号 需要管理员权限
+func (c *Client) SetUserDisabled(ctx context.Context, id int, disabled bool) error {
+	form := url.Values{}
+	form.Set("disabled", strconv.FormatBool(disabled))
+	_, err := c.submit(ctx, fmt.Sprintf("/admin/users/%d/disable", id), form)
+	return err
+}
+
+// RequirePasswordReset 要求用户在下次访问时修改密码 需要管理员权限
+func (c *Client) RequirePasswordReset(ctx context.Context, id int) error {
+	_, err := c.submit(ctx, fmt.Sprintf("/admin/users/%d/reset", id), nil)
+	return err
+}
@@ -0,0 +1,228 @@
+// 端到端加密的消息
+// 创建时在浏览器中使用随机的AES-GCM密钥加密每个文件的内容 服务器只保存密文
+// 密钥只放在链接的片段中(#key=...) 浏览器不会把片段发送到服务器
+// 密文格式与internal/models/encrypted.go一致: "e2e1:" + base64(12字节的IV + 密文与认证标签)
+"use strict";
+
+(function () {
+	var PREFIX = "e2e1:";
+	var IV_SIZE = 12;
+	// 与models.MaxContentBytes一致 按照明文计算
+	var MAX_CONTENT_BYTES = 64 * 1024;
+
+	var supported = !!(window.crypto && window.crypto.subtle && window.TextEncoder);
+
+	function toBase64(bytes) {
+		var s = "";
+		for (var i = 0; i < bytes.length; i++) {
+			s += String.fromCharCode(bytes[i]);
+		}
+		return btoa(s);
+	}
+
+	function fromBase64(s) {
+		var bin = atob(s);
+		var bytes = new Uint8Array(bin.length);
+		for (var i = 0; i < bin.length; i++) {
+			bytes[i] = bin.charCodeAt(i);
+		}
+		return bytes;
+	}
+
+	// 链接中的密钥使用base64url 不需要再进行转义
+	function toBase64URL(bytes) {
+		return toBase64(bytes).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+	}
+
+	function fromBase64URL(s) {
+		s = s.replace(/-/g, "+").replace(/_/g, "/");
+		while (s.length % 4 !== 0) {
+			s += "=";
+		}
+		return fromBase64(s);
+	}
+
+	async function encrypt(key, text) {
+		var iv = crypto.getRandomValues(new Uint8Array(IV_SIZE));
+		var ct = await crypto.subtle.encrypt({ name: "AES-GCM", iv: iv }, key, new TextEncoder().encode(text));
+		var out = new Uint8Array(IV_SIZE + ct.byteLength);
+		out.set(iv);
+		out.set(new Uint8Array(ct), IV_SIZE);
+		return PREFIX + toBase64(out);
+	}
+
+	async function decrypt(key, data) {
+		if (data.indexOf(PREFIX) !== 0) {
+			throw new Error("invalid ciphertext");
+		}
+		var bytes = fromBase64(data.slice(PREFIX.length));
+		var pt = await crypto.subtle.decrypt({ name: "AES-GCM", iv: bytes.slice(0, IV_SIZE) }, key, bytes.slice(IV_SIZE));
+		return new TextDecoder().decode(pt);
+	}
+
+	function showError(el, message) {
+		el.textContent = message;
+		el.hidden = false;
+	}
+
+	// 创建页面: 勾选加密后在提交前加密内容
+	function setupCreate(form) {
+		var checkbox = form.querySelector("input[name=encrypted]");
+		var hint = document.getElementById("encrypted-hint");
+		var errorEl = document.getElementById("encrypted-error");
+		if (!checkbox) {
+			return;
+		}
+		if (!supported) {
+			checkbox.disabled = true;
+			showError(errorEl, "当前浏览器不支持加密...");
+			return;
+		}
+
+		// 添加删除文件与预览会把明文提交到服务器 加密时禁用
+		function toggle() {
+			hint.hidden = !checkbox.checked;
+			var buttons = form.querySelectorAll("button[name=action]");
+			for (var i = 0; i < buttons.length; i++) {
+				buttons[i].disabled = checkbox.checked;
+			}
+		}
+		checkbox.addEventListener("change", toggle);
+		toggle();
+
+		form.addEventListener("submit", async function (event) {
+			if (!checkbox.checked) {
+				return;
+			}
+			event.preventDefault();
+			errorEl.hidden = true;
+			var textareas = form.querySelectorAll("textarea[name$='.content']");
+			var size = 0;
+			for (var i = 0; i < textareas.length; i++) {
+				if (textareas[i].value.trim() === "") {
+					showError(errorEl, "内容不能为空...");
+					return;
+				}
+				size += new TextEncoder().encode(textareas[i].value).length;
+			}
+			if (size > MAX_CONTENT_BYTES) {
+				showError(errorEl, "所有文件的内容合计不能超过64KB...");
+				return;
+			}
+			try {
+				var key = await crypto.subtle.generateKey({ name: "AES-GCM", length: 256 }, true, ["encrypt", "decrypt"]);
+				var data = new FormData(form);
+				for (var j = 0; j < textareas.length; j++) {
+					data.set(textareas[j].name, await encrypt(key, textareas[j].value));
+				}
+				var raw = new Uint8Array(await crypto.subtle.exportKey("raw", key));
+				// 创建成功后服务器重定向到消息页面
+				var rs = await fetch(form.action, {
+					method: "POST",
+					body: new URLSearchParams(data),
+					credentials: "same-origin",
+				});
+				if (rs.redirected && /\/snippet\/view\/\d+$/.test(new URL(rs.url).pathname)) {
+					window.location.assign(rs.url + "#key=" + toBase64URL(raw));
+					return;
+				}
+				// 验证失败时只展示错误信息 保留页面上的明文
+				var doc = new DOMParser().parseFromString(await rs.text(), "text/html");
+				var messages = [];
+				doc.querySelectorAll(".error").forEach(function (el) {
+					if (el.textContent.trim() !== "") {
+						messages.push(el.textContent.trim());
+					}
+				});
+				showError(errorEl, messages.length > 0 ? messages.join(" ") : "创建失败(" + rs.status + ")...");
+			} catch (err) {
+				showError(errorEl, "加密或提交失败: " + err.message);
+			}
+		});
+	}
+
+	// 读取链接中的密钥 之后跳转到评论等锚点时片段会被替换 所以在当前会话中保存一份
+	function snippetKey(id) {
+		var name = "snippetbox-key-" + id;
+		var match = /(?:^#|&)key=([A-Za-z0-9_-]+)/.exec(window.location.hash);
+		if (match) {
+			try {
+				sessionStorage.setItem(name, match[1]);
+			} catch (err) {
+				// 禁用存储时只能使用链接中的密钥
+			}
+			return match[1];
+		}
+		try {
+			return sessionStorage.getItem(name);
+		} catch (err) {
+			return null;
+		}
+	}
+
+	// 按行展示解密后的内容 与服务器端的codeLines生成相同的锚点
+	function renderLines(code, text) {
+		code.textContent = "";
+		var lines = text.split("\n");
+		for (var i = 0; i < lines.length; i++) {
+			var span = document.createElement("span");
+			span.className = "line";
+			span.id = "file-" + code.dataset.file + "-L" + (i + 1);
+			span.textContent = lines[i];
+			code.appendChild(span);
+			code.appendChild(document.createTextNode("\n"));
+		}
+	}
+
+	// 消息页面: 使用链接中的密钥解密
+	async function setupView(snippet, blocks) {
+		if (!supported) {
+			return;
+		}
+		var encoded = snippetKey(snippet.dataset.snippetId);
+		if (!encoded) {
+			blocks.forEach(function (code) {
+				code.textContent = "链接中没有密钥 无法解密...";
+			});
+			return;
+		}
+		var key;
+		try {
+			key = await crypto.subtle.importKey("raw", fromBase64URL(encoded), "AES-GCM", false, ["decrypt"]);
+		} catch (err) {
+			blocks.forEach(function (code) {
+				code.textContent = "链接中的密钥格式不正确...";
+			});
+			return;
+		}
+		for (var i = 0; i < blocks.length; i++) {
+			try {
+				renderLines(blocks[i], await decrypt(key, blocks[i].dataset.ciphertext));
+			} catch (err) {
+				blocks[i].textContent = "解密失败 链接中的密钥不正确...";
+			}
+		}
+		// 行的锚点在解密之后才存在
+		var target = window.location.hash.length > 1 && document.getElementById(window.location.hash.slice(1));
+		if (target) {
+			target.scrollIntoView();
+		}
+		var link = document.getElementById("encrypted-link");
+		if (link) {
+			link.value = window.location.origin + window.location.pathname + "#key=" + encoded;
+			link.parentElement.hidden = false;
+		}
+	}
+
+	document.addEventListener("DOMContentLoaded", function () {
+		var checkbox = document.querySelector("input[name=encrypted]");
+		if (checkbox && checkbox.form) {
+			setupCreate(checkbox.form);
+		}
+		var snippet = document.querySelector(".snippet[data-snippet-id]");
+		var blocks = Array.prototype.slice.call(document.querySelectorAll("code[data-ciphertext]"));
+		if (snippet && blocks.length > 0) {
+			setupView(snippet, blocks);
+		}
+	});
+})();